@@ -1,5 +1,70 @@
 package main
 
-// func main() {
-// 	SetupEnvironment()
-// }
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/caveda/qmoves-transit/lib"
+)
+
+const publishedLinesPath string = "./gen/alllines.json"
+
+const usage string = `Usage:
+  qmoves-transit                                 Fetch, digest and publish the transit data
+  qmoves-transit nearest <lat> <long> [k]        Stops nearest to the location
+  qmoves-transit within <lat> <long> [radius]    Stops within radius meters of the location
+  qmoves-transit serve <address>                 Serve the queries above over HTTP, e.g.
+                                                 /nearest?lat=43.2630&long=-2.9350&k=5`
+
+// runCommand runs a query over the stops published in publishedLinesPath.
+// The command and its number of arguments are checked before reading them.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "serve":
+		if len(args) != 2 {
+			return errors.New(usage)
+		}
+	case "nearest", "within":
+		if len(args) < 3 || len(args) > 4 {
+			return errors.New(usage)
+		}
+	default:
+		return fmt.Errorf("Unknown command %v\n%v", args[0], usage)
+	}
+
+	stops, err := transit.ReadPublishedStops(publishedLinesPath)
+	if err != nil {
+		return err
+	}
+	index := transit.NewStopIndex(stops, transit.DefaultIndexCellSize)
+	if args[0] == "serve" {
+		log.Printf("Serving queries of %v stops at %v", index.Len(), args[1])
+		return http.ListenAndServe(args[1], transit.StopQueryHandler{Index: index})
+	}
+
+	p, err := transit.Coordinates{Lat: args[1], Long: args[2]}.ToPoint()
+	if err != nil {
+		return err
+	}
+	param := float64(transit.DefaultNearestStops)
+	if args[0] == "within" {
+		param = transit.DefaultQueryRadius
+	}
+	if len(args) == 4 {
+		if param, err = strconv.ParseFloat(args[3], 64); err != nil {
+			return errors.New(usage)
+		}
+	}
+
+	result, err := transit.QueryStops(index, args[0], p, param)
+	if err != nil {
+		return fmt.Errorf("%v\n%v", err, usage)
+	}
+	for _, s := range result {
+		fmt.Printf("%v\t%.0f m\t%v\n", s.Stop.Id, s.Distance, s.Stop.Name)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package transit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Constants
+const EnvAgencies string = "AGENCIES"
+const AgencyBilbobus string = "Bilbobus"
+const AgencyBizkaibus string = "Bizkaibus"
+const AgencyMetroBilbao string = "MetroBilbao"
+const AgencyEuskotren string = "Euskotren"
+const AgencyIdSeparator string = ":"
+const EnvNameBizkaibus string = "BIZKAIBUS_TRANSIT"
+const EnvNameMetroBilbao string = "METRO_BILBAO_TRANSIT"
+const EnvNameEuskotren string = "EUSKOTREN_TRANSIT"
+
+const AgencyAll string = "All"
+
+// AgencyFactory is a type of function that creates a new instance of
+// an agency, ready to digest its sources.
+type AgencyFactory func() Parser
+
+// mergedAgency is a Parser over the data already digested by several
+// agencies (see MergeAgencies).
+type mergedAgency struct {
+	data TransitData
+}
+
+// Agencies registered by name.
+var agencyFactories = map[string]AgencyFactory{
+	AgencyBilbobus:    func() Parser { return &Bilbobus{} },
+	AgencyBizkaibus:   func() Parser { return NewGTFSAgency(AgencyBizkaibus, EnvNameBizkaibus) },
+	AgencyMetroBilbao: func() Parser { return NewGTFSAgency(AgencyMetroBilbao, EnvNameMetroBilbao) },
+	AgencyEuskotren:   func() Parser { return NewGTFSAgency(AgencyEuskotren, EnvNameEuskotren) },
+}
+var agencyFactoriesLock sync.RWMutex
+
+// RegisterAgency makes the agency created by f available under name,
+// replacing the previous one (if any).
+func RegisterAgency(name string, f AgencyFactory) {
+	agencyFactoriesLock.Lock()
+	defer agencyFactoriesLock.Unlock()
+	agencyFactories[name] = f
+}
+
+// NewAgency returns a new instance of the agency registered under name.
+func NewAgency(name string) (Parser, error) {
+	agencyFactoriesLock.RLock()
+	defer agencyFactoriesLock.RUnlock()
+	f, found := agencyFactories[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown agency %v", name)
+	}
+	return f(), nil
+}
+
+// ConfiguredAgencies returns the names of the agencies to process, read
+// from env variable AGENCIES (comma separated). Bilbobus if not defined.
+func ConfiguredAgencies() []string {
+	envData := os.Getenv(EnvAgencies)
+	if len(strings.TrimSpace(envData)) == 0 {
+		log.Printf("Env variable %v is empty. Processing %v", EnvAgencies, AgencyBilbobus)
+		return []string{AgencyBilbobus}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(envData, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) > 0 && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// GetSourcesFromEnv reads the list of data sources from the env var.
+// Returns the list of sources.
+func GetSourcesFromEnv(env string) []TransitSource {
+	envData := os.Getenv(env)
+	// Base case
+	if len(envData) == 0 {
+		log.Printf("Warning: Env variable %v is empty!", env)
+		return make([]TransitSource, 0)
+	}
+
+	var sources []TransitSource
+	dec := json.NewDecoder(strings.NewReader(envData))
+	dec.DisallowUnknownFields()
+	for {
+		if err := dec.Decode(&sources); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Error while parsing input: %v ", err)
+			return nil
+		}
+	}
+	return sources
+}
+
+// NamespaceId returns id qualified with the agency name, so ids of
+// different agencies do not collide (e.g. Bilbobus:I01).
+func NamespaceId(agency, id string) string {
+	if len(id) == 0 {
+		return id
+	}
+	return agency + AgencyIdSeparator + id
+}
+
+// MergeAgencies joins the data digested by several agencies in one
+// Parser. Line, agency line, stop and station ids (and the ids they
+// reference) are namespaced per agency, so queries (e.g. Stops("Bilbobus:01",
+// direction)) do not mix the lines of different agencies. Connections
+// are namespaced with the agency of the line they connect with. Metadata is the one of the first agency.
+func MergeAgencies(agencies []Parser) Parser {
+	var merged TransitData
+	for i, a := range agencies {
+		data := a.Data()
+		if i == 0 {
+			merged.metadata = data.metadata
+		}
+		for _, l := range data.lines {
+			merged.lines = append(merged.lines, namespaceLine(a.Name(), l))
+		}
+		for _, l := range data.dayLines {
+			merged.dayLines = append(merged.dayLines, namespaceLine(a.Name(), l))
+		}
+		for _, l := range data.nightLines {
+			merged.nightLines = append(merged.nightLines, namespaceLine(a.Name(), l))
+		}
+		for _, s := range data.stops {
+			merged.stops = append(merged.stops, namespaceStop(a.Name(), s))
+		}
+		for _, st := range data.stations {
+			merged.stations = append(merged.stations, namespaceStation(a.Name(), st))
+		}
+	}
+	return &mergedAgency{merged}
+}
+
+// Name returns AgencyAll.
+func (m mergedAgency) Name() string {
+	return AgencyAll
+}
+
+// GetSources returns no sources, data is already digested.
+func (m mergedAgency) GetSources() []TransitSource {
+	return nil
+}
+
+// Digest does nothing, data is already digested.
+func (m *mergedAgency) Digest(ctx context.Context, sources []TransitSource) error {
+	return ctx.Err()
+}
+
+func (m mergedAgency) Data() TransitData {
+	return m.data
+}
+
+func (m mergedAgency) Lines() []Line {
+	return m.data.Lines()
+}
+
+func (m mergedAgency) Line(id string) (Line, bool) {
+	return m.data.Line(id)
+}
+
+func (m mergedAgency) Stops(lineId string, direction string) []Stop {
+	return m.data.Stops(lineId, direction)
+}
+
+func (m mergedAgency) Stop(id string) (Stop, bool) {
+	return m.data.Stop(id)
+}
+
+func namespaceLine(agency string, l Line) Line {
+	l.Id = NamespaceId(agency, l.Id)
+	l.AgencyId = NamespaceId(agency, l.AgencyId)
+	stops := make([]Stop, len(l.Stops))
+	for i, s := range l.Stops {
+		stops[i] = namespaceStop(agency, s)
+	}
+	if l.Stops != nil {
+		l.Stops = stops
+	}
+	return l
+}
+
+func namespaceStop(agency string, s Stop) Stop {
+	s.Id = NamespaceId(agency, s.Id)
+	s.StationId = NamespaceId(agency, s.StationId)
+	connections := make(Connections, len(s.Connections))
+	for i, c := range s.Connections {
+		if len(c.Agency) == 0 {
+			c.Agency = agency
+		}
+		c.LineId = NamespaceId(c.Agency, c.LineId)
+		connections[i] = c
+	}
+	if s.Connections != nil {
+		s.Connections = connections
+	}
+	return s
+}
+
+func namespaceStation(agency string, st Station) Station {
+	st.Id = NamespaceId(agency, st.Id)
+	stopIds := make([]string, len(st.StopIds))
+	for i, id := range st.StopIds {
+		stopIds[i] = NamespaceId(agency, id)
+	}
+	st.StopIds = stopIds
+	return st
+}
@@ -0,0 +1,129 @@
+package transit
+
+import (
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewAgency(t *testing.T) {
+	log.Printf("---------- TestNewAgency ------------ ")
+	for _, name := range []string{AgencyBilbobus, AgencyBizkaibus, AgencyMetroBilbao, AgencyEuskotren} {
+		a, err := NewAgency(name)
+		if err != nil || a.Name() != name {
+			t.Errorf("Expected agency %v, actual %v (%v)", name, a, err)
+		}
+	}
+
+	if _, err := NewAgency("Unknown"); err == nil {
+		t.Errorf("Expected error creating unknown agency")
+	}
+
+	RegisterAgency("Test", func() Parser { return NewGTFSAgency("Test", "TEST_TRANSIT") })
+	if a, err := NewAgency("Test"); err != nil || a.Name() != "Test" {
+		t.Errorf("Expected registered agency, actual %v (%v)", a, err)
+	}
+}
+
+func TestConfiguredAgencies(t *testing.T) {
+	log.Printf("---------- TestConfiguredAgencies ------------ ")
+	defer os.Unsetenv(EnvAgencies)
+	testCases := []struct {
+		env      string
+		expected []string
+	}{
+		{"", []string{AgencyBilbobus}},
+		{"Bilbobus,Bizkaibus", []string{AgencyBilbobus, AgencyBizkaibus}},
+		{" MetroBilbao , Euskotren,MetroBilbao,", []string{AgencyMetroBilbao, AgencyEuskotren}},
+	}
+	for _, tc := range testCases {
+		os.Setenv(EnvAgencies, tc.env)
+		if actual := ConfiguredAgencies(); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%q: expected %v, actual %v", tc.env, tc.expected, actual)
+		}
+	}
+}
+
+func TestMergeAgencies(t *testing.T) {
+	log.Printf("---------- TestMergeAgencies ------------ ")
+	isNightly := false
+	stop := Stop{Id: "0001", Name: "Moyua", Connections: ParseConnections("I01 V01")}
+	stop.Connections = stop.Connections.Add(Connection{"I3411", DirectionForward, AgencyBizkaibus})
+	line := Line{"I01", "01", 1, "Plaza Biribila - Arangoiti", DirectionForward, []Stop{stop}, nil, &isNightly, nil}
+	bilbobus := &Bilbobus{TransitData{lines: []Line{line}, stops: []Stop{stop}}}
+	bizkaibus := &GTFSAgency{name: AgencyBizkaibus, data: TransitData{lines: []Line{line}, stops: []Stop{stop}}}
+
+	merged := MergeAgencies([]Parser{bilbobus, bizkaibus})
+	if len(merged.Lines()) != 2 || len(merged.Data().stops) != 2 {
+		t.Fatalf("Expected 2 lines and 2 stops, actual %v and %v", len(merged.Lines()), len(merged.Data().stops))
+	}
+	if _, found := merged.Line("Bilbobus:I01"); !found {
+		t.Errorf("Expected line Bilbobus:I01 in %v", merged.Lines())
+	}
+	if stops := merged.Stops("Bizkaibus:01", DirectionForward); len(stops) != 1 || stops[0].Id != "Bizkaibus:0001" {
+		t.Errorf("Expected stops of line Bizkaibus:01, actual %v", stops)
+	}
+	if stops := merged.Stops("01", DirectionForward); stops != nil {
+		t.Errorf("Expected no stops of line without agency, actual %v", stops)
+	}
+	l, found := merged.Line("Bizkaibus:I01")
+	if s := l.Stops[0]; !found || s.Id != "Bizkaibus:0001" || s.Connections.String() != "Bizkaibus:I01 Bizkaibus:V01 Bizkaibus:I3411" || s.Connections[1].Agency != AgencyBizkaibus {
+		t.Errorf("Unexpected namespaced stop %v", s)
+	}
+	bilbobusStop, _ := merged.Stop("Bilbobus:0001")
+	if c := bilbobusStop.Connections; len(c) != 3 || c[0].LineId != "Bilbobus:I01" || c[2].LineId != "Bizkaibus:I3411" {
+		t.Errorf("Unexpected connections of other agencies %v", c)
+	}
+	if line.Id != "I01" || line.Stops[0].Id != "0001" {
+		t.Errorf("Merge modified the data of the agency: %v", line)
+	}
+}
+
+func TestTransitDataQueries(t *testing.T) {
+	log.Printf("---------- TestTransitDataQueries ------------ ")
+	isNightly := false
+	moyua := Stop{Id: "0001", Name: "Moyua"}
+	abando := Stop{Id: "0002", Name: "Abando"}
+	td := TransitData{
+		lines: []Line{
+			{"I01", "01", 1, "Plaza Biribila - Arangoiti", DirectionForward, []Stop{moyua, abando}, nil, &isNightly, nil},
+			{"V01", "01", 1, "Arangoiti - Plaza Biribila", DirectionBackward, []Stop{abando}, nil, &isNightly, nil},
+		},
+		stops: []Stop{moyua, abando},
+	}
+
+	if l, found := td.Line("V01"); !found || l.Direction != DirectionBackward {
+		t.Errorf("Expected line V01, actual %v", l)
+	}
+	if _, found := td.Line("I02"); found {
+		t.Errorf("Unexpected line I02")
+	}
+	if stops := td.Stops("01", DirectionForward); len(stops) != 2 {
+		t.Errorf("Expected 2 stops in line 01 forward, actual %v", stops)
+	}
+	if stops := td.Stops("02", DirectionForward); stops != nil {
+		t.Errorf("Expected no stops for unknown line, actual %v", stops)
+	}
+	if s, found := td.Stop("0002"); !found || s.Name != "Abando" {
+		t.Errorf("Expected stop Abando, actual %v", s)
+	}
+	if _, found := td.Stop("9999"); found {
+		t.Errorf("Unexpected stop 9999")
+	}
+}
+
+func TestDigestErrors(t *testing.T) {
+	log.Printf("---------- TestDigestErrors ------------ ")
+	var errs DigestErrors
+	if errs.ErrorOrNil() != nil {
+		t.Errorf("Expected nil error when there are no errors")
+	}
+
+	errs = append(errs, errors.New("first"), errors.New("second"))
+	err := errs.ErrorOrNil()
+	if err == nil || err.Error() != "2 errors: first; second" {
+		t.Errorf("Unexpected aggregated error %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package transit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveLimits bounds what can be extracted from an archive, so a
+// corrupt or malicious feed can not fill up the disk.
+type ArchiveLimits struct {
+	MaxEntries   int   // Entries in the archive (files and folders)
+	MaxFileSize  int64 // Bytes of a single extracted file
+	MaxTotalSize int64 // Bytes of all the extracted files
+}
+
+var DefaultArchiveLimits = ArchiveLimits{MaxEntries: 10000, MaxFileSize: 1 << 30, MaxTotalSize: 4 << 30}
+
+// Archive formats
+const archiveZip = "zip"
+const archiveTarGz = "tar.gz"
+const archiveGzip = "gzip"
+
+// archiveExtractor keeps the state of one extraction.
+type archiveExtractor struct {
+	dest      string
+	limits    ArchiveLimits
+	wanted    map[string]bool
+	entries   int
+	total     int64
+	extracted []string
+}
+
+// UnzipFromArchive is a function that unzips a file from the supplied zip archive.
+// The unzipped file is save to dest path.
+func UnzipFromArchive(archive, file, dest string) error {
+	_, err := ExtractFromArchive(archive, dest, file)
+	return err
+}
+
+// ExtractFromArchive extracts the given files (all of them if none is
+// given) from archive into folder dest, applying DefaultArchiveLimits.
+// Zip, tar.gz and plain gzip archives are supported.
+// Returns the paths of the extracted files.
+func ExtractFromArchive(archive, dest string, files ...string) ([]string, error) {
+	return ExtractFromArchiveWithLimits(archive, dest, DefaultArchiveLimits, files...)
+}
+
+// ExtractFromArchiveWithLimits works as ExtractFromArchive with the
+// supplied limits. Entries escaping dest (e.g. "../../etc/passwd"), links
+// and archives exceeding the limits are rejected with an error.
+func ExtractFromArchiveWithLimits(archive, dest string, limits ArchiveLimits, files ...string) ([]string, error) {
+	format, err := archiveFormat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &archiveExtractor{dest: dest, limits: limits}
+	if len(files) > 0 {
+		e.wanted = make(map[string]bool)
+		for _, f := range files {
+			e.wanted[path.Clean(f)] = true
+		}
+	}
+
+	if err = os.MkdirAll(dest, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case archiveZip:
+		err = e.extractZip(archive)
+	case archiveTarGz:
+		err = e.extractTarGz(archive)
+	default:
+		err = e.extractGzip(archive)
+	}
+	if err != nil {
+		return e.extracted, err
+	}
+
+	// Everything requested shall be there
+	var missing []string
+	for _, f := range files {
+		if e.wanted[path.Clean(f)] {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return e.extracted, fmt.Errorf("Files %v not found inside %v", missing, archive)
+	}
+
+	log.Printf("Extracted %v files from %v", len(e.extracted), archive)
+	return e.extracted, nil
+}
+
+// archiveFormat figures out the format of the archive from its content.
+func archiveFormat(archive string) (string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", fmt.Errorf("Unknown archive format of %v: %v", archive, err)
+	}
+	if bytes.Equal(magic, []byte("PK\x03\x04")) || bytes.Equal(magic, []byte("PK\x05\x06")) {
+		return archiveZip, nil
+	}
+	if magic[0] != 0x1f || magic[1] != 0x8b {
+		return "", fmt.Errorf("Unknown archive format of %v", archive)
+	}
+
+	// Gzip. Is it a tarball?
+	f.Seek(0, io.SeekStart)
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+	header := make([]byte, 512)
+	if n, _ := io.ReadFull(gz, header); n == len(header) && bytes.HasPrefix(header[257:], []byte("ustar")) {
+		return archiveTarGz, nil
+	}
+	return archiveGzip, nil
+}
+
+func (e *archiveExtractor) extractZip(archive string) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if len(r.File) > e.limits.MaxEntries {
+		return fmt.Errorf("Archive %v has %v entries. Limit is %v", archive, len(r.File), e.limits.MaxEntries)
+	}
+
+	for _, f := range r.File {
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("Archive entry %v is a link", f.Name)
+		}
+		if err := e.extractEntry(f.Name, mode.IsDir(), func() (io.ReadCloser, error) { return f.Open() }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTarGz(archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeRegA:
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("Archive entry %v is a link", h.Name)
+		default:
+			log.Printf("Ignoring archive entry %v of type %v", h.Name, h.Typeflag)
+			continue
+		}
+
+		if err := e.extractEntry(h.Name, h.Typeflag == tar.TypeDir, func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(tr), nil
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *archiveExtractor) extractGzip(archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	// Name stored in the header or, if none, the archive one without extension
+	name := gz.Name
+	if len(name) == 0 {
+		name = strings.TrimSuffix(filepath.Base(archive), filepath.Ext(archive))
+	}
+	return e.extractEntry(name, false, func() (io.ReadCloser, error) { return ioutil.NopCloser(gz), nil })
+}
+
+// extractEntry writes the entry name of the archive in dest,
+// if it is wanted, checking limits and path traversal.
+func (e *archiveExtractor) extractEntry(name string, isDir bool, open func() (io.ReadCloser, error)) error {
+	e.entries++
+	if e.entries > e.limits.MaxEntries {
+		return fmt.Errorf("Archive has more than %v entries", e.limits.MaxEntries)
+	}
+
+	target, err := safeArchivePath(e.dest, name)
+	if err != nil {
+		return err
+	}
+
+	if isDir {
+		return nil // Folders are created as needed
+	}
+	clean := path.Clean(strings.Replace(name, `\`, "/", -1))
+	if e.wanted != nil && !e.wanted[clean] {
+		return nil
+	}
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	// Sizes in headers can not be trusted. Count what is actually written
+	limit := e.limits.MaxFileSize
+	if remaining := e.limits.MaxTotalSize - e.total; remaining < limit {
+		limit = remaining
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, limit+1))
+	e.total += n
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("Archive entry %v exceeds size limits (file %v bytes, total %v bytes)",
+			name, e.limits.MaxFileSize, e.limits.MaxTotalSize)
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	if e.wanted != nil {
+		delete(e.wanted, clean)
+	}
+	e.extracted = append(e.extracted, target)
+	return nil
+}
+
+// safeArchivePath returns the path where the entry name of an archive
+// shall be extracted inside dest. Absolute names and names escaping
+// dest are rejected (zip-slip).
+func safeArchivePath(dest, name string) (string, error) {
+	slashed := strings.Replace(name, `\`, "/", -1)
+	if len(slashed) == 0 || path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("Illegal archive entry name %v", name)
+	}
+	clean := path.Clean(slashed)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("Archive entry " + name + " escapes destination folder")
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean)), nil
+}
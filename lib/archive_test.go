@@ -0,0 +1,151 @@
+package transit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name    string
+	content string
+	link    bool
+}
+
+var gtfsEntries = []archiveEntry{
+	{"agency.txt", "agency_id,agency_name\nBIO,Bilbobus\n", false},
+	{"stops.txt", "stop_id,stop_name,stop_lat,stop_lon\n0001,Moyua,43.2630,-2.9350\n", false},
+	{"extra/readme.txt", "Bilbobus GTFS\n", false},
+}
+
+func writeZipArchive(p string, entries []archiveEntry) {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.link {
+			h.SetMode(os.ModeSymlink | 0777)
+		}
+		f, _ := w.CreateHeader(h)
+		f.Write([]byte(e.content))
+	}
+	w.Close()
+	ioutil.WriteFile(p, b.Bytes(), 0644)
+}
+
+func writeTarGzArchive(p string, entries []archiveEntry) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	w := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link {
+			h = &tar.Header{Name: e.name, Linkname: e.content, Typeflag: tar.TypeSymlink}
+		}
+		w.WriteHeader(h)
+		if !e.link {
+			w.Write([]byte(e.content))
+		}
+	}
+	w.Close()
+	gz.Close()
+	ioutil.WriteFile(p, b.Bytes(), 0644)
+}
+
+func writeGzipArchive(p string, entries []archiveEntry) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Name = entries[0].name
+	gz.Write([]byte(entries[0].content))
+	gz.Close()
+	ioutil.WriteFile(p, b.Bytes(), 0644)
+}
+
+var extractFromArchiveTestCases = []struct {
+	name          string
+	write         func(string, []archiveEntry)
+	entries       []archiveEntry
+	files         []string      // files to extract
+	limits        ArchiveLimits // limits to apply
+	expected      []string      // files expected in dest
+	expectedError bool          // shall return an error?
+}{
+	{"zip all", writeZipArchive, gtfsEntries, nil, DefaultArchiveLimits,
+		[]string{"agency.txt", "extra/readme.txt", "stops.txt"}, false},
+	{"zip some", writeZipArchive, gtfsEntries, []string{"stops.txt", "agency.txt"}, DefaultArchiveLimits,
+		[]string{"agency.txt", "stops.txt"}, false},
+	{"zip missing file", writeZipArchive, gtfsEntries, []string{"stops.txt", "trips.txt"}, DefaultArchiveLimits,
+		[]string{"stops.txt"}, true},
+	{"zip slip", writeZipArchive, append([]archiveEntry{{"../../evil.txt", "evil", false}}, gtfsEntries...), nil, DefaultArchiveLimits,
+		nil, true},
+	{"zip absolute path", writeZipArchive, []archiveEntry{{"/tmp/evil.txt", "evil", false}}, nil, DefaultArchiveLimits,
+		nil, true},
+	{"zip link", writeZipArchive, []archiveEntry{{"stops.txt", "/etc/passwd", true}}, nil, DefaultArchiveLimits,
+		nil, true},
+	{"zip too many entries", writeZipArchive, gtfsEntries, nil, ArchiveLimits{2, 1 << 20, 1 << 20},
+		nil, true},
+	{"zip file too big", writeZipArchive, gtfsEntries, nil, ArchiveLimits{10, 20, 1 << 20},
+		nil, true},
+	{"zip total too big", writeZipArchive, gtfsEntries, nil, ArchiveLimits{10, 1 << 20, 60},
+		[]string{"agency.txt"}, true},
+	{"tar.gz all", writeTarGzArchive, gtfsEntries, nil, DefaultArchiveLimits,
+		[]string{"agency.txt", "extra/readme.txt", "stops.txt"}, false},
+	{"tar.gz some", writeTarGzArchive, gtfsEntries, []string{"extra/readme.txt"}, DefaultArchiveLimits,
+		[]string{"extra/readme.txt"}, false},
+	{"tar.gz slip", writeTarGzArchive, []archiveEntry{{"extra/../../evil.txt", "evil", false}}, nil, DefaultArchiveLimits,
+		nil, true},
+	{"tar.gz link", writeTarGzArchive, []archiveEntry{{"stops.txt", "/etc/passwd", true}}, nil, DefaultArchiveLimits,
+		nil, true},
+	{"gzip", writeGzipArchive, gtfsEntries, nil, DefaultArchiveLimits,
+		[]string{"agency.txt"}, false},
+	{"gzip slip", writeGzipArchive, []archiveEntry{{"../evil.txt", "evil", false}}, nil, DefaultArchiveLimits,
+		nil, true},
+	{"not an archive", func(p string, e []archiveEntry) { ioutil.WriteFile(p, []byte("<html></html>"), 0644) }, nil, nil, DefaultArchiveLimits,
+		nil, true},
+}
+
+func TestExtractFromArchive(t *testing.T) {
+	log.Printf("---------- TestExtractFromArchive ------------ ")
+	for _, tc := range extractFromArchiveTestCases {
+		dir, _ := ioutil.TempDir("", "archive")
+		archive := filepath.Join(dir, "feed.archive")
+		dest := filepath.Join(dir, "out", "feed")
+		tc.write(archive, tc.entries)
+
+		_, err := ExtractFromArchiveWithLimits(archive, dest, tc.limits, tc.files...)
+		if err != nil != tc.expectedError {
+			t.Errorf("%v: expected error %v, actual %v", tc.name, tc.expectedError, err)
+		}
+
+		var found []string
+		filepath.Walk(filepath.Join(dir, "out"), func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(dest, p)
+				found = append(found, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		sort.Strings(found)
+		if strings.Join(found, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("%v: expected files %v, actual %v", tc.name, tc.expected, found)
+		}
+		for _, e := range tc.entries {
+			if b, err := ioutil.ReadFile(filepath.Join(dest, e.name)); err == nil && string(b) != e.content {
+				t.Errorf("%v: file %v expected content %v, actual %v", tc.name, e.name, e.content, string(b))
+			}
+		}
+		if Exists(filepath.Join(dir, "evil.txt")) || Exists(filepath.Join(filepath.Dir(dir), "evil.txt")) {
+			t.Errorf("%v: file extracted outside destination", tc.name)
+		}
+		os.RemoveAll(dir)
+	}
+	log.Printf("------------------------------------------------ ")
+}
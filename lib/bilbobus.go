@@ -1,6 +1,7 @@
 package transit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -17,6 +18,33 @@ type Bilbobus struct {
 	data TransitData
 }
 
+// bilbobusContext keeps the state of one run of the Bilbobus parsers, so
+// several runs (e.g. two digests in parallel) do not interfere.
+type bilbobusContext struct {
+	linesIgnored     map[string]bool   // Agency ids of the lines to ignore
+	lineNumbers      *LineRegistry     // Line numbers, by configuration or registered
+	stopsCache       map[string][]Stop // Stops already fetched per line id
+	night            NightLineDetector // Night lines by configuration
+	agencyNightLines map[string]bool   // Agency ids of the lines listed only as night lines
+}
+
+// newBilbobusContext creates the context of a new run, loading the
+// lines to ignore, line numbers mapping and line registry from environment.
+func newBilbobusContext() *bilbobusContext {
+	return &bilbobusContext{
+		linesIgnored:     LoadIgnoreLineIds(),
+		lineNumbers:      LoadLineRegistry(AgencyBilbobus, loadLineNumberMapping()),
+		stopsCache:       make(map[string][]Stop),
+		night:            LoadNightLineDetector(bilbobusNightLinesPattern),
+		agencyNightLines: make(map[string]bool),
+	}
+}
+
+// isIgnored checks whether line id shall be ignored in this run
+func (c *bilbobusContext) isIgnored(id string) bool {
+	return IsIgnored(c.linesIgnored, id)
+}
+
 // Constants
 const EnvNameBilbao string = "BILBAO_TRANSIT"
 const separator string = "-"
@@ -24,51 +52,61 @@ const EnvMetadata string = "METADATA"
 
 var defaultMetadataItem = MetadataItem{"1", "1", "1", "86400", "False", strconv.FormatInt(time.Now().Unix(), 10)}
 
+// Name returns the identifier of the agency.
+func (p Bilbobus) Name() string {
+	return AgencyBilbobus
+}
+
 // Read the data sources from the env var.
 // Returns the list of sources.
 func (p Bilbobus) GetSources() []TransitSource {
-	envData := os.Getenv(EnvNameBilbao)
-	// Base case
-	if len(envData) == 0 {
-		log.Printf("Warning: Env variable %v is empty!", EnvNameBilbao)
-		return make([]TransitSource, 0)
-	}
-
-	var sources []TransitSource
-	dec := json.NewDecoder(strings.NewReader(envData))
-	dec.DisallowUnknownFields()
-	for {
-		if err := dec.Decode(&sources); err == io.EOF {
-			break
-		} else if err != nil {
-			log.Printf("Error while parsing input: %v ", err)
-			return nil
-		}
-	}
-	return sources
+	return GetSourcesFromEnv(EnvNameBilbao)
 }
 
 // Process the data files in folder dataPath and build the data model.
-func (p *Bilbobus) Digest(sources []TransitSource) error {
-	var err error
+// Sources that fail are skipped and their errors returned together.
+func (p *Bilbobus) Digest(ctx context.Context, sources []TransitSource) error {
+	var errs DigestErrors
+	c := newBilbobusContext()
 	for _, s := range sources {
-		parser, e := getParser(s)
-		if e != nil {
-			log.Printf("Error getting parser or source %v: %v", s.Id, e)
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		parser, err := c.getParser(s)
+		if err != nil {
+			log.Printf("Error getting parser or source %v: %v", s.Id, err)
+			errs = append(errs, err)
 			continue
 		}
 
-		err = parser(&p.data.lines, s)
+		err = parser(ctx, &p.data.lines, s)
 
 		if err != nil {
 			log.Printf("Error while processing source %v from path %v. Error: %v ", s.Id, s.Path, err)
+			errs = append(errs, fmt.Errorf("Source %v: %v", s.Id, err))
 			continue
 		}
 	}
 
-	// All sources processed. Add the list of stops
-	p.data.stops, _ = extractStops(p.data.lines)
-	return nil
+	// All sources processed. Keep the line numbers, normalize names,
+	// apply the remediation rules, sort the lines and add the list of stops
+	errs = append(errs, c.lineNumbers.Errors()...)
+	errs = append(errs, c.lineNumbers.Save()...)
+	dictionary, err := LoadNameDictionary()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	NormalizeNames(p.data.lines, dictionary)
+	if p.data.remediations, err = remediate(AgencyBilbobus, &p.data.lines); err != nil {
+		errs = append(errs, err)
+	}
+	SortLines(p.data.lines)
+	tagNightLines(&p.data)
+	p.data.stops, _ = extractStops(AgencyBilbobus, p.data.lines)
+	BuildStations(&p.data)
+	return errs.ErrorOrNil()
 }
 
 // Observer that returns the list of lines for this transit.
@@ -77,67 +115,68 @@ func (p Bilbobus) Data() TransitData {
 	return p.data
 }
 
+// Lines returns the lines digested (all directions).
+func (p Bilbobus) Lines() []Line {
+	return p.data.Lines()
+}
+
+// Line returns the digested line with the given id (e.g. I01), if found.
+func (p Bilbobus) Line(id string) (Line, bool) {
+	return p.data.Line(id)
+}
+
+// Stops returns the stops of line lineId (e.g. 01) in direction.
+func (p Bilbobus) Stops(lineId string, direction string) []Stop {
+	return p.data.Stops(lineId, direction)
+}
+
+// Stop returns the digested stop with the given id, if found.
+func (p Bilbobus) Stop(id string) (Stop, bool) {
+	return p.data.Stop(id)
+}
+
 // getParser returns the proper parser for the given transitSource.
-func getParser(s TransitSource) (Parse, error) {
+func (c *bilbobusContext) getParser(s TransitSource) (Parse, error) {
 	switch s.Id {
 	case SourceLines:
-		return LinesParser, nil
+		return c.parseLines, nil
 	case SourceStops:
-		return StopsParser, nil
+		return c.parseStops, nil
 	case SourceSchedule:
 		return ScheduleParser, nil
+	case SourceShapes:
+		return ShapesParser, nil
 	default:
 		return nil, errors.New("Unknown source id " + s.Id)
 	}
 }
 
-// tagNightlyLines walk through the list of lines setting
-// to true those considered nightly
-func tagNightlyLines(t *TransitData) error {
-
-	// Precondition
-	if t.lines==nil || t.nightLines == nil || len(t.lines) <= 0 || len(t.nightLines) <= 0 {
-		message := fmt.Sprintf("Either lines or nightlines has no elements")
-		log.Printf(message)
-		return errors.New(message)
-	}
-
-	for _, nl := range t.nightLines {
-		for i, l := range t.lines {
-			if nl.Number==l.Number {
-				*(t.lines[i].IsNightLine) = true
-			}
-		}
-	}
-	return nil
-}
-
 // extractStops explores the supplied transit data to fill out
 // the list of stops from the rest of the information contained.
-func extractStops (l []Line) ([]Stop, error){
+// Connections of a stop are merged from all the lines visiting it,
+// and the ones without agency of the lines are set to agency. Schedule
+// and distance along the route are per line, so left empty (see
+// AggregateStops for the timetables of all the lines at a stop).
+func extractStops (agency string, l []Line) ([]Stop, error){
+	setConnectionsAgency(l, agency)
 	stops := make(map[string]Stop)
 	for _, line := range l {
 		for _, s := range line.Stops {
-			_, stopPresent := stops[s.Id]
+			stop, stopPresent := stops[s.Id]
 			if !stopPresent {
-				s.Connections = addLineIdToConnections(s.Connections, line.Id)
-				stops[s.Id] = s
+				stop = s
+				stop.Connections = nil
+				stop.Schedule, stop.ShapeDistance = Timetable{}, 0
 			}
+			stop.Connections = stop.Connections.Merge(s.Connections).Add(Connection{line.Id, line.Direction, agency})
+			stops[s.Id] = stop
 		}
 	}
 
 	return toStopSlice(stops), nil
 }
 
-func addLineIdToConnections (connections string, newLineId string) string {
-
-	if len(connections)!=0 {
-		connections += " "
-	}
-	connections+=newLineId
-	return connections
-}
-
+// toStopSlice returns the stops of m sorted by id.
 func toStopSlice(m map[string]Stop) []Stop {
 	v := make([]Stop, len(m))
 	index := 0
@@ -145,12 +184,19 @@ func toStopSlice(m map[string]Stop) []Stop {
 		v[index] = value
 		index++
 	}
+	sortStops(v)
 	return v
 }
 
 // Read the metadata from the env var.
 // Returns an object holding the Metadata information.
 func (p Bilbobus) BuildMetadata() []MetadataItem {
+	return buildMetadata()
+}
+
+// buildMetadata reads the metadata from the env var.
+// Returns an object holding the Metadata information.
+func buildMetadata() []MetadataItem {
 	envData := os.Getenv(EnvMetadata)
 	// Base case
 	if len(envData) == 0 {
@@ -159,6 +205,26 @@ func (p Bilbobus) BuildMetadata() []MetadataItem {
 		return append(metadataDefault, defaultMetadataItem)
 	}
 
+	metadata := readMetadata()
+
+	// Insert last update timestamp to the one which has not
+	for index, value := range metadata {
+		if len(value.LastUpdate) == 0 {
+			metadata[index].LastUpdate = strconv.FormatInt(time.Now().Unix(), 10)
+			break
+		}
+	}
+	return metadata
+}
+
+// readMetadata reads the metadata from the env var as it is, without
+// timestamp of last update added. Nil if not defined or not valid.
+func readMetadata() []MetadataItem {
+	envData := os.Getenv(EnvMetadata)
+	if len(envData) == 0 {
+		return nil
+	}
+
 	var metadata []MetadataItem
 	dec := json.NewDecoder(strings.NewReader(envData))
 	dec.DisallowUnknownFields()
@@ -170,13 +236,5 @@ func (p Bilbobus) BuildMetadata() []MetadataItem {
 			return nil
 		}
 	}
-
-	// Insert last update timestamp to the one which has not
-	for index, value := range metadata {
-		if len(value.LastUpdate)==0 {
-			metadata[index].LastUpdate = strconv.FormatInt(time.Now().Unix(), 10)
-			break
-		}
-	}
 	return metadata
-}
\ No newline at end of file
+}
@@ -0,0 +1,248 @@
+package transit
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeAgency is a local replacement of the Bilbobus agency web site. It is
+// built from a declarative description of lines, stops and timetables and
+// renders the same HTML the agency serves for the list of lines, the stops
+// of a line and the horario-estimado schedule of a stop.
+type fakeAgency struct {
+	Season string
+	Lines  []fakeLine
+	Faults map[string]fakeFault
+
+	server *httptest.Server
+	mu     sync.Mutex
+	hits   map[string]int
+}
+
+// fakeLine describes a line of the fake agency. Duplicated lines are listed
+// in both groups (day and night) of the lines page, as the agency does for
+// lines in several groups.
+type fakeLine struct {
+	Id         string
+	Name       string
+	Night      bool
+	Duplicated bool
+	Forward    []fakeStop
+	Backward   []fakeStop
+}
+
+// fakeStop describes a stop of a line in one direction. A stop without
+// Lat/Long is rendered without map link, like the stops the agency has
+// not geolocated. Times are the departures keyed by day type id.
+type fakeStop struct {
+	Id          string
+	Name        string
+	Lat, Long   string
+	Connections []fakeConnection
+	Times       map[string][]string
+}
+
+// fakeConnection is a line (and its direction) reachable from a stop.
+type fakeConnection struct {
+	Line      string
+	Direction string
+}
+
+// fakeFault is a failure injected in the responses of the fake agency.
+// Times limits how many requests fail (0 means all of them).
+type fakeFault struct {
+	Status   int
+	Delay    time.Duration
+	Truncate bool
+	Times    int
+}
+
+// Keys of the fake agency pages, used to inject faults and count hits.
+func fakeLinesKey() string                     { return "lines" }
+func fakeStopsKey(line string) string          { return "stops/" + line }
+func fakeScheduleKey(line, stop string) string { return "schedule/" + line + "/" + stop }
+
+// Start launches the fake agency in a local HTTP server.
+func (a *fakeAgency) Start() {
+	a.hits = make(map[string]int)
+	if len(a.Season) == 0 {
+		a.Season = SeasonWinter
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lineas", func(w http.ResponseWriter, r *http.Request) {
+		a.serve(w, r, fakeLinesKey(), a.renderLines())
+	})
+	mux.HandleFunc("/paradas", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("codLinea")
+		l, found := a.line(id)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		a.serve(w, r, fakeStopsKey(id), a.renderStops(l))
+	})
+	mux.HandleFunc("/horarios", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("codLinea")
+		stop := r.URL.Query().Get("parada")
+		l, found := a.line(id)
+		if !found || r.URL.Query().Get("temporada") != a.Season {
+			http.NotFound(w, r)
+			return
+		}
+		a.serve(w, r, fakeScheduleKey(id, stop), a.renderSchedule(l, stop))
+	})
+	a.server = httptest.NewServer(mux)
+}
+
+// Close shuts down the fake agency server.
+func (a *fakeAgency) Close() {
+	a.server.Close()
+}
+
+// Sources returns the list of sources pointing to the fake agency, in the
+// same format of env variable EnvNameBilbao.
+func (a *fakeAgency) Sources(dataPath string) string {
+	return fmt.Sprintf(`[{"Path":"%v","Uri":"%v/lineas","Id":"%v"},`+
+		`{"Path":"%v","Uri":"%v/paradas?codLinea=%v","Id":"%v"},`+
+		`{"Path":"%v/sched/schedule","Uri":"%v/horarios?codLinea=%v&parada=%v&temporada=%v","Id":"%v"}]`,
+		dataPath, a.server.URL, SourceLines,
+		dataPath, a.server.URL, TokenLine, SourceStops,
+		dataPath, a.server.URL, TokenLine, TokenStop, TokenSeason, SourceSchedule)
+}
+
+// Hits returns the number of requests received for the page key.
+func (a *fakeAgency) Hits(key string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.hits[key]
+}
+
+func (a *fakeAgency) line(id string) (fakeLine, bool) {
+	for _, l := range a.Lines {
+		if l.Id == id {
+			return l, true
+		}
+	}
+	return fakeLine{}, false
+}
+
+// serve writes body as the response of page key, applying the fault
+// configured for it (if any).
+func (a *fakeAgency) serve(w http.ResponseWriter, r *http.Request, key, body string) {
+	a.mu.Lock()
+	a.hits[key]++
+	hit := a.hits[key]
+	fault, faulty := a.Faults[key]
+	a.mu.Unlock()
+
+	if !faulty || (fault.Times > 0 && hit > fault.Times) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, body)
+		return
+	}
+
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault.Status != 0 {
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+	if fault.Truncate {
+		// Announce the full body but send half of it. The server drops the
+		// connection and the client gets an unexpected EOF.
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		fmt.Fprint(w, body[:len(body)/2])
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func (a *fakeAgency) renderLines() string {
+	var day, night strings.Builder
+	for _, l := range a.Lines {
+		option := fmt.Sprintf("            <option value=\"%v\">%v - %v</option>\n", l.Id, l.Id, html.EscapeString(l.Name))
+		group, other := &day, &night
+		if l.Night {
+			group, other = &night, &day
+		}
+		group.WriteString(option)
+		if l.Duplicated {
+			other.WriteString(option)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<html>\n<body>\n<section>\n    <div class=\"select selectlong\">\n")
+	b.WriteString("        <select name=\"linea\" id=\"linea\" class=\"select\">\n")
+	b.WriteString("            <option value=\"0000\" selected=\"selected\">Seleccione una l&iacute;nea</option>\n")
+	b.WriteString("            <optgroup label=\"Diurnas\">\n" + day.String() + "            </optgroup>\n")
+	b.WriteString("            <optgroup label=\"Nocturnas\">\n" + night.String() + "            </optgroup>\n")
+	b.WriteString("        </select>\n    </div>\n</section>\n</body>\n</html>\n")
+	return b.String()
+}
+
+func (a *fakeAgency) renderStops(l fakeLine) string {
+	var b strings.Builder
+	b.WriteString("<html>\n<body>\n")
+	for _, d := range []struct {
+		tag   string
+		stops []fakeStop
+	}{{"ida", l.Forward}, {"vuelta", l.Backward}} {
+		b.WriteString("<table summary=\"Paradas " + d.tag + "\">\n")
+		for i, s := range d.stops {
+			b.WriteString("<tr>\n")
+			fmt.Fprintf(&b, "<td headers=\"parada_%v\"><span class=\"num\">%v</span>%v</td>\n", d.tag, i+1, html.EscapeString(s.Name))
+			fmt.Fprintf(&b, "<td headers=\"horario_%v\"><a href=\"horarios?codLinea=%v&amp;temporada=%v&amp;parada=%v\">\nHorario</a></td>\n", d.tag, l.Id, a.Season, s.Id)
+			if len(s.Lat) > 0 && len(s.Long) > 0 {
+				fmt.Fprintf(&b, "<td headers=\"mapa_%v\"><a href=\"https://maps.google.com/?q=%v,%v\"\ntarget=\"_blank\">Mapa</a></td>\n", d.tag, s.Lat, s.Long)
+			}
+			fmt.Fprintf(&b, "<td headers=\"correspondencias_%v correspondencia_parada\">", d.tag)
+			for _, c := range s.Connections {
+				fmt.Fprintf(&b, "\n<a href=\"linea?codLinea=%v&amp;sentido=%v\"> %v </a>", c.Line, ToDirectionNumber(c.Direction), c.Line)
+			}
+			b.WriteString("</td>\n</tr>\n")
+		}
+		b.WriteString("</table>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func (a *fakeAgency) renderSchedule(l fakeLine, stopId string) string {
+	var b strings.Builder
+	b.WriteString("<html>\n<body>\n")
+	for _, d := range []struct {
+		direction string
+		stops     []fakeStop
+	}{{DirectionForward, l.Forward}, {DirectionBackward, l.Backward}} {
+		for _, s := range d.stops {
+			if s.Id != stopId {
+				continue
+			}
+			days := make([]string, 0, len(s.Times))
+			for day := range s.Times {
+				days = append(days, day)
+			}
+			sort.Strings(days)
+			for _, day := range days {
+				for _, t := range s.Times[day] {
+					fmt.Fprintf(&b, "<a href=\"horario-estimado?codLinea=%v&amp;temporada=%v&amp;servicio=1&amp;tipodia=%v&amp;sentido=%v&amp;hora=%v\">%v</a>\n",
+						l.Id, a.Season, day, ToDirectionNumber(d.direction), strings.Replace(t, ":", "", 1), t)
+				}
+			}
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,323 @@
+package transit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fakeBilbobusLines = []fakeLine{
+	{Id: "01", Name: "Plaza Biribila - Arangoiti",
+		Forward: []fakeStop{
+			{Id: "0001", Name: "Plaza Biribila", Lat: "43.2614", Long: "-2.9275",
+				Connections: []fakeConnection{{"03", DirectionForward}, {"G1", DirectionBackward}},
+				Times:       map[string][]string{WeekDayTypeId: {"06:30", "07:00"}, SaturdayTypeId: {"08:00"}, SundayTypeId: {"09:00"}}},
+			{Id: "0002", Name: "Arangoiti", Lat: "43.2690", Long: "-2.9371",
+				Times: map[string][]string{WeekDayTypeId: {"06:45", "07:15"}, SaturdayTypeId: {"08:15"}, SundayTypeId: {"09:15"}}},
+		},
+		Backward: []fakeStop{
+			{Id: "0003", Name: "Arangoiti", Lat: "43.2691", Long: "-2.9372",
+				Times: map[string][]string{WeekDayTypeId: {"07:30"}, SaturdayTypeId: {"08:30"}, SundayTypeId: {"09:30"}}},
+			{Id: "0004", Name: "Plaza Biribila", Lat: "43.2615", Long: "-2.9276",
+				Times: map[string][]string{WeekDayTypeId: {"07:45"}, SaturdayTypeId: {"08:45"}, SundayTypeId: {"09:45"}}},
+		},
+	},
+	{Id: "03", Name: "Otxarkoaga - Moyua", Duplicated: true,
+		Forward: []fakeStop{
+			{Id: "0010", Name: "Otxarkoaga", Lat: "43.2580", Long: "-2.8990",
+				Times: map[string][]string{MondayToThursdayTypeId: {"06:00"}, FridayTypeId: {"06:00", "23:00"}}},
+			{Id: "0001", Name: "Plaza Biribila", Lat: "43.2614", Long: "-2.9275",
+				Connections: []fakeConnection{{"01", DirectionForward}},
+				Times:       map[string][]string{WeekDayTypeId: {"06:20"}}},
+		},
+		Backward: []fakeStop{
+			{Id: "0011", Name: "Moyua", Lat: "43.2630", Long: "-2.9350",
+				Times: map[string][]string{WeekDayTypeId: {"07:00"}}},
+		},
+	},
+	{Id: "G1", Name: "Moyua - Santutxu", Night: true, Duplicated: true,
+		Forward: []fakeStop{
+			{Id: "0011", Name: "Moyua", Lat: "43.2630", Long: "-2.9350",
+				Times: map[string][]string{WeekDayTypeId: {"23:30", "00:30"}, SaturdayTypeId: {"01:30"}}},
+		},
+		Backward: []fakeStop{
+			{Id: "0020", Name: "Santutxu", Lat: "43.2560", Long: "-2.9180",
+				Times: map[string][]string{WeekDayTypeId: {"00:00", "01:00"}, SaturdayTypeId: {"02:00"}}},
+		},
+	},
+}
+
+// digestFakeAgency runs the whole Bilbobus pipeline (sources, download,
+// parsing) against the given fake agency and returns the digested data.
+func digestFakeAgency(t *testing.T, agency *fakeAgency) TransitData {
+	td, _ := digestFakeAgencyContext(t, context.Background(), agency)
+	return td
+}
+
+// digestFakeAgencyContext is digestFakeAgency with a context, also
+// returning the error of the digest.
+func digestFakeAgencyContext(t *testing.T, ctx context.Context, agency *fakeAgency) (TransitData, error) {
+	dir, err := ioutil.TempDir("", "fakeagency")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv(EnvNameBilbao, agency.Sources(dir))
+	os.Setenv(EnvNameBilbobusSummerStart, "2000-06-01T00:00:00Z")
+	os.Setenv(EnvNameBilbobusSummerEnd, "2000-09-01T00:00:00Z")
+	os.Unsetenv(EnvNameReuseLocalData)
+	defer os.Unsetenv(EnvNameBilbao)
+	defer os.Unsetenv(EnvNameBilbobusSummerStart)
+	defer os.Unsetenv(EnvNameBilbobusSummerEnd)
+
+	retries := MaxDownloadRetries
+	MaxDownloadRetries = 1
+	defer func() { MaxDownloadRetries = retries }()
+
+	var b Bilbobus
+	sources := b.GetSources()
+	if len(sources) != 3 {
+		t.Fatalf("Expected 3 sources, actual %v", sources)
+	}
+	err = b.Digest(ctx, sources)
+	return b.Data(), err
+}
+
+func findLine(lines []Line, id string) (Line, bool) {
+	for _, l := range lines {
+		if l.Id == id {
+			return l, true
+		}
+	}
+	return Line{}, false
+}
+
+func TestFakeAgencyEndToEnd(t *testing.T) {
+	log.Printf("---------- TestFakeAgencyEndToEnd ------------ ")
+	agency := &fakeAgency{Lines: fakeBilbobusLines}
+	agency.Start()
+	defer agency.Close()
+
+	td := digestFakeAgency(t, agency)
+	if len(td.lines) != 6 {
+		t.Fatalf("Expected 6 lines (duplicated lines once), actual %v", len(td.lines))
+	}
+
+	l, _ := findLine(td.lines, "I01")
+	if len(l.Stops) != 2 || l.Name != "Plaza Biribila - Arangoiti" || len(l.MapRoute) != 2 {
+		t.Errorf("Unexpected line I01: %v", l)
+	} else {
+		s := l.Stops[0]
+		if s.Id != "0001" || s.Connections.String() != "I03 VG1" || s.Location != (Coordinates{"43.2614", "-2.9275"}) {
+			t.Errorf("Unexpected stop 0001 of line I01: %v", s)
+		}
+		expected := Timetable{Weekday: "06:30,07:00", Saturday: "08:00", Sunday: "09:00"}
+		if s.Schedule != expected {
+			t.Errorf("Schedule of stop 0001 line I01: expected %v, actual %v", expected, s.Schedule)
+		}
+	}
+
+	l, _ = findLine(td.lines, "V01")
+	if l.Name != "Arangoiti - Plaza Biribila" || len(l.Stops) != 2 || l.Stops[1].Schedule.Sunday != "09:45" {
+		t.Errorf("Unexpected line V01: %v", l)
+	}
+
+	// Lines with different Fridays have Monday to Thursday and Friday
+	l, _ = findLine(td.lines, "I03")
+	if len(l.Stops) != 2 || l.Stops[0].Schedule != (Timetable{MondayToThrusday: "06:00", Friday: "06:00,23:00"}) {
+		t.Errorf("Unexpected line I03: %v", l)
+	}
+
+	// Night lines, also listed among the day lines, have night types of day
+	l, _ = findLine(td.lines, "IG1")
+	if !*l.IsNightLine || len(l.Stops) != 1 || l.Stops[0].Schedule != (Timetable{FridayNight: "23:30,00:30", SaturdayNight: "01:30"}) {
+		t.Errorf("Unexpected night line IG1: %v", l)
+	}
+
+	if len(td.stops) != 7 {
+		t.Errorf("Expected 7 different stops, actual %v", len(td.stops))
+	}
+
+	if _, err := CheckConsistency(td); err != nil {
+		t.Errorf("Unexpected consistency error: %v", err)
+	}
+	log.Printf("------------------------------------------------ ")
+}
+
+func TestFakeAgencyMissingCoordinates(t *testing.T) {
+	log.Printf("---------- TestFakeAgencyMissingCoordinates ------------ ")
+	lines := []fakeLine{
+		fakeBilbobusLines[0],
+		{Id: "05", Name: "Txurdinaga - Zorrotza",
+			Forward: []fakeStop{{Id: "0030", Name: "Txurdinaga", Times: map[string][]string{WeekDayTypeId: {"06:00"}}}},
+		},
+	}
+	agency := &fakeAgency{Lines: lines}
+	agency.Start()
+	defer agency.Close()
+
+	td := digestFakeAgency(t, agency)
+	if l, _ := findLine(td.lines, "I05"); len(l.Stops) != 0 {
+		t.Errorf("Line I05 has a stop without location, expected no stops, actual %v", l.Stops)
+	}
+	if l, _ := findLine(td.lines, "I01"); len(l.Stops) != 2 {
+		t.Errorf("Line I01 shall not be affected, actual stops %v", l.Stops)
+	}
+	if _, err := CheckConsistency(td); err == nil {
+		t.Errorf("Expected consistency error for line without stops")
+	}
+	log.Printf("------------------------------------------------ ")
+}
+
+var fakeAgencyFaultsTestCases = []struct {
+	name          string
+	faults        map[string]fakeFault
+	expectedStops map[string]int // stops per line id
+	expectedHits  map[string]int // requests per page key
+}{
+	{"server error",
+		map[string]fakeFault{fakeStopsKey("03"): {Status: http.StatusInternalServerError}},
+		map[string]int{"I01": 2, "V01": 2, "I03": 0, "V03": 0, "IG1": 1},
+		map[string]int{fakeStopsKey("03"): 4},
+	},
+	{"server error recovered by retry",
+		map[string]fakeFault{fakeStopsKey("03"): {Status: http.StatusInternalServerError, Times: 1}},
+		map[string]int{"I03": 2, "V03": 1},
+		map[string]int{fakeStopsKey("03"): 2},
+	},
+	{"slow response",
+		map[string]fakeFault{fakeStopsKey("01"): {Delay: 200 * time.Millisecond}, fakeScheduleKey("G1", "0011"): {Delay: 200 * time.Millisecond}},
+		map[string]int{"I01": 2, "V01": 2, "IG1": 1},
+		map[string]int{fakeStopsKey("01"): 1},
+	},
+	{"truncated body",
+		map[string]fakeFault{fakeStopsKey("G1"): {Truncate: true}},
+		map[string]int{"I01": 2, "IG1": 0, "VG1": 0},
+		map[string]int{},
+	},
+}
+
+func TestFakeAgencyFaults(t *testing.T) {
+	log.Printf("---------- TestFakeAgencyFaults ------------ ")
+	for _, tc := range fakeAgencyFaultsTestCases {
+		agency := &fakeAgency{Lines: fakeBilbobusLines, Faults: tc.faults}
+		agency.Start()
+		td := digestFakeAgency(t, agency)
+		agency.Close()
+
+		for id, expected := range tc.expectedStops {
+			if l, _ := findLine(td.lines, id); len(l.Stops) != expected {
+				t.Errorf("%v: line %v expected %v stops, actual %v", tc.name, id, expected, len(l.Stops))
+			}
+		}
+		for key, expected := range tc.expectedHits {
+			if actual := agency.Hits(key); actual != expected {
+				t.Errorf("%v: page %v expected %v requests, actual %v", tc.name, key, expected, actual)
+			}
+		}
+	}
+	log.Printf("------------------------------------------------ ")
+}
+
+func TestFakeAgencyDigestErrors(t *testing.T) {
+	log.Printf("---------- TestFakeAgencyDigestErrors ------------ ")
+	agency := &fakeAgency{Lines: fakeBilbobusLines,
+		Faults: map[string]fakeFault{fakeStopsKey("03"): {Status: http.StatusInternalServerError}}}
+	agency.Start()
+	defer agency.Close()
+
+	td, err := digestFakeAgencyContext(t, context.Background(), agency)
+	if err == nil || !strings.Contains(err.Error(), "Stops of line I03") || !strings.Contains(err.Error(), "Stops of line V03") {
+		t.Errorf("Expected errors of the stops of line 03, actual %v", err)
+	}
+	if l, _ := findLine(td.lines, "I01"); len(l.Stops) != 2 {
+		t.Errorf("Line I01 shall be digested despite the errors, actual %v", l)
+	}
+	log.Printf("------------------------------------------------ ")
+}
+
+func TestFakeAgencyDigestCancelled(t *testing.T) {
+	log.Printf("---------- TestFakeAgencyDigestCancelled ------------ ")
+	agency := &fakeAgency{Lines: fakeBilbobusLines}
+	agency.Start()
+	defer agency.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	td, err := digestFakeAgencyContext(t, ctx, agency)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("Expected cancellation error, actual %v", err)
+	}
+	if len(td.lines) != 0 || agency.Hits(fakeLinesKey()) != 0 {
+		t.Errorf("Expected nothing digested nor fetched, actual %v lines", len(td.lines))
+	}
+	log.Printf("------------------------------------------------ ")
+}
+
+var fakeOtherLines = []fakeLine{
+	{Id: "A3", Name: "Zorrotza - Moyua",
+		Forward: []fakeStop{
+			{Id: "0040", Name: "Zorrotza", Lat: "43.2751", Long: "-2.9640",
+				Times: map[string][]string{WeekDayTypeId: {"06:10"}}},
+		},
+		Backward: []fakeStop{
+			{Id: "0011", Name: "Moyua", Lat: "43.2630", Long: "-2.9350",
+				Times: map[string][]string{WeekDayTypeId: {"06:40"}}},
+		},
+	},
+}
+
+func TestConcurrentDigests(t *testing.T) {
+	log.Printf("---------- TestConcurrentDigests ------------ ")
+	os.Setenv(EnvNameBilbobusSummerStart, "2000-06-01T00:00:00Z")
+	os.Setenv(EnvNameBilbobusSummerEnd, "2000-09-01T00:00:00Z")
+	defer os.Unsetenv(EnvNameBilbobusSummerStart)
+	defer os.Unsetenv(EnvNameBilbobusSummerEnd)
+
+	agencies := []*fakeAgency{{Lines: fakeBilbobusLines}, {Lines: fakeOtherLines}}
+	results := make([]TransitData, len(agencies))
+	var wg sync.WaitGroup
+	for i, agency := range agencies {
+		agency.Start()
+		defer agency.Close()
+
+		dir, _ := ioutil.TempDir("", "concurrent")
+		defer os.RemoveAll(dir)
+		var sources []TransitSource
+		if err := json.Unmarshal([]byte(agency.Sources(dir)), &sources); err != nil {
+			t.Fatalf("Error decoding sources: %v", err)
+		}
+
+		wg.Add(1)
+		go func(i int, sources []TransitSource) {
+			defer wg.Done()
+			var b Bilbobus
+			b.Digest(context.Background(), sources)
+			results[i] = b.Data()
+		}(i, sources)
+	}
+	wg.Wait()
+
+	if len(results[0].lines) != 6 || len(results[1].lines) != 2 {
+		t.Fatalf("Expected 6 and 2 lines, actual %v and %v", len(results[0].lines), len(results[1].lines))
+	}
+	// Each run generates its own numbers for alphanumeric lines
+	if l, _ := findLine(results[0].lines, "IG1"); l.Number != GeneratedBaseNumber+1 {
+		t.Errorf("Line IG1: expected number %v, actual %v", GeneratedBaseNumber+1, l.Number)
+	}
+	if l, _ := findLine(results[1].lines, "IA3"); l.Number != GeneratedBaseNumber+1 || len(l.Stops) != 1 {
+		t.Errorf("Unexpected line IA3: %v", l)
+	}
+	if l, _ := findLine(results[0].lines, "I03"); len(l.Stops) != 2 || l.Stops[1].Schedule.Weekday != "06:20" {
+		t.Errorf("Unexpected line I03: %v", l)
+	}
+	log.Printf("------------------------------------------------ ")
+}
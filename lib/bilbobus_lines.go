@@ -1,6 +1,7 @@
 package transit
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,26 +10,24 @@ import (
 	"os"
 	"path"
 	"regexp"
-	"strconv"
-	"strings"
 )
 
 const envIgnoreLinesIds = "IGNORE_LINES_IDS"
 const envMapLineNumbers = "BILBOBUS_SPECIAL_LINES_MAPPING"
 const bilbobusLineListPattern string = `(?m).*<option value="\S{2}">(\S{2})[\s,-]+(.*)[\s]*<\/option>`
+const bilbobusLineGroupPattern string = `(?sU)<optgroup label="([^"]*)">(.*)</optgroup>`
+const bilbobusNightGroupPattern string = `(?i)nocturn`
+const bilbobusNightLinesPattern string = `^G`
 
-var lineNumberIdMap map[string]int
-var linesProcessed map[string]bool
-var lineNumberMapStr map[string]string
 
-
-// checkStopsSchedules verifies that the schedule information
-// associated with with the information published by the transit agency
-func LinesParser(l *[]Line, ts TransitSource) error {
+// parseLines implements the signature of type Parse.
+// It's responsible for filling l with the lines published by the agency.
+func (c *bilbobusContext) parseLines(ctx context.Context, l *[]Line, ts TransitSource) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	log.Printf("Parsing lines")
-	LinesIgnored = LoadIgnoreLineIds()
-	loadLineNumberMapping()
-	agencyLines, err := getAgencyLines(ts.Path, ts.Uri)
+	agencyLines, err := c.getAgencyLines(ts.Path, ts.Uri)
 	if err != nil {
 		return err
 	}
@@ -40,21 +39,28 @@ func LinesParser(l *[]Line, ts TransitSource) error {
 // getAgencyLines fetch the list of lines published by the agency and
 // returns it. If something goes wrong, the returned list will be nil and error
 // holds the specific error.
-func getAgencyLines(outputDataPath, uri string) (*[]Line, error) {
+func (c *bilbobusContext) getAgencyLines(outputDataPath, uri string) (*[]Line, error) {
 
 	p := path.Join(outputDataPath, "lines.html")
 	if !UseCachedData() || !Exists(p) {
-		Download(uri, p, IsFileSizeGreaterThanZero)
+		if err := Download(uri, p, ValidateLinesPage); err != nil {
+			log.Printf("Error downloading lines from %v: %v", uri, err)
+		}
 	}
 
-	return ParseAgencyLinesFile(p)
+	return c.parseAgencyLinesFile(p)
 }
 
-// parseAgencyLinesFile parses the agency file containing the list of lines.
+// ParseAgencyLinesFile parses the agency file containing the list of lines.
+// Line ids to ignore and line numbers mapping are taken from environment.
 func ParseAgencyLinesFile(filePath string) (*[]Line, error) {
+	return newBilbobusContext().parseAgencyLinesFile(filePath)
+}
+
+// parseAgencyLinesFile parses the agency file containing the list of lines.
+func (c *bilbobusContext) parseAgencyLinesFile(filePath string) (*[]Line, error) {
 
-	lineNumberIdMap = make(map[string]int)
-	linesProcessed = make(map[string]bool)
+	linesProcessed := make(map[string]bool)
 
 	f, err := ioutil.ReadFile(filePath) // Read all
 	if err != nil || len(f) == 0 {
@@ -76,10 +82,12 @@ func ParseAgencyLinesFile(filePath string) (*[]Line, error) {
 		return nil, errors.New(message)
 	}
 
+	c.agencyNightLines = findAgencyNightLines(string(f))
+
 	var lines []Line
 	for _, t := range times {
 
-		if IsIgnored(t[1]) {
+		if c.isIgnored(t[1]) {
 			log.Printf("ParseLines: Line %v shall be ignored", t[1])
 			continue
 		}
@@ -91,7 +99,7 @@ func ParseAgencyLinesFile(filePath string) (*[]Line, error) {
 
 		linesProcessed[t[1]] = true
 
-		lines = append(lines, createLine(t[1], t[2], DirectionForward))
+		lines = append(lines, c.createLine(t[1], t[2], DirectionForward))
 
 		backwardsName, err := ReverseLineName(t[2])
 		if err != nil {
@@ -99,71 +107,62 @@ func ParseAgencyLinesFile(filePath string) (*[]Line, error) {
 			backwardsName = t[2]
 		}
 
-		lines = append(lines, createLine(t[1], backwardsName, DirectionBackward))
+		lines = append(lines, c.createLine(t[1], backwardsName, DirectionBackward))
 	}
 
 	log.Printf("Found %v lines (backwards and forward) in the agency file.", len(lines))
 	return &lines, nil
 }
 
-func createLine(id string, name string, direction string) Line {
+func (c *bilbobusContext) createLine(id string, name string, direction string) Line {
 
-	isNightly := isNightlyLine(id)
-	l := Line{BuildLineIdWithDirection(id, direction), id, toLineNumberMap(id),
-		name, direction, nil, nil, &isNightly}
+	isNightly := c.night.IsNight(id) || c.agencyNightLines[id]
+	lineId := BuildLineIdWithDirection(id, direction)
+	l := Line{lineId, id, c.lineNumbers.Number(id, lineId),
+		name, direction, nil, nil, &isNightly, nil}
 	return l
 }
 
-func loadLineNumberMapping() {
+// loadLineNumberMapping loads from environment the line numbers
+// that have to be assigned to specific agency ids.
+func loadLineNumberMapping() map[string]string {
 
 	envData := os.Getenv(envMapLineNumbers)
 	if len(envData) == 0 {
 		log.Printf("Env variable %v is empty. Nothing to map.", envMapLineNumbers)
-		return
+		return nil
 	}
 
-	err := json.Unmarshal([]byte(envData), &lineNumberMapStr)
+	var lineNumberMap map[string]string
+	err := json.Unmarshal([]byte(envData), &lineNumberMap)
 	if err != nil {
 		log.Printf("Error mapping content of %v", envMapLineNumbers)
-		return
+		return nil
 	}
+	return lineNumberMap
 }
 
-func toLineNumberMap(s string) int {
-	var n = -1
-	if len(lineNumberMapStr) > 0 {
-		nstr, exists := lineNumberMapStr[strings.ToUpper(s)]
-		if exists {
-			n, _ = strconv.Atoi(nstr)
+// findAgencyNightLines returns the agency ids of the lines listed only
+// in the group of night lines (e.g. Nocturnas) of the lines page.
+func findAgencyNightLines(content string) map[string]bool {
+	night := make(map[string]bool)
+	day := make(map[string]bool)
+	groups := regexp.MustCompile(bilbobusLineGroupPattern).FindAllStringSubmatch(content, -1)
+	options := regexp.MustCompile(bilbobusLineListPattern)
+	isNight := regexp.MustCompile(bilbobusNightGroupPattern)
+	for _, g := range groups {
+		for _, o := range options.FindAllStringSubmatch(g[2], -1) {
+			if isNight.MatchString(g[1]) {
+				night[o[1]] = true
+			} else {
+				day[o[1]] = true
+			}
 		}
 	}
-
-	if n == -1 {
-		n = toLineNumber(s)
-	}
-	return n
-}
-
-func toLineNumber(s string) int {
-	var n int
-	n, err := strconv.Atoi(s)
-	if err != nil {
-		i, exists := lineNumberIdMap[s]
-		if !exists {
-			currentGeneratedNumberOrdinal++
-			n = GeneratedBaseNumber + currentGeneratedNumberOrdinal
-			lineNumberIdMap[s] = n
-		} else {
-			n = i
-		}
+	for id := range day {
+		delete(night, id)
 	}
-	return n
-}
-
-// isNightlyLine returns a boolean saying whether the line is a nightly line
-// based on the agencyId.
-func isNightlyLine(agencyID string) bool {
-	return strings.HasPrefix(strings.ToUpper(agencyID), "G")
+	return night
 }
 
 
@@ -36,14 +36,14 @@ var parseAgencyLinesFileTestCases = []struct {
 </section>
 <p class="aut"><b>Class:</b></p>`,
 		[]Line {
-			Line {"I03", "03", 3,"MOON - PLUTO URANO", "FORWARD", nil, nil, &isNotNightly },
-			Line {"V03", "03", 3,"PLUTO URANO - MOON", "BACKWARD", nil, nil, &isNotNightly },
-			Line {"I46", "46",46,"EARTH - SUNSUNSUN","FORWARD", nil, nil, &isNotNightly },
-			Line {"V46", "46",46,"SUNSUNSUN - EARTH","BACKWARD", nil, nil, &isNotNightly },
-			Line {"IA3", "A3", 9001, "SUN VENUS - SATURN/NEPTUNE", "FORWARD",nil, nil, &isNotNightly },
-			Line {"VA3", "A3", 9001, "SATURN/NEPTUNE - SUN VENUS", "BACKWARD",nil, nil, &isNotNightly },
-			Line {"IG1", "G1", 9002, "MERCURY - SATURN MARS", "FORWARD",nil, nil, &isNightly },
-			Line {"VG1", "G1", 9002, "SATURN MARS - MERCURY", "BACKWARD",nil, nil, &isNightly },
+			Line {"I03", "03", 3,"MOON - PLUTO URANO", "FORWARD", nil, nil, &isNotNightly, nil },
+			Line {"V03", "03", 3,"PLUTO URANO - MOON", "BACKWARD", nil, nil, &isNotNightly, nil },
+			Line {"I46", "46",46,"EARTH - SUNSUNSUN","FORWARD", nil, nil, &isNotNightly, nil },
+			Line {"V46", "46",46,"SUNSUNSUN - EARTH","BACKWARD", nil, nil, &isNotNightly, nil },
+			Line {"IA3", "A3", 9001, "SUN VENUS - SATURN/NEPTUNE", "FORWARD",nil, nil, &isNotNightly, nil },
+			Line {"VA3", "A3", 9001, "SATURN/NEPTUNE - SUN VENUS", "BACKWARD",nil, nil, &isNotNightly, nil },
+			Line {"IG1", "G1", 9002, "MERCURY - SATURN MARS", "FORWARD",nil, nil, &isNightly, nil },
+			Line {"VG1", "G1", 9002, "SATURN MARS - MERCURY", "BACKWARD",nil, nil, &isNightly, nil },
 		},
 		false,
 	},
@@ -11,6 +11,7 @@ const RemediationTag = "REMEDIATE"
 
 // RemediateLineName replace the detected line name in data by the
 // expected. The expected name shall correspond to direction FORWARD.
+// Names per language are removed, the expected name is the same in all.
 func  RemediateLineName(lines *[]Line, agencyLineId string, expected string) error {
 	message := fmt.Sprintf(RemediationTag + ": Changing line %v name. Expected: %v", agencyLineId, expected)
 	log.Printf(message)
@@ -21,6 +22,7 @@ func  RemediateLineName(lines *[]Line, agencyLineId string, expected string) err
 		if l.AgencyId == agencyLineId {
 			if l.Direction==DirectionForward {
 				(*lines)[i].Name = expected
+				(*lines)[i].Names = nil
 				forwardDone = true
 			} else {
 				backwardName, err := ReverseLineName(expected)
@@ -28,6 +30,7 @@ func  RemediateLineName(lines *[]Line, agencyLineId string, expected string) err
 					return err
 				}
 				(*lines)[i].Name = backwardName
+				(*lines)[i].Names = nil
 				backwardDone = true
 			}
 		}
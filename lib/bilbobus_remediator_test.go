@@ -7,14 +7,14 @@ import (
 
 
 var remediationLinesTest = []Line{
-	 Line {"I01", "01", 1, "Moon - Pluto URANO", "FORWARD", nil, nil, new(bool)},
-	 Line {"I22", "22", 22, "PERSEI - GLIESE/PEGASI", "FORWARD", nil, nil, new(bool)},
-	 Line {"IK4", "K4", 90881, "Tauri - Herculis", "FORWARD", nil, nil, new(bool)},
-	 Line {"V01", "01", 1, "Pluto URANO - Moon", "BACKWARD", nil, nil, new(bool)},
-	 Line {"V22", "22", 22, "PEGASI/GLIESE - PERSEI", "BACKWARD", nil, nil, new(bool)},
-	 Line {"VK4", "K4", 90881, "Herculis - Tauris", "BACKWARD", nil, nil, new(bool)},
-	 Line {"I29", "29", 29, "Orionis - Trianguli", "FORWARD", nil, nil, new(bool)},
-	 Line {"V99", "99", 99, "Serpentis - Pegasi", "BACKWARD", nil, nil, new(bool)},
+	 Line {"I01", "01", 1, "Moon - Pluto URANO", "FORWARD", nil, nil, new(bool), nil},
+	 Line {"I22", "22", 22, "PERSEI - GLIESE/PEGASI", "FORWARD", nil, nil, new(bool), nil},
+	 Line {"IK4", "K4", 90881, "Tauri - Herculis", "FORWARD", nil, nil, new(bool), nil},
+	 Line {"V01", "01", 1, "Pluto URANO - Moon", "BACKWARD", nil, nil, new(bool), nil},
+	 Line {"V22", "22", 22, "PEGASI/GLIESE - PERSEI", "BACKWARD", nil, nil, new(bool), nil},
+	 Line {"VK4", "K4", 90881, "Herculis - Tauris", "BACKWARD", nil, nil, new(bool), nil},
+	 Line {"I29", "29", 29, "Orionis - Trianguli", "FORWARD", nil, nil, new(bool), nil},
+	 Line {"V99", "99", 99, "Serpentis - Pegasi", "BACKWARD", nil, nil, new(bool), nil},
 }
 
 
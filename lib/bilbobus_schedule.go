@@ -1,6 +1,7 @@
 package transit
 
 import (
+	"context"
 	"sync"
 	"log"
 	"path"
@@ -9,6 +10,7 @@ import (
 	"fmt"
 	"errors"
 	"strings"
+	"sort"
 	"os"
 	"time"
 )
@@ -20,6 +22,15 @@ const scheduleRegExTemplate string = `(?m).*<a href="horario-estimado\?codLinea=
 	`&amp;servicio=\d{0,3}&amp;tipodia=` + TokenDay + `&amp;sentido=` + TokenDirection + `&amp;hora=.*">(.*)<`
 const scheduleValidationFileRegEx string = `(?m).*<a href="horario-estimado\?codLinea=`
 
+// Types of day of the agency (tipodia) and their type of day in the timetable
+var bilbobusDayTypes = map[string]string{
+	WeekDayTypeId:          DayWeekday,
+	MondayToThursdayTypeId: DayMondayToThursday,
+	FridayTypeId:           DayFriday,
+	SaturdayTypeId:         DaySaturday,
+	SundayTypeId:           DaySunday,
+}
+
 // Types
 type JobSchedule struct {
 	s  *Stop
@@ -29,37 +40,59 @@ type JobSchedule struct {
 
 // ScheduleParser implements the signature of type Decorator.
 // It's responsible for decorating lines with the location of the stops.
-func ScheduleParser(l *[]Line, ts TransitSource) error {
+// Returns the errors of all the stops whose schedule could not be filled.
+func ScheduleParser(ctx context.Context, l *[]Line, ts TransitSource) error {
 	c := make(chan JobSchedule)
-	go scheduleMaster(c, l, ts)
-	collectSchedules(3, c)
-	return nil
+	go scheduleMaster(ctx, c, l, ts)
+	errs := collectSchedules(3, c)
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.ErrorOrNil()
 }
 
-func collectSchedules(workers int, c chan JobSchedule) {
+func collectSchedules(workers int, c chan JobSchedule) DigestErrors {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs DigestErrors
 	poolSize := 5
 	for i := 0; i < poolSize; i++ {
 		wg.Add(1)
-		go scheduleWorker(&wg, c)
+		go scheduleWorker(&wg, c, func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		})
 	}
 	wg.Wait()
+
+	// Same errors in the same order whatever the order of the workers
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
 }
 
-func scheduleMaster(c chan JobSchedule, l *[]Line, ts TransitSource) {
+// scheduleMaster sends a job per stop of every line, until all sent
+// or ctx is cancelled.
+func scheduleMaster(ctx context.Context, c chan JobSchedule, l *[]Line, ts TransitSource) {
+	defer close(c)
 	for _, line := range *l {
 		for j, _ := range line.Stops {
-			c <- JobSchedule{&line.Stops[j], line, ts}
+			select {
+			case c <- JobSchedule{&line.Stops[j], line, ts}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
-	close(c)
 }
 
-func scheduleWorker(wg *sync.WaitGroup, c <-chan JobSchedule) {
+func scheduleWorker(wg *sync.WaitGroup, c <-chan JobSchedule, report func(error)) {
 	defer wg.Done()
 	for job := range c {
 		log.Printf("Processing static schedule for line %v and stop %v", job.l.Id, job.s.Id)
-		fillScheduleForStop(job.s, job.l, job.ts)
+		if err := fillScheduleForStop(job.s, job.l, job.ts); err != nil {
+			report(fmt.Errorf("Schedule of line %v and stop %v: %v", job.l.Id, job.s.Id, err))
+		}
 	}
 }
 
@@ -74,7 +107,9 @@ func fillScheduleForStop(s *Stop, l Line, ts TransitSource) error {
 
 	p := path.Join(path.Dir(ts.Path), "sched_"+l.Id+"_"+s.Id+".html")
 	if !UseCachedData() || !Exists(p) {
-		Download(u, p, validateScheduleFile)
+		if err := Download(u, p, ValidateSchedulePage); err != nil {
+			log.Printf("Error downloading schedule of line %v and stop %v: %v", l.Id, s.Id, err)
+		}
 	}
 	err = parseScheduleFile(p, s, l)
 	return err
@@ -93,46 +128,63 @@ func parseScheduleFile(path string, s *Stop, l Line) error {
 		return err
 	}
 
-	days := []string{WeekDayTypeId, SaturdayTypeId, SundayTypeId}
-	for _, day := range days {
-		pattern := buildScheduleRegexPattern(l.AgencyId, season, day, ToDirectionNumber(l.Direction))
-		regex, err := regexp.Compile(pattern)
-		if err != nil {
-			log.Printf("Error compiling schedule regex %v. Error: %v ", pattern, err)
-			return err
-		}
-		times := regex.FindAllStringSubmatch(string(f), -1)
-		if times == nil {
-			message := fmt.Sprintf("No static schedule found. Line: %v. Stop: %v. Day %v. Season: %v", l.Id, s.Id, day, season)
-			log.Printf(message)
+	// Every type of day published for the line and direction
+	pattern := buildScheduleRegexPattern(l.AgencyId, season, `(\w+)`, ToDirectionNumber(l.Direction))
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("Error compiling schedule regex %v. Error: %v ", pattern, err)
+		return err
+	}
+	times := regex.FindAllStringSubmatch(string(f), -1)
+	if times == nil {
+		log.Printf("No static schedule found. Line: %v. Stop: %v. Season: %v", l.Id, s.Id, season)
+		return nil
+	}
+
+	var days []string
+	departures := make(map[string][]string)
+	for _, t := range times {
+		day, found := bilbobusDayTypes[t[1]]
+		if !found {
+			log.Printf("Unknown type of day %v in schedule of line %v and stop %v", t[1], l.Id, s.Id)
 			continue
 		}
-		for i, t := range times {
-
-			// Last element does not have separator
-			separator := ""
-			if i < len(times)-1 {
-				separator = ","
-			}
-
-			time := string(t[1])
-			if day == WeekDayTypeId {
-				if *l.IsNightLine {
-					s.Schedule.Friday += time + separator
-				}else {
-					s.Schedule.Weekday += time + separator
-				}
-			} else if day == SaturdayTypeId {
-				s.Schedule.Saturday += time + separator
-			} else if day == SundayTypeId {
-				s.Schedule.Sunday += time + separator
-			}
+		if departures[day] == nil {
+			days = append(days, day)
 		}
+		departures[day] = append(departures[day], t[2])
+	}
+	for _, day := range days {
+		*timetableField(&s.Schedule, day, *l.IsNightLine) = strings.Join(departures[day], ",")
 	}
 
 	return nil
 }
 
+// timetableField returns the field of the timetable of the type of day.
+// Night lines publish as weekday (or Friday) the nights of Fridays, as
+// Monday to Thursday the rest of the nights of weekdays, as Saturday
+// the nights of Saturdays and as Sunday (and holidays) the eves of holidays.
+func timetableField(t *Timetable, day string, night bool) *string {
+	switch {
+	case (day == DayWeekday || day == DayFriday) && night:
+		return &t.FridayNight
+	case day == DaySaturday && night:
+		return &t.SaturdayNight
+	case day == DaySunday && night:
+		return &t.HolidayEve
+	case day == DayMondayToThursday:
+		return &t.MondayToThrusday
+	case day == DayFriday:
+		return &t.Friday
+	case day == DaySaturday:
+		return &t.Saturday
+	case day == DaySunday:
+		return &t.Sunday
+	}
+	return &t.Weekday
+}
+
 func buildScheduleUrl(template, lineNumber, stopId string) (string, error) {
 	season, err := getSeason()
 	if err != nil {
@@ -176,37 +228,3 @@ func getSeason() (string, error) {
 	return season, nil
 }
 
-
-func validateScheduleFile (p string) bool {
-	fi, err := os.Stat(p)
-	if err != nil {
-		log.Printf("validateScheduleFile: Error gettings stats file %v. Error: %v ", p, err)
-		return false
-	}
-
-	// checks the size
-	if fi.Size()==0 {
-		log.Printf("validateScheduleFile: file %v has zero size", p)
-		return false
-	}
-
-	f, err := ioutil.ReadFile(p) // Read all
-	if err != nil || len(f) == 0 {
-		log.Printf("validateScheduleFile: Error opening file %v. Error: %v ", p, err)
-		return false
-	}
-
-	regex, err := regexp.Compile(scheduleValidationFileRegEx)
-	if err != nil {
-		log.Printf("validateScheduleFile: Error compiling schedule regex %v. Error: %v ", scheduleValidationFileRegEx, err)
-		return false
-	}
-	times := regex.FindAllStringSubmatch(string(f), -1)
-	if times == nil {
-		log.Printf("validateScheduleFile: No static schedule found with pattern %v", scheduleValidationFileRegEx)
-		return false
-	}
-
-	// Valid
-	return true
-}
\ No newline at end of file
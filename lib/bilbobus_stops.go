@@ -1,6 +1,7 @@
 package transit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html"
@@ -21,52 +22,51 @@ const RegexPatternStopPosition = `(?m).*href="https:\/\/maps\.google\.com\/\?q=(
 const RegexPatternStopConnections = `(?mU)<td headers="correspondencias_(ida|vuelta) correspondencia_parada">(?s)(.*)<\/td>` // flag as Ungreedy
 const RegexPatternStopConnectionsNumbers = `(?m).*ntido=(1|2)">\s*(\S*)\s*<\/a>`
 
-// Globals to this file
-var currentLineDirection string
-var currentLinePrefixDirecton string
-var currentGeneratedNumberOrdinal int = 0
-var stopsCache map[string][]Stop
-
-// StopsParser implements the signature of type Parse.
+// parseStops implements the signature of type Parse.
 // It's responsible for adding stops to every line present in l.
-func StopsParser(lines *[]Line, ts TransitSource) error {
-	currentGeneratedNumberOrdinal = 0
-	stopsCache = make(map[string][]Stop)
-
+// Lines whose stops can not be parsed are left without stops.
+func (c *bilbobusContext) parseStops(ctx context.Context, lines *[]Line, ts TransitSource) error {
+	var errs DigestErrors
 	for i, l := range *lines {
-		if stopsCache[l.Id] != nil {
-			(*lines)[i].Stops = stopsCache[l.Id]
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		if c.stopsCache[l.Id] != nil {
+			(*lines)[i].Stops = c.stopsCache[l.Id]
 		} else {
-			forwardStops, backwardStops, err := fetchStopsForLine(l, ts)
+			forwardStops, backwardStops, err := c.fetchStopsForLine(l, ts)
 
 			if err != nil {
 				log.Printf("Error parsing stops of line %v. Error: %v ", l.AgencyId, err)
+				errs = append(errs, fmt.Errorf("Stops of line %v: %v", l.Id, err))
 				continue
 			}
 
 			if l.Direction == DirectionForward {
 				(*lines)[i].Stops = forwardStops
-				stopsCache[BuildLineIdWithDirection(l.AgencyId, DirectionBackward)] = backwardStops
+				c.stopsCache[BuildLineIdWithDirection(l.AgencyId, DirectionBackward)] = backwardStops
 			} else {
 				(*lines)[i].Stops = backwardStops
-				stopsCache[BuildLineIdWithDirection(l.AgencyId, DirectionForward)] = forwardStops
+				c.stopsCache[BuildLineIdWithDirection(l.AgencyId, DirectionForward)] = forwardStops
 			}
 		}
 
 		(*lines)[i].MapRoute = generateMapRoute((*lines)[i])
 	}
 
-	return nil
+	return errs.ErrorOrNil()
 }
 
-func fetchStopsForLine(l Line, ts TransitSource) (forwardStops []Stop, backwardStops []Stop, e error) {
+func (c *bilbobusContext) fetchStopsForLine(l Line, ts TransitSource) (forwardStops []Stop, backwardStops []Stop, e error) {
 	path, err := getLinePage(l.AgencyId, ts.Uri, ts.Path)
 	if err != nil {
 		log.Printf("Error getting doc of line %v. Error: %v ", l.AgencyId, err)
 		return nil, nil, err
 	}
 
-	return parseLineStops(path)
+	return c.parseLineStops(path)
 }
 
 // getLinePage downloads the document containing the line stops and
@@ -79,7 +79,7 @@ func getLinePage(lineId string, uri string, outputDataPath string) (p string, er
 
 	p = path.Join(outputDataPath, "line_stops_"+lineId+".html")
 	if !UseCachedData() || !Exists(p) {
-		err = Download(agencyLinesUri, p, IsFileSizeGreaterThanZero)
+		err = Download(agencyLinesUri, p, ValidateStopsPage)
 	}
 
 	return p, err
@@ -92,7 +92,7 @@ func buildStopsUri(template, lineId string) (string, error) {
 
 // parseLineStops parses the stops file of the line a return the two lists of
 // stops, one for forward direction and the other for backwards direction.
-func parseLineStops(filePath string) (forwardStops []Stop, backwardStops []Stop, e error) {
+func (c *bilbobusContext) parseLineStops(filePath string) (forwardStops []Stop, backwardStops []Stop, e error) {
 
 	f, err := ioutil.ReadFile(filePath)
 	if err != nil || len(f) == 0 {
@@ -142,7 +142,7 @@ func parseLineStops(filePath string) (forwardStops []Stop, backwardStops []Stop,
 			return nil, nil, err
 		}
 
-		connections := buildConnectionList(connectionsRaw[i][2])
+		connections := c.buildConnectionList(connectionsRaw[i][2])
 
 		stop := buildStop(ids[i][1], html.UnescapeString(n[2]), connections, positions[i][1], positions[i][2])
 		if d == DirectionForward {
@@ -196,33 +196,30 @@ func generateMapRoute(l Line) []Coordinates {
 	return route
 }
 
-func buildStop(id, name, connections, lat, long string) Stop {
-	stop := Stop{id, name, connections, Timetable{"", "", "", "", ""}, Coordinates{lat, long}}
+func buildStop(id, name string, connections Connections, lat, long string) Stop {
+	stop := Stop{id, name, connections, Timetable{}, Coordinates{lat, long}, "", nil, 0}
 	return stop
 }
 
-func buildConnectionList(connectionsRaw string) string {
+func (c *bilbobusContext) buildConnectionList(connectionsRaw string) Connections {
 	matches, err := ApplyRegexAllSubmatch(connectionsRaw, RegexPatternStopConnectionsNumbers)
 	if err != nil {
-		return ""
+		return nil
 	}
 
-	var connections string
-	for i, m := range matches {
-		if !IsIgnored(m[2]) {
-			connections += buildConnectionCode(m[1], m[2])
-			if i < len(matches)-1 {
-				connections += " "
-			}
+	var connections Connections
+	for _, m := range matches {
+		if !c.isIgnored(m[2]) {
+			connections = connections.Add(buildConnection(m[1], m[2]))
 		}
 	}
 	return connections
 }
 
-func buildConnectionCode(direction, id string) string {
-	if direction == "1" {
-		return DirectionForwardShortPrefix + id
+func buildConnection(direction, id string) Connection {
+	d := DirectionForward
+	if direction == DirectionBackwardNumber {
+		d = DirectionBackward
 	}
-	return DirectionBackwardShortPrefix + id
+	return Connection{BuildLineIdWithDirection(id, d), d, AgencyBilbobus}
 }
-
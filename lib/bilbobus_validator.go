@@ -0,0 +1,18 @@
+package transit
+
+// ValidateLinesPage rejects agency pages without the list of lines.
+func ValidateLinesPage(p string) error {
+	return validateContentMatches(p, bilbobusLineListPattern, "line options")
+}
+
+// ValidateStopsPage rejects agency pages without the rows of the
+// stops of a line.
+func ValidateStopsPage(p string) error {
+	return validateContentMatches(p, RegexPatternStopName, "stop rows")
+}
+
+// ValidateSchedulePage rejects agency pages without the links to the
+// estimated schedule (horario-estimado) of a stop.
+func ValidateSchedulePage(p string) error {
+	return validateContentMatches(p, scheduleValidationFileRegEx, "horario-estimado links")
+}
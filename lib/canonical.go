@@ -0,0 +1,133 @@
+package transit
+
+import (
+	"sort"
+	"strings"
+)
+
+// Constants
+const EnvPublishCanonical string = "PUBLISH_CANONICAL" // true to publish in canonical order
+
+// CanonicalPresenter presents lines and stops in canonical order, so the
+// same data is always presented byte by byte the same, whatever the
+// order it was digested in: lines by number and direction, stops by id,
+// departures of every type of day in service order and connections by
+// line id. The format is the one of the presenter it wraps.
+type CanonicalPresenter struct {
+	Presenter
+}
+
+// Returns the line in canonical order with the format of the presenter.
+func (p CanonicalPresenter) Format(l Line) (string, error) {
+	return p.Presenter.Format(canonicalLine(l))
+}
+
+// Returns the lines in canonical order with the format of the presenter.
+func (p CanonicalPresenter) FormatList(l []Line) (string, error) {
+	return p.Presenter.FormatList(CanonicalLines(l))
+}
+
+// Returns the stops in canonical order with the format of the presenter.
+func (p CanonicalPresenter) FormatStops(s []AggregatedStop) (string, error) {
+	return p.Presenter.FormatStops(CanonicalStops(s))
+}
+
+// SortLines sorts the lines by number and direction (forward first).
+// Lines with the same number are sorted by id.
+func SortLines(lines []Line) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.Number != b.Number {
+			return a.Number < b.Number
+		}
+		if a.Direction != b.Direction {
+			return a.Direction == DirectionForward
+		}
+		return a.Id < b.Id
+	})
+}
+
+// sortStops sorts the stops by id.
+func sortStops(stops []Stop) {
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+}
+
+// CanonicalLines returns a copy of the lines in canonical order. The
+// stops of every line keep the order of its route.
+func CanonicalLines(lines []Line) []Line {
+	canonical := make([]Line, len(lines))
+	for i, l := range lines {
+		canonical[i] = canonicalLine(l)
+	}
+	SortLines(canonical)
+	return canonical
+}
+
+// canonicalLine returns a copy of l with its stops in canonical order.
+func canonicalLine(l Line) Line {
+	if l.Stops == nil {
+		return l
+	}
+	stops := make([]Stop, len(l.Stops))
+	for i, s := range l.Stops {
+		s.Schedule = canonicalTimetable(s.Schedule)
+		s.Connections = canonicalConnections(s.Connections)
+		stops[i] = s
+	}
+	l.Stops = stops
+	return l
+}
+
+// CanonicalStops returns a copy of the stops in canonical order.
+func CanonicalStops(stops []AggregatedStop) []AggregatedStop {
+	canonical := make([]AggregatedStop, len(stops))
+	for i, s := range stops {
+		services := make([]StopService, len(s.Services))
+		for j, service := range s.Services {
+			service.Schedule = canonicalTimetable(service.Schedule)
+			services[j] = service
+		}
+		sort.SliceStable(services, func(a, b int) bool { return services[a].LineId < services[b].LineId })
+		if s.Services != nil {
+			s.Services = services
+		}
+		canonical[i] = s
+	}
+	sort.SliceStable(canonical, func(i, j int) bool { return canonical[i].Id < canonical[j].Id })
+	return canonical
+}
+
+// canonicalConnections returns a copy of the connections sorted by line id.
+func canonicalConnections(cs Connections) Connections {
+	if cs == nil {
+		return nil
+	}
+	sorted := append(Connections(nil), cs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].LineId < sorted[j].LineId })
+	return sorted
+}
+
+// canonicalTimetable returns t with the departures of every type of day
+// sorted in service order (past midnight ones last).
+func canonicalTimetable(t Timetable) Timetable {
+	for _, f := range []*string{&t.Weekday, &t.MondayToThrusday, &t.Friday, &t.Saturday, &t.Sunday,
+		&t.FridayNight, &t.SaturdayNight, &t.HolidayEve} {
+		*f = canonicalDepartures(*f)
+	}
+	return t
+}
+
+// canonicalDepartures returns the departures (HH:MM separated by commas)
+// sorted in service order. Returned as they are if not valid.
+func canonicalDepartures(times string) string {
+	minutes, err := parseDepartures(times)
+	if err != nil || len(minutes) == 0 {
+		return times
+	}
+	sort.Ints(minutes)
+	departures := make([]string, len(minutes))
+	for i, m := range minutes {
+		departures[i] = formatMinutes(m)
+	}
+	return strings.Join(departures, ",")
+}
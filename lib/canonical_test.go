@@ -0,0 +1,90 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestSortLines(t *testing.T) {
+	log.Printf("---------- TestSortLines ------------ ")
+	lines := []Line{{Id: "VG1", Number: 9001, Direction: DirectionBackward}, {Id: "V03", Number: 3, Direction: DirectionBackward},
+		{Id: "IG1", Number: 9001, Direction: DirectionForward}, {Id: "I03", Number: 3, Direction: DirectionForward},
+		{Id: "I01", Number: 1, Direction: DirectionForward}}
+	SortLines(lines)
+	for i, expected := range []string{"I01", "I03", "V03", "IG1", "VG1"} {
+		if lines[i].Id != expected {
+			t.Errorf("Position %v: expected %v, actual %v", i, expected, lines[i].Id)
+		}
+	}
+}
+
+func TestCanonicalPresenter(t *testing.T) {
+	log.Printf("---------- TestCanonicalPresenter ------------ ")
+	forward := shapeTestLine("I01")
+	forward.Stops[0].Schedule = Timetable{Weekday: "06:30,07:00", Sunday: "23:30,00:15"}
+	forward.Stops[0].Connections = ParseConnections("I03 VG1")
+	backward := shapeTestLine("V01")
+	backward.Direction = DirectionBackward
+
+	// Same data in other order
+	shuffled := []Line{backward, forward}
+	shuffled[1].Stops = append([]Stop(nil), forward.Stops...)
+	shuffled[1].Stops[0].Schedule = Timetable{Weekday: "07:00,06:30", Sunday: "23:30,00:15"}
+	shuffled[1].Stops[0].Connections = ParseConnections("VG1 I03")
+
+	for _, p := range []Presenter{JsonPresenter{}, GeoJsonPresenter{}, KmlPresenter{}} {
+		canonical := CanonicalPresenter{p}
+		a, errA := canonical.FormatList([]Line{forward, backward})
+		b, errB := canonical.FormatList(shuffled)
+		if errA != nil || errB != nil || a != b || MD5(a) != MD5(b) {
+			t.Errorf("%T: expected same lines, actual %v and %v", p, a, b)
+		}
+		a, errA = canonical.FormatStops(AggregateStops([]Line{forward, backward}))
+		b, errB = canonical.FormatStops(AggregateStops(shuffled))
+		if errA != nil || errB != nil || a != b {
+			t.Errorf("%T: expected same stops, actual %v and %v", p, a, b)
+		}
+		if canonical.OutputName() != p.OutputName() || canonical.StopsOutputName() != p.StopsOutputName() {
+			t.Errorf("%T: unexpected output names", p)
+		}
+	}
+
+	s, _ := CanonicalPresenter{JsonPresenter{}}.Format(shuffled[1])
+	var l Line
+	if err := json.Unmarshal([]byte(s), &l); err != nil || l.Stops[0].Schedule != (Timetable{Weekday: "06:30,07:00", Sunday: "23:30,00:15"}) ||
+		l.Stops[0].Connections.String() != "I03 VG1" {
+		t.Errorf("Unexpected canonical line %v (%v)", l, err)
+	}
+	// Lines presented are not changed
+	if shuffled[0].Id != "V01" || shuffled[1].Stops[0].Schedule.Weekday != "07:00,06:30" {
+		t.Errorf("Lines changed by presenter %v", shuffled)
+	}
+
+	os.Setenv(EnvPublishCanonical, "true")
+	defer os.Unsetenv(EnvPublishCanonical)
+	if presenters, err := PresentersFromEnv(); err != nil || len(presenters) != 1 {
+		t.Errorf("Unexpected presenters %v (%v)", presenters, err)
+	} else if _, ok := presenters[0].(CanonicalPresenter); !ok {
+		t.Errorf("Expected canonical presenter, actual %T", presenters[0])
+	}
+}
+
+func TestDigestDeterministic(t *testing.T) {
+	log.Printf("---------- TestDigestDeterministic ------------ ")
+	agency := &fakeAgency{Lines: fakeBilbobusLines}
+	agency.Start()
+	defer agency.Close()
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		td := digestFakeAgency(t, agency)
+		lines, _ := JsonPresenter{}.FormatList(td.lines)
+		stops, _ := json.Marshal(td.stops)
+		outputs = append(outputs, lines+string(stops))
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("Expected same output of both digests, actual %v and %v", outputs[0], outputs[1])
+	}
+}
@@ -0,0 +1,112 @@
+package transit
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Connection is a line that can be taken at a stop.
+type Connection struct {
+	LineId    string `json:"Id"`            // e.g. I03
+	Direction string `json:"Dir,omitempty"` // DirectionForward or DirectionBackward
+	Agency    string `json:"Ag,omitempty"`
+}
+
+// Connections are the lines that can be taken at a stop, without
+// duplicates. Published as a list of Connection, or as the line ids
+// separated by spaces (e.g. "I03 V27 IG1") in compatibility mode.
+type Connections []Connection
+
+// NewConnection returns the connection to line lineId, with the
+// direction of the prefix of the id (e.g. I03 is forward).
+func NewConnection(lineId string) Connection {
+	c := Connection{LineId: lineId}
+	switch {
+	case strings.HasPrefix(lineId, DirectionForwardShortPrefix):
+		c.Direction = DirectionForward
+	case strings.HasPrefix(lineId, DirectionBackwardShortPrefix):
+		c.Direction = DirectionBackward
+	}
+	return c
+}
+
+// ParseConnections returns the connections of the line ids separated
+// by spaces (e.g. "I03 V27 IG1").
+func ParseConnections(ids string) Connections {
+	var connections Connections
+	for _, id := range strings.Fields(ids) {
+		connections = connections.Add(NewConnection(id))
+	}
+	return connections
+}
+
+// Contains tells whether lineId is one of the connections.
+func (cs Connections) Contains(lineId string) bool {
+	for _, c := range cs {
+		if c.LineId == lineId {
+			return true
+		}
+	}
+	return false
+}
+
+// Add returns the connections with c appended, unless its line is
+// already in them. An agency missing in the existing one is taken from c.
+func (cs Connections) Add(c Connection) Connections {
+	for i, existing := range cs {
+		if existing.LineId == c.LineId && (existing.Agency == c.Agency || len(existing.Agency) == 0 || len(c.Agency) == 0) {
+			if len(existing.Agency) == 0 {
+				cs[i].Agency = c.Agency
+			}
+			return cs
+		}
+	}
+	return append(cs, c)
+}
+
+// Merge returns the connections with the ones of other not in them.
+func (cs Connections) Merge(other Connections) Connections {
+	for _, c := range other {
+		cs = cs.Add(c)
+	}
+	return cs
+}
+
+// String returns the line ids of the connections separated by spaces.
+func (cs Connections) String() string {
+	ids := make([]string, len(cs))
+	for i, c := range cs {
+		ids[i] = c.LineId
+	}
+	return strings.Join(ids, " ")
+}
+
+// UnmarshalJSON reads the connections as a list of Connection or as
+// line ids separated by spaces, as published in compatibility mode.
+func (cs *Connections) UnmarshalJSON(b []byte) error {
+	var ids string
+	if err := json.Unmarshal(b, &ids); err == nil {
+		*cs = ParseConnections(ids)
+		return nil
+	}
+	var connections []Connection
+	if err := json.Unmarshal(b, &connections); err != nil {
+		return err
+	}
+	*cs = connections
+	return nil
+}
+
+// setConnectionsAgency sets agency to the connections of the stops of
+// the lines without agency.
+func setConnectionsAgency(lines []Line, agency string) {
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			for i := range s.Connections {
+				if len(s.Connections[i].Agency) == 0 {
+					s.Connections[i].Agency = agency
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestConnections(t *testing.T) {
+	log.Printf("---------- TestConnections ------------ ")
+	cs := ParseConnections("I03 V27 I03 IG1")
+	if len(cs) != 3 || cs[1] != (Connection{"V27", DirectionBackward, ""}) || cs.String() != "I03 V27 IG1" {
+		t.Errorf("Unexpected connections %v", cs)
+	}
+	if !cs.Contains("IG1") || cs.Contains("I27") {
+		t.Errorf("Unexpected lines in connections %v", cs)
+	}
+
+	cs = cs.Merge(Connections{{"V27", DirectionBackward, AgencyBilbobus}, {"I3411", DirectionForward, AgencyBizkaibus}})
+	if len(cs) != 4 || cs[1].Agency != AgencyBilbobus || cs[3].Agency != AgencyBizkaibus {
+		t.Errorf("Unexpected merged connections %v", cs)
+	}
+
+	testCases := []struct {
+		json     string
+		expected string
+	}{
+		{`"I03 V27"`, "I03 V27"},
+		{`[{"Id":"I03","Dir":"FORWARD","Ag":"Bilbobus"}]`, "I03"},
+		{`[]`, ""},
+	}
+	for _, tc := range testCases {
+		var actual Connections
+		if err := json.Unmarshal([]byte(tc.json), &actual); err != nil || actual.String() != tc.expected {
+			t.Errorf("Unmarshal %v: expected %v, actual %v (%v)", tc.json, tc.expected, actual, err)
+		}
+	}
+}
+
+func TestExtractStopsConnections(t *testing.T) {
+	log.Printf("---------- TestExtractStopsConnections ------------ ")
+	forward := shapeTestLine("I01")
+	forward.Stops[0].Connections = ParseConnections("I03")
+	other := shapeTestLine("I05")
+	other.AgencyId = "05"
+	other.Stops = other.Stops[:1]
+	other.Stops[0].Connections = ParseConnections("IG1")
+
+	stops, _ := extractStops(AgencyBilbobus, []Line{forward, other})
+	for _, s := range stops {
+		if s.Id != "0001" {
+			continue
+		}
+		// Connections of all the lines visiting the stop
+		expected := Connections{{"I03", DirectionForward, AgencyBilbobus}, {"I01", DirectionForward, AgencyBilbobus},
+			{"IG1", DirectionForward, AgencyBilbobus}, {"I05", DirectionForward, AgencyBilbobus}}
+		if len(s.Connections) != len(expected) {
+			t.Fatalf("Expected connections %v, actual %v", expected, s.Connections)
+		}
+		for i := range expected {
+			if s.Connections[i] != expected[i] {
+				t.Errorf("Expected connection %v, actual %v", expected[i], s.Connections[i])
+			}
+		}
+	}
+	if forward.Stops[0].Connections.String() != "I03" || forward.Stops[0].Connections[0].Agency != AgencyBilbobus {
+		t.Errorf("Unexpected connections of stop of line I01 %v", forward.Stops[0].Connections)
+	}
+}
+
+func TestJsonPresenterConnections(t *testing.T) {
+	log.Printf("---------- TestJsonPresenterConnections ------------ ")
+	l := shapeTestLine("I01")
+	l.Stops[0].Connections = Connections{{"I03", DirectionForward, AgencyBilbobus}, {"VG1", DirectionBackward, AgencyBilbobus}}
+
+	testCases := []struct {
+		presenter JsonPresenter
+		expected  string
+	}{
+		{JsonPresenter{}, `"Co": [`},
+		{JsonPresenter{ConnectionsString: true}, `"Co": "I03 VG1"`},
+		{JsonPresenter{ConnectionsString: true, Geometry: GeometryOptions{Polyline: true}}, `"Co": "I03 VG1"`},
+	}
+	for _, tc := range testCases {
+		s, err := tc.presenter.FormatList([]Line{l})
+		if err != nil || !strings.Contains(s, tc.expected) {
+			t.Errorf("%+v: expected %v in %v (%v)", tc.presenter, tc.expected, s, err)
+			continue
+		}
+		// Both forms are read back
+		var lines []Line
+		if err := json.Unmarshal([]byte(s), &lines); err != nil || len(lines) != 1 || lines[0].Stops[0].Connections.String() != "I03 VG1" {
+			t.Errorf("%+v: unexpected lines read %v (%v)", tc.presenter, lines, err)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of the findings.
+const SeverityError string = "error"     // Data not publishable
+const SeverityWarning string = "warning" // Data publishable but likely wrong
+
+// Ids of the validation rules.
+const RuleLineIncomplete string = "line-incomplete"
+const RuleStopIncomplete string = "stop-incomplete"
+const RuleStopNoSchedule string = "stop-no-schedule"
+const RuleStopOutsideArea string = "stop-outside-area"
+const RuleTimesNotMonotonic string = "times-not-monotonic"
+const RuleImpossibleSpeed string = "impossible-speed"
+const RuleUnknownConnection string = "unknown-connection"
+const RuleDirectionAsymmetry string = "direction-asymmetry"
+const RuleTimetablesIdentical string = "timetables-identical"
+
+const minutesPerDay int = 24 * 60
+
+// ServiceArea is the area every stop has to be in (Bizkaia).
+var ServiceArea = BoundingBox{Point{42.95, -3.50}, Point{43.50, -2.40}}
+
+// MaxTravelSpeed is the maximum speed (km/h) between consecutive stops.
+var MaxTravelSpeed = 90.0
+
+// MaxDirectionAsymmetry is the maximum difference in number of stops
+// between both directions of a line, relative to the longest one.
+var MaxDirectionAsymmetry = 0.5
+
+// Finding is an issue found in the data by a validation rule.
+type Finding struct {
+	Severity string `json:"Severity"`
+	Rule     string `json:"Rule"`
+	LineId   string `json:"Line,omitempty"`
+	StopId   string `json:"Stop,omitempty"`
+	Message  string `json:"Message"`
+}
+
+// ValidationRule checks the lines and reports the findings with the
+// severity of the rule.
+type ValidationRule struct {
+	Id       string
+	Severity string
+	Check    func(lines []Line, report func(lineId, stopId, message string))
+}
+
+// ValidationReport holds the findings of all the rules.
+type ValidationReport struct {
+	Findings []Finding
+}
+
+// DefaultValidationRules are the rules run by CheckConsistency.
+var DefaultValidationRules = []ValidationRule{
+	{RuleLineIncomplete, SeverityError, checkLineComplete},
+	{RuleStopIncomplete, SeverityError, checkStopComplete},
+	{RuleStopNoSchedule, SeverityWarning, checkStopSchedule},
+	{RuleStopOutsideArea, SeverityError, checkStopArea},
+	{RuleTimesNotMonotonic, SeverityWarning, checkTimesMonotonic},
+	{RuleImpossibleSpeed, SeverityWarning, checkTravelSpeed},
+	{RuleUnknownConnection, SeverityWarning, checkConnections},
+	{RuleDirectionAsymmetry, SeverityWarning, checkDirectionSymmetry},
+	{RuleTimetablesIdentical, SeverityWarning, checkTimetablesDiffer},
+}
+
+// CheckConsistency verifies that the output data is consistent
+// with the information published by the transit agency. Runs every
+// default rule and returns the report as text, and an error if
+// any finding has severity error.
+func CheckConsistency(td TransitData) (report string, err error) {
+	r := ValidateLines(td.lines, DefaultValidationRules)
+	if errorsFound := r.Count(SeverityError); errorsFound > 0 {
+		return r.Text(), fmt.Errorf("%d consistency errors found", errorsFound)
+	}
+	return r.Text(), nil
+}
+
+// ValidateLines runs all the rules over the lines. Findings are sorted
+// by line, stop and rule.
+func ValidateLines(lines []Line, rules []ValidationRule) ValidationReport {
+	var r ValidationReport
+	for _, rule := range rules {
+		rule.Check(lines, func(lineId, stopId, message string) {
+			r.Findings = append(r.Findings, Finding{rule.Severity, rule.Id, lineId, stopId, message})
+		})
+	}
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		a, b := r.Findings[i], r.Findings[j]
+		if a.LineId != b.LineId {
+			return a.LineId < b.LineId
+		}
+		if a.StopId != b.StopId {
+			return a.StopId < b.StopId
+		}
+		return a.Rule < b.Rule
+	})
+	return r
+}
+
+// Count returns the number of findings with severity.
+func (r ValidationReport) Count(severity string) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Text returns the report as text, a finding per line.
+func (r ValidationReport) Text() string {
+	var str strings.Builder
+	fmt.Fprintf(&str, "\n------ Validation: %d errors, %d warnings -------",
+		r.Count(SeverityError), r.Count(SeverityWarning))
+	for _, f := range r.Findings {
+		fmt.Fprintf(&str, "\n[%v] %v", f.Severity, f.Rule)
+		if len(f.LineId) > 0 {
+			str.WriteString(" line " + f.LineId)
+		}
+		if len(f.StopId) > 0 {
+			str.WriteString(" stop " + f.StopId)
+		}
+		str.WriteString(": " + f.Message)
+	}
+	return str.String()
+}
+
+// JSON returns the findings as JSON.
+func (r ValidationReport) JSON() (string, error) {
+	findings := r.Findings
+	if findings == nil {
+		findings = make([]Finding, 0)
+	}
+	b, err := json.MarshalIndent(findings, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func checkLineComplete(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		var missing []string
+		if len(l.Stops) == 0 {
+			missing = append(missing, "stops")
+		}
+		if l.Name == "" {
+			missing = append(missing, "name")
+		}
+		if l.Number == 0 {
+			missing = append(missing, "number")
+		}
+		if l.AgencyId == "" {
+			missing = append(missing, "agencyId")
+		}
+		if l.Direction == "" {
+			missing = append(missing, "direction")
+		}
+		if len(l.MapRoute) == 0 {
+			missing = append(missing, "map route")
+		}
+		if len(missing) > 0 {
+			report(l.Id, "", "No "+strings.Join(missing, ", "))
+		}
+	}
+}
+
+func checkStopComplete(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			if s.Location.Lat == "" || s.Location.Long == "" {
+				report(l.Id, s.Id, "No location")
+			} else if _, err := s.Location.ToPoint(); err != nil {
+				report(l.Id, s.Id, err.Error())
+			}
+			if s.Name == "" {
+				report(l.Id, s.Id, "No name")
+			}
+		}
+	}
+}
+
+func checkStopSchedule(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			if len(timetableDays(s.Schedule)) == 0 {
+				report(l.Id, s.Id, "No schedule for stop "+s.Name)
+			}
+		}
+	}
+}
+
+func checkStopArea(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			if p, err := s.Location.ToPoint(); err == nil && !ServiceArea.Contains(p) {
+				report(l.Id, s.Id, fmt.Sprintf("Location %v,%v out of the service area", s.Location.Lat, s.Location.Long))
+			}
+		}
+	}
+}
+
+// checkTimesMonotonic reports stops whose departures of a day go back in
+// time. Departures after midnight following late ones are next day's.
+func checkTimesMonotonic(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			for _, day := range timetableDays(s.Schedule) {
+				times, err := parseDepartures(day.times)
+				if err != nil {
+					report(l.Id, s.Id, fmt.Sprintf("%v: %v", day.name, err))
+					continue
+				}
+				for i := 1; i < len(times); i++ {
+					if times[i] <= times[i-1] {
+						report(l.Id, s.Id, fmt.Sprintf("%v: departure %v after %v", day.name,
+							formatMinutes(times[i]), formatMinutes(times[i-1])))
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// checkTravelSpeed reports consecutive stops whose departures (the n-th of
+// one and the n-th of the next, when both have the same number) are too
+// close in time for the distance between them.
+func checkTravelSpeed(lines []Line, report func(lineId, stopId, message string)) {
+	for _, l := range lines {
+		for i := 1; i < len(l.Stops); i++ {
+			from, to := l.Stops[i-1], l.Stops[i]
+			distance, ok := stopsDistance(from, to)
+			if !ok {
+				continue
+			}
+			departures := make(map[string]string)
+			for _, day := range timetableDays(from.Schedule) {
+				departures[day.name] = day.times
+			}
+			for _, day := range timetableDays(to.Schedule) {
+				fromTimes, errFrom := parseDepartures(departures[day.name])
+				toTimes, errTo := parseDepartures(day.times)
+				if errFrom != nil || errTo != nil || len(fromTimes) != len(toTimes) {
+					continue
+				}
+				for j := range toTimes {
+					if toTimes[j]+minutesPerDay-fromTimes[j] < minutesPerDay/2 {
+						toTimes[j] += minutesPerDay // Past midnight
+					}
+					// Times have minute resolution
+					minutes := math.Max(1, float64(toTimes[j]-fromTimes[j]))
+					if speed := distance / 1000 / (minutes / 60); toTimes[j] < fromTimes[j] || speed > MaxTravelSpeed {
+						report(l.Id, to.Id, fmt.Sprintf("%v: %.0f m from stop %v in %v min (%v to %v)", day.name, distance,
+							from.Id, toTimes[j]-fromTimes[j], formatMinutes(fromTimes[j]), formatMinutes(toTimes[j])))
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func checkConnections(lines []Line, report func(lineId, stopId, message string)) {
+	known := make(map[string]bool)
+	for _, l := range lines {
+		known[l.Id] = true
+	}
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			for _, c := range s.Connections {
+				if !known[c.LineId] {
+					report(l.Id, s.Id, "Connection to unknown line "+c.LineId)
+				}
+			}
+		}
+	}
+}
+
+// checkDirectionSymmetry reports lines whose directions differ in number
+// of stops more than MaxDirectionAsymmetry.
+func checkDirectionSymmetry(lines []Line, report func(lineId, stopId, message string)) {
+	forward := make(map[string]Line)
+	for _, l := range lines {
+		if l.Direction == DirectionForward {
+			forward[l.AgencyId+"/"+strconv.Itoa(l.Number)] = l
+		}
+	}
+	for _, b := range lines {
+		f, found := forward[b.AgencyId+"/"+strconv.Itoa(b.Number)]
+		if b.Direction != DirectionBackward || !found {
+			continue
+		}
+		longest := math.Max(float64(len(f.Stops)), float64(len(b.Stops)))
+		if longest > 0 && math.Abs(float64(len(f.Stops)-len(b.Stops)))/longest > MaxDirectionAsymmetry {
+			report(b.Id, "", fmt.Sprintf("%v stops, %v stops in line %v", len(b.Stops), len(f.Stops), f.Id))
+		}
+	}
+}
+
+// checkTimetablesDiffer reports lines with two types of day that are
+// published apart (e.g. Monday to Thursday and Friday) but have the same
+// departures in every stop, likely the same schedule parsed twice.
+func checkTimetablesDiffer(lines []Line, report func(lineId, stopId, message string)) {
+	pairs := [][2]string{{DayMondayToThursday, DayFriday}, {DayWeekday, DaySaturday}, {DaySaturday, DaySunday}}
+	for _, l := range lines {
+		for _, p := range pairs {
+			compared, identical := 0, true
+			for _, s := range l.Stops {
+				departures := make(map[string]string)
+				for _, day := range timetableDays(s.Schedule) {
+					departures[day.name] = day.times
+				}
+				first, second := departures[p[0]], departures[p[1]]
+				if len(first) == 0 || len(second) == 0 {
+					continue
+				}
+				compared++
+				if first != second {
+					identical = false
+					break
+				}
+			}
+			if compared > 0 && identical {
+				report(l.Id, "", fmt.Sprintf("%v and %v timetables are identical in all the stops", p[0], p[1]))
+			}
+		}
+	}
+}
+
+// stopsDistance returns the distance in meters between the stops, along
+// the map route if known.
+func stopsDistance(from, to Stop) (float64, bool) {
+	if to.ShapeDistance > from.ShapeDistance {
+		return float64(to.ShapeDistance - from.ShapeDistance), true
+	}
+	a, errA := from.Location.ToPoint()
+	b, errB := to.Location.ToPoint()
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return Distance(a, b), true
+}
+
+type timetableDay struct {
+	name, times string
+}
+
+// timetableDays returns the days of the timetable with departures.
+func timetableDays(t Timetable) []timetableDay {
+	var days []timetableDay
+	for _, d := range []timetableDay{{DayWeekday, t.Weekday}, {DayMondayToThursday, t.MondayToThrusday},
+		{DayFriday, t.Friday}, {DaySaturday, t.Saturday}, {DaySunday, t.Sunday},
+		{DayFridayNight, t.FridayNight}, {DaySaturdayNight, t.SaturdayNight}, {DayHolidayEve, t.HolidayEve}} {
+		if len(d.times) > 0 {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// parseDepartures parses the departures (HH:MM separated by commas) in
+// minutes since the start of the day. Departures after midnight that
+// follow late ones (less than 12 hours before) belong to the next day.
+func parseDepartures(times string) ([]int, error) {
+	var minutes []int
+	for _, t := range strings.Split(times, ",") {
+		t = strings.TrimSpace(t)
+		if len(t) == 0 {
+			continue
+		}
+		parts := strings.Split(t, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid departure %v", t)
+		}
+		h, errH := strconv.Atoi(parts[0])
+		m, errM := strconv.Atoi(parts[1])
+		if errH != nil || errM != nil || h < 0 || m < 0 || m > 59 {
+			return nil, fmt.Errorf("Invalid departure %v", t)
+		}
+		v := h*60 + m
+		if len(minutes) > 0 && v < minutes[len(minutes)-1] && v+minutesPerDay-minutes[len(minutes)-1] < minutesPerDay/2 {
+			v += minutesPerDay
+		}
+		minutes = append(minutes, v)
+	}
+	return minutes, nil
+}
+
+// formatMinutes formats minutes since the start of the day as HH:MM.
+func formatMinutes(m int) string {
+	return fmt.Sprintf("%02d:%02d", (m/60)%24, m%60)
+}
@@ -0,0 +1,126 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func validationTestLines() []Line {
+	forward := shapeTestLine("I01")
+	forward.MapRoute = []Coordinates{{"43.2630", "-2.9350"}, {"43.2610", "-2.9310"}}
+	times := []string{"06:30,07:00", "06:32,07:02", "06:34,07:04"}
+	for i := range forward.Stops {
+		forward.Stops[i].Name = "Moyua"
+		forward.Stops[i].Schedule = Timetable{Weekday: times[i]}
+	}
+	backward := forward
+	backward.Id, backward.Direction = "V01", DirectionBackward
+	backward.Stops = append([]Stop(nil), forward.Stops...)
+	forward.Stops[0].Connections = ParseConnections("V01")
+	return []Line{forward, backward}
+}
+
+func TestValidateLines(t *testing.T) {
+	log.Printf("---------- TestValidateLines ------------ ")
+	testCases := []struct {
+		name     string
+		modify   func(lines []Line) []Line
+		expected []Finding
+	}{
+		{"valid", func(lines []Line) []Line { return lines }, nil},
+		{"incomplete line", func(lines []Line) []Line {
+			lines[1].Name, lines[1].MapRoute = "", nil
+			return lines
+		}, []Finding{{SeverityError, RuleLineIncomplete, "V01", "", "No name, map route"}}},
+		{"incomplete stop", func(lines []Line) []Line {
+			lines[0].Stops[1].Location.Lat = ""
+			lines[0].Stops[1].Schedule = Timetable{}
+			return lines
+		}, []Finding{
+			{SeverityError, RuleStopIncomplete, "I01", "0002", "No location"},
+			{SeverityWarning, RuleStopNoSchedule, "I01", "0002", "No schedule for stop Moyua"},
+		}},
+		{"outside Bizkaia", func(lines []Line) []Line {
+			lines[0].Stops[2].Location = Coordinates{"40.4168", "-3.7038"}
+			return lines
+		}, []Finding{
+			{SeverityWarning, RuleImpossibleSpeed, "I01", "0003", "Weekday: 322639 m from stop 0002 in 2 min (06:32 to 06:34)"},
+			{SeverityError, RuleStopOutsideArea, "I01", "0003", "Location 40.4168,-3.7038 out of the service area"},
+		}},
+		{"not monotonic", func(lines []Line) []Line {
+			lines[1].Stops[0].Schedule = Timetable{Weekday: "06:30,06:00", Sunday: "23:30,00:15"}
+			return lines
+		}, []Finding{{SeverityWarning, RuleTimesNotMonotonic, "V01", "0001", "Weekday: departure 06:00 after 06:30"}}},
+		{"too fast", func(lines []Line) []Line {
+			lines[1].Stops[2].Schedule = Timetable{Weekday: "06:31,07:05"}
+			return lines
+		}, []Finding{{SeverityWarning, RuleImpossibleSpeed, "V01", "0003", "Weekday: 162 m from stop 0002 in -1 min (06:32 to 06:31)"}}},
+		{"unknown connection", func(lines []Line) []Line {
+			lines[1].Stops[0].Connections = ParseConnections("I01 I99")
+			return lines
+		}, []Finding{{SeverityWarning, RuleUnknownConnection, "V01", "0001", "Connection to unknown line I99"}}},
+		{"asymmetric", func(lines []Line) []Line {
+			lines[1].Stops = lines[1].Stops[:1]
+			return lines
+		}, []Finding{{SeverityWarning, RuleDirectionAsymmetry, "V01", "", "1 stops, 3 stops in line I01"}}},
+		{"identical Friday", func(lines []Line) []Line {
+			for i, s := range lines[0].Stops {
+				lines[0].Stops[i].Schedule = Timetable{MondayToThrusday: s.Schedule.Weekday, Friday: s.Schedule.Weekday}
+			}
+			return lines
+		}, []Finding{{SeverityWarning, RuleTimetablesIdentical, "I01", "", "MondayToThursday and Friday timetables are identical in all the stops"}}},
+		{"different Friday", func(lines []Line) []Line {
+			for i, s := range lines[0].Stops {
+				lines[0].Stops[i].Schedule = Timetable{MondayToThrusday: s.Schedule.Weekday, Friday: s.Schedule.Weekday}
+			}
+			lines[0].Stops[2].Schedule.Friday = "06:34,07:04,23:04"
+			return lines
+		}, nil},
+	}
+	for _, tc := range testCases {
+		r := ValidateLines(tc.modify(validationTestLines()), DefaultValidationRules)
+		if len(r.Findings) != len(tc.expected) {
+			t.Errorf("%v: expected %v, actual %v", tc.name, tc.expected, r.Findings)
+			continue
+		}
+		for i, f := range r.Findings {
+			if f != tc.expected[i] {
+				t.Errorf("%v: expected %v, actual %v", tc.name, tc.expected[i], f)
+			}
+		}
+	}
+}
+
+func TestValidationReportFormats(t *testing.T) {
+	log.Printf("---------- TestValidationReportFormats ------------ ")
+	lines := validationTestLines()
+	lines[0].Number = 0
+	lines[1].Stops[0].Connections = ParseConnections("I99")
+	r := ValidateLines(lines, DefaultValidationRules)
+
+	text := r.Text()
+	for _, expected := range []string{"1 errors, 1 warnings", "[error] line-incomplete line I01: No number",
+		"[warning] unknown-connection line V01 stop 0001: Connection to unknown line I99"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %v in report %v", expected, text)
+		}
+	}
+
+	s, err := r.JSON()
+	var findings []Finding
+	if err != nil || json.Unmarshal([]byte(s), &findings) != nil || len(findings) != 2 || findings[1] != r.Findings[1] {
+		t.Errorf("Unexpected JSON report %v (%v)", s, err)
+	}
+	if s, _ := (ValidationReport{}).JSON(); s != "[]" {
+		t.Errorf("Expected empty list of findings, actual %v", s)
+	}
+
+	if _, err := CheckConsistency(TransitData{lines: lines}); err == nil {
+		t.Errorf("Expected consistency error")
+	}
+	if _, err := CheckConsistency(TransitData{lines: validationTestLines()}); err != nil {
+		t.Errorf("Unexpected consistency error %v", err)
+	}
+}
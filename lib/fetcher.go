@@ -3,22 +3,77 @@
 package transit
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/secsy/goftp"
 	"log"
 )
 
+// Constants
+const envFTPUser string = "FTP_USER"
+const envFTPPassword string = "FTP_PASSWORD"
+const envFTPActiveMode string = "FTP_ACTIVE_MODE"
+const envFTPTimeout string = "FTP_TIMEOUT"
+const envFTPSImplicit string = "FTPS_IMPLICIT"
+const envFTPSRootCA string = "FTPS_ROOT_CA"
+const ftpDefaultPort string = "21"
+const ftpsImplicitDefaultPort string = "990"
+
 var MaxDownloadRetries int = 10
+var DefaultFTPTimeout = 30 * time.Second
+
+// Fetch is a type of function that retrieves the resource the url
+// points to and stores it in destPath. There is one per url scheme.
+type Fetch func(u *url.URL, destPath string) error
+
+// Fetchers registered per url scheme.
+var fetchers = map[string]Fetch{
+	"http":  fetchHTTP,
+	"https": fetchHTTP,
+	"ftp":   fetchFTP,
+	"ftps":  fetchFTP,
+	"file":  fetchFile,
+	"sftp":  fetchSFTP,
+	"s3":    fetchS3,
+}
+var fetchersLock sync.RWMutex
+
+// RegisterFetcher makes f the fetcher of the urls with the given scheme,
+// replacing the previous one (if any).
+func RegisterFetcher(scheme string, f Fetch) {
+	fetchersLock.Lock()
+	defer fetchersLock.Unlock()
+	fetchers[strings.ToLower(scheme)] = f
+}
+
+// getFetcher returns the fetcher registered for the given scheme.
+func getFetcher(scheme string) (Fetch, error) {
+	fetchersLock.RLock()
+	defer fetchersLock.RUnlock()
+	f, found := fetchers[strings.ToLower(scheme)]
+	if !found {
+		return nil, fmt.Errorf("Unknown protocol %v", scheme)
+	}
+	return f, nil
+}
 
 // Pulls down the content of the provided url and stores in filepath.
+// The download is retried until validate accepts the file or
+// MaxDownloadRetries is reached.
 // If anything goes wrong it returns an error otherwise nil
-func Download(address string, fileFullPath string, validateFunc func(string)bool) (err error) {
+func Download(address string, fileFullPath string, validate Validate) (err error) {
 	// Create the folder
 	os.MkdirAll(filepath.Dir(fileFullPath), os.ModePerm)
 
@@ -29,20 +84,54 @@ func Download(address string, fileFullPath string, validateFunc func(string)bool
 		return err
 	}
 
+	fetch, err := getFetcher(u.Scheme)
+	if err != nil {
+		return fmt.Errorf("Unknown protocol for url %v", address)
+	}
+
 	retries := 0
-	validated := false
-	for retries<=MaxDownloadRetries && !validated {
+	var rejection error
+	for retries<=MaxDownloadRetries {
 		retries++
-		if u.Scheme == "http" || u.Scheme == "https" {
-			err = fetchHTTP(u, fileFullPath)
-		} else if u.Scheme == "ftp" {
-			err = fetchFTP(u, fileFullPath)
-		} else {
-			err = fmt.Errorf("Unknown protocol for url %v", address)
+		err = fetch(u, fileFullPath)
+		if rejection = validate(fileFullPath); rejection == nil {
+			log.Printf("%v downloaded OK", fileFullPath)
+			break
 		}
-		validated = validateFunc(fileFullPath)
-		log.Printf("%v downloaded OK", fileFullPath)
+		log.Printf("%v rejected (attempt %v): %v", fileFullPath, retries, rejection)
 	}
+
+	if err == nil && rejection != nil {
+		err = fmt.Errorf("Download of %v rejected: %v", address, rejection)
+	}
+	return err
+}
+
+// fetchFile copies the local file url points to.
+// Output file is created in destPath.
+func fetchFile(u *url.URL, destPath string) error {
+	in, err := os.Open(filepath.FromSlash(u.Path))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(in, destPath)
+}
+
+// writeFile creates a file in destPath with the content read from r.
+func writeFile(r io.Reader, destPath string) (err error) {
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	_, err = io.Copy(out, r)
 	return err
 }
 
@@ -76,26 +165,105 @@ func fetchHTTP(u *url.URL, destPath string) error {
 	return nil
 }
 
-// fetchFTP retrieves the file url points to via FTP.
+// fetchFTP retrieves the file url points to via FTP or FTPS.
 // Output file is created in destPath.
 func fetchFTP(u *url.URL, destPath string) (err error) {
+	config, err := ftpConfig(u)
+	if err != nil {
+		return err
+	}
 
-	// Create client object with default config
-	client, err := goftp.Dial(u.Hostname())
+	client, err := goftp.DialConfig(config, ftpAddress(u, config))
 	if err != nil {
 		return err
 	}
+	defer client.Close()
 
 	// Download a file to disk
-	readme, err := os.Create(destPath)
+	out, err := os.Create(destPath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	return client.Retrieve(u.Path, out)
+}
+
+// ftpConfig builds the configuration of the FTP client for u.
+// Credentials in the url take precedence over the ones configured
+// in environment (FTP_USER and FTP_PASSWORD). Without any, the
+// login is anonymous.
+func ftpConfig(u *url.URL) (goftp.Config, error) {
+	config := goftp.Config{
+		User:               os.Getenv(envFTPUser),
+		Password:           os.Getenv(envFTPPassword),
+		ConnectionsPerHost: 1,
+		Timeout:            DefaultFTPTimeout,
+		ActiveTransfers:    GetEnvVariableValueBool(envFTPActiveMode),
+	}
+
+	if u.User != nil {
+		config.User = u.User.Username()
+		config.Password, _ = u.User.Password()
+	}
+
+	if t := os.Getenv(envFTPTimeout); len(t) > 0 {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return config, fmt.Errorf("Invalid value %v of %v: %v", t, envFTPTimeout, err)
+		}
+		config.Timeout = timeout
+	}
 
-	err = client.Retrieve(u.RequestURI(), readme)
+	if u.Scheme == "ftps" {
+		tlsConfig, err := ftpsTLSConfig(u)
+		if err != nil {
+			return config, err
+		}
+		config.TLSConfig = tlsConfig
+		config.TLSMode = goftp.TLSExplicit
+		if GetEnvVariableValueBool(envFTPSImplicit) {
+			config.TLSMode = goftp.TLSImplicit
+		}
+	}
+
+	return config, nil
+}
+
+// ftpsTLSConfig returns the TLS configuration to connect to u. Servers
+// with certificates signed by a private CA are trusted if the CA is
+// supplied in FTPS_ROOT_CA (PEM file).
+func ftpsTLSConfig(u *url.URL) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	caPath := os.Getenv(envFTPSRootCA)
+	if len(caPath) == 0 {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(caPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %v", caPath)
+	}
+	return tlsConfig, nil
+}
 
-	return nil
+// ftpAddress returns host:port of the FTP server of u. When the url
+// has no port, the default one of the protocol is used.
+func ftpAddress(u *url.URL, config goftp.Config) string {
+	port := u.Port()
+	if len(port) == 0 {
+		port = ftpDefaultPort
+		if config.TLSConfig != nil && config.TLSMode == goftp.TLSImplicit {
+			port = ftpsImplicitDefaultPort
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
 }
@@ -0,0 +1,245 @@
+package transit
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeFTPServer is a minimal in-process FTP server. It supports login,
+// passive (EPSV/PASV) and active (EPRT/PORT) transfers of the files
+// it holds in memory. Silent servers accept connections but never talk,
+// which is useful to exercise client timeouts. With TLSConfig, the
+// server speaks implicit FTPS (control and passive data connections).
+type fakeFTPServer struct {
+	User      string
+	Password  string
+	Files     map[string]string
+	Silent    bool
+	TLSConfig *tls.Config
+
+	listener net.Listener
+	mu       sync.Mutex
+	commands []string
+}
+
+// Start listens in a random local port and serves the clients.
+func (s *fakeFTPServer) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	s.listener = l
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return nil
+}
+
+// Close stops listening for clients.
+func (s *fakeFTPServer) Close() {
+	s.listener.Close()
+}
+
+// Addr returns host:port of the server.
+func (s *fakeFTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Commands returns the list of commands received (without arguments).
+func (s *fakeFTPServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *fakeFTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	if s.Silent {
+		buf := make([]byte, 1)
+		conn.Read(buf) // Hold the connection until client gives up
+		return
+	}
+
+	reply := func(format string, a ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", a...)
+	}
+
+	var user string
+	var logged bool
+	var passive net.Listener
+	var active string
+	defer func() {
+		if passive != nil {
+			passive.Close()
+		}
+	}()
+
+	reply("220 Fake agency FTP")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		parts := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+		cmd := strings.ToUpper(parts[0])
+		arg := ""
+		if len(parts) > 1 {
+			arg = parts[1]
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+
+		switch cmd {
+		case "USER":
+			user = arg
+			reply("331 Password required")
+			continue
+		case "PASS":
+			if user == s.User && arg == s.Password {
+				logged = true
+				reply("230 Logged in")
+			} else {
+				reply("530 Login incorrect")
+			}
+			continue
+		case "FEAT":
+			reply("211-Features:\r\n SIZE\r\n EPSV\r\n211 End")
+			continue
+		case "QUIT":
+			reply("221 Bye")
+			return
+		}
+
+		if !logged {
+			reply("530 Not logged in")
+			continue
+		}
+
+		switch cmd {
+		case "TYPE", "OPTS", "PBSZ", "PROT", "MODE", "STRU":
+			reply("200 OK")
+		case "PWD":
+			reply(`257 "/"`)
+		case "SIZE":
+			if content, found := s.Files[arg]; found {
+				reply("213 %d", len(content))
+			} else {
+				reply("550 File not found")
+			}
+		case "EPSV", "PASV":
+			if passive != nil {
+				passive.Close()
+			}
+			if passive, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 Can't open data connection")
+				continue
+			}
+			port := passive.Addr().(*net.TCPAddr).Port
+			if s.TLSConfig != nil {
+				passive = tls.NewListener(passive, s.TLSConfig)
+			}
+			active = ""
+			if cmd == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256)
+			}
+		case "EPRT":
+			// |1|127.0.0.1|port|
+			f := strings.Split(arg, "|")
+			if len(f) < 4 {
+				reply("501 Bad arguments")
+				continue
+			}
+			active = net.JoinHostPort(f[2], f[3])
+			reply("200 OK")
+		case "PORT":
+			// h1,h2,h3,h4,p1,p2
+			f := strings.Split(arg, ",")
+			if len(f) != 6 {
+				reply("501 Bad arguments")
+				continue
+			}
+			p1, _ := strconv.Atoi(f[4])
+			p2, _ := strconv.Atoi(f[5])
+			active = net.JoinHostPort(strings.Join(f[:4], "."), strconv.Itoa(p1*256+p2))
+			reply("200 OK")
+		case "REST":
+			reply("350 Restarting")
+		case "RETR":
+			content, found := s.Files[arg]
+			if !found {
+				reply("550 File not found")
+				continue
+			}
+			reply("150 Opening data connection")
+			var data net.Conn
+			if len(active) > 0 {
+				data, err = net.Dial("tcp", active)
+			} else if passive != nil {
+				data, err = passive.Accept()
+			} else {
+				err = fmt.Errorf("no data connection")
+			}
+			if err != nil {
+				reply("425 Can't open data connection")
+				continue
+			}
+			fmt.Fprint(data, content)
+			data.Close()
+			reply("226 Transfer complete")
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// newFakeFTPSCertificate returns the TLS configuration of a FTPS server
+// of 127.0.0.1 and, as PEM, its self-signed certificate.
+func newFakeFTPSCertificate() (*tls.Config, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake agency FTPS"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return config, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
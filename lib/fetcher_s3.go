@@ -0,0 +1,184 @@
+package transit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Constants
+const envS3Endpoint string = "S3_ENDPOINT"
+const envS3Region string = "S3_REGION"
+const envS3AccessKey string = "AWS_ACCESS_KEY_ID"
+const envS3SecretKey string = "AWS_SECRET_ACCESS_KEY"
+const envS3SessionToken string = "AWS_SESSION_TOKEN"
+const envS3Timeout string = "S3_TIMEOUT"
+const s3DefaultRegion string = "us-east-1"
+const s3SigningAlgorithm string = "AWS4-HMAC-SHA256"
+const s3EmptyPayloadHash string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+var DefaultS3Timeout = 30 * time.Second
+
+// fetchS3 retrieves the object url (s3://bucket/key) points to from an
+// S3 compatible object store. The store is AWS unless S3_ENDPOINT
+// tells otherwise (e.g. http://minio.local:9000). Objects are addressed
+// path-style and requests are signed with AWS signature version 4.
+// S3_TIMEOUT overrides DefaultS3Timeout.
+// Output file is created in destPath.
+func fetchS3(u *url.URL, destPath string) error {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if len(bucket) == 0 || len(key) == 0 {
+		return fmt.Errorf("Invalid S3 url %v. Expected s3://bucket/key", u)
+	}
+
+	region := os.Getenv(envS3Region)
+	if len(region) == 0 {
+		region = s3DefaultRegion
+	}
+	endpoint := os.Getenv(envS3Endpoint)
+	if len(endpoint) == 0 {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid value %v of %v: %v", endpoint, envS3Endpoint, err)
+	}
+	object := &url.URL{
+		Scheme: base.Scheme,
+		Host:   base.Host,
+		Path:   strings.TrimSuffix(base.Path, "/") + "/" + bucket + "/" + key,
+	}
+	// Keys are sent escaped exactly as they are signed
+	object.RawPath = s3URIEncode(object.Path, false)
+
+	req, err := http.NewRequest(http.MethodGet, object.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	accessKey := os.Getenv(envS3AccessKey)
+	secretKey := os.Getenv(envS3SecretKey)
+	if len(accessKey) > 0 && len(secretKey) > 0 {
+		if token := os.Getenv(envS3SessionToken); len(token) > 0 {
+			req.Header.Set("X-Amz-Security-Token", token)
+		}
+		signS3Request(req, accessKey, secretKey, region, time.Now())
+	}
+
+	client := &http.Client{Timeout: DefaultS3Timeout}
+	if t := os.Getenv(envS3Timeout); len(t) > 0 {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return fmt.Errorf("Invalid value %v of %v: %v", t, envS3Timeout, err)
+		}
+		client.Timeout = timeout
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	return writeFile(resp.Body, destPath)
+}
+
+// signS3Request adds to req the authorization header of AWS signature
+// version 4, signing the host and all the headers already present in req.
+// Requests are expected to have no payload.
+func signS3Request(req *http.Request, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{s3SigningAlgorithm, amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", s3SigningAlgorithm+" Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3CanonicalQuery returns the query parameters sorted and encoded
+// as signature version 4 requires.
+func s3CanonicalQuery(values url.Values) string {
+	var params []string
+	for k, vs := range values {
+		for _, v := range vs {
+			params = append(params, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// s3URIEncode encodes every byte of s but the unreserved characters.
+// Slashes are kept unless encodeSlash is true.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
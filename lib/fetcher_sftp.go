@@ -0,0 +1,111 @@
+package transit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Constants
+const envSFTPUser string = "SFTP_USER"
+const envSFTPPassword string = "SFTP_PASSWORD"
+const envSFTPPrivateKey string = "SFTP_PRIVATE_KEY"
+const envSFTPKnownHosts string = "SFTP_KNOWN_HOSTS"
+const envSFTPTimeout string = "SFTP_TIMEOUT"
+const sftpDefaultPort string = "22"
+
+var DefaultSFTPTimeout = 30 * time.Second
+
+// fetchSFTP retrieves the file url points to via SFTP.
+// Output file is created in destPath.
+func fetchSFTP(u *url.URL, destPath string) error {
+	config, err := sftpConfig(u)
+	if err != nil {
+		return err
+	}
+
+	port := u.Port()
+	if len(port) == 0 {
+		port = sftpDefaultPort
+	}
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(u.Hostname(), port), config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	in, err := client.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(in, destPath)
+}
+
+// sftpConfig builds the configuration of the SSH client for u.
+// Credentials in the url take precedence over the ones configured in
+// environment (SFTP_USER and SFTP_PASSWORD). A private key can be supplied
+// as well in SFTP_PRIVATE_KEY. The server key is always verified against
+// the known hosts file in SFTP_KNOWN_HOSTS.
+func sftpConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	knownHostsPath := os.Getenv(envSFTPKnownHosts)
+	if len(knownHostsPath) == 0 {
+		return nil, errors.New("Env variable " + envSFTPKnownHosts + " is empty. Can not verify SFTP server " + u.Host)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            os.Getenv(envSFTPUser),
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         DefaultSFTPTimeout,
+	}
+
+	password := os.Getenv(envSFTPPassword)
+	if u.User != nil {
+		config.User = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	if len(password) > 0 {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+
+	if keyPath := os.Getenv(envSFTPPrivateKey); len(keyPath) > 0 {
+		pem, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid private key %v: %v", keyPath, err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+
+	if t := os.Getenv(envSFTPTimeout); len(t) > 0 {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value %v of %v: %v", t, envSFTPTimeout, err)
+		}
+		config.Timeout = timeout
+	}
+
+	return config, nil
+}
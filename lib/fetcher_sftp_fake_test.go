@@ -0,0 +1,107 @@
+package transit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSFTPServer is an in-process SFTP server that authenticates users
+// by password and serves the local file system.
+type fakeSFTPServer struct {
+	User     string
+	Password string
+
+	listener net.Listener
+	hostKey  ssh.Signer
+}
+
+// Start listens in a random local port and serves the clients.
+func (s *fakeSFTPServer) Start() error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if s.hostKey, err = ssh.NewSignerFromKey(key); err != nil {
+		return err
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == s.User && string(password) == s.Password {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(s.hostKey)
+
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn, config)
+		}
+	}()
+	return nil
+}
+
+// Close stops listening for clients.
+func (s *fakeSFTPServer) Close() {
+	s.listener.Close()
+}
+
+// Addr returns host:port of the server.
+func (s *fakeSFTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// HostKey returns the public key the server identifies itself with.
+func (s *fakeSFTPServer) HostKey() ssh.PublicKey {
+	return s.hostKey.PublicKey()
+}
+
+func (s *fakeSFTPServer) handle(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				// Payload is the subsystem name prefixed by its length
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}(requests)
+
+		server, err := sftp.NewServer(channel, sftp.ReadOnly())
+		if err != nil {
+			channel.Close()
+			continue
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
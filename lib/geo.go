@@ -0,0 +1,273 @@
+package transit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const earthRadiusMeters float64 = 6371008.8
+const metersPerDegreeLat float64 = 111320
+
+// DefaultIndexCellSize is the side in meters of the cells of the index
+// built over the stops of the transit data.
+const DefaultIndexCellSize float64 = 250
+
+// Point is a location in decimal degrees.
+type Point struct {
+	Lat, Long float64
+}
+
+// BoundingBox is the rectangle between its south-west (Min) and
+// north-east (Max) corners.
+type BoundingBox struct {
+	Min, Max Point
+}
+
+// StopDistance is a stop and its distance in meters to a given point.
+type StopDistance struct {
+	Stop     Stop    `json:"Stop"`
+	Distance float64 `json:"Dist"`
+}
+
+// gridCell identifies a cell of the grid of a StopIndex.
+type gridCell struct {
+	row, col int
+}
+
+// StopIndex is an in-memory spatial index of stops. Stops are bucketed
+// in a grid of cells of fixed size, so lookups only visit the cells
+// near the point queried.
+type StopIndex struct {
+	cellSize float64 // Degrees
+	cells    map[gridCell][]int
+	bounds   BoundingBox // Of the stops indexed
+	stops    []Stop
+	points   []Point
+}
+
+// ToPoint parses the coordinates. Returns error if they are empty,
+// not finite numbers or out of range.
+func (c Coordinates) ToPoint() (Point, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(c.Lat), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("Invalid latitude %q", c.Lat)
+	}
+	long, err := strconv.ParseFloat(strings.TrimSpace(c.Long), 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("Invalid longitude %q", c.Long)
+	}
+	if !isFinite(lat) || !isFinite(long) {
+		return Point{}, fmt.Errorf("Coordinates not finite %v,%v", lat, long)
+	}
+	if lat < -90 || lat > 90 || long < -180 || long > 180 {
+		return Point{}, fmt.Errorf("Coordinates out of range %v,%v", lat, long)
+	}
+	return Point{lat, long}, nil
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// Distance returns the great-circle (haversine) distance in meters
+// between a and b.
+func Distance(a, b Point) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLong := (b.Long - a.Long) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// NewBoundingBox returns the smallest box containing the points.
+func NewBoundingBox(points ...Point) BoundingBox {
+	if len(points) == 0 {
+		return BoundingBox{}
+	}
+	b := BoundingBox{points[0], points[0]}
+	for _, p := range points[1:] {
+		b = b.Extend(p)
+	}
+	return b
+}
+
+// BoundingBoxAround returns the box containing the circle of radius
+// meters centered in p.
+func BoundingBoxAround(p Point, radius float64) BoundingBox {
+	dLat := radius / metersPerDegreeLat
+	dLong := dLat / math.Max(math.Cos(p.Lat*math.Pi/180), 0.01)
+	return BoundingBox{Point{p.Lat - dLat, p.Long - dLong}, Point{p.Lat + dLat, p.Long + dLong}}
+}
+
+// Contains tells whether p is inside the box (borders included).
+func (b BoundingBox) Contains(p Point) bool {
+	return p.Lat >= b.Min.Lat && p.Lat <= b.Max.Lat && p.Long >= b.Min.Long && p.Long <= b.Max.Long
+}
+
+// Extend returns the smallest box containing b and p.
+func (b BoundingBox) Extend(p Point) BoundingBox {
+	return BoundingBox{Point{math.Min(b.Min.Lat, p.Lat), math.Min(b.Min.Long, p.Long)},
+		Point{math.Max(b.Max.Lat, p.Lat), math.Max(b.Max.Long, p.Long)}}
+}
+
+// StopIndex returns a spatial index of the stops.
+func (td TransitData) StopIndex() *StopIndex {
+	return NewStopIndex(td.stops, DefaultIndexCellSize)
+}
+
+// NewStopIndex indexes the stops in cells of cellMeters side. Stops
+// without valid location are left out.
+func NewStopIndex(stops []Stop, cellMeters float64) *StopIndex {
+	x := &StopIndex{cellSize: cellMeters / metersPerDegreeLat, cells: make(map[gridCell][]int)}
+	for _, s := range stops {
+		p, err := s.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		if len(x.points) == 0 {
+			x.bounds = NewBoundingBox(p)
+		}
+		x.bounds = x.bounds.Extend(p)
+		x.stops = append(x.stops, s)
+		x.points = append(x.points, p)
+		c := x.cell(p)
+		x.cells[c] = append(x.cells[c], len(x.stops)-1)
+	}
+	return x
+}
+
+// Len returns the number of stops indexed.
+func (x *StopIndex) Len() int {
+	return len(x.stops)
+}
+
+// WithinRadius returns the stops at radius meters or less from p,
+// sorted by distance.
+func (x *StopIndex) WithinRadius(p Point, radius float64) []StopDistance {
+	var result []StopDistance
+	x.visitCells(BoundingBoxAround(p, radius), func(i int) {
+		if d := Distance(p, x.points[i]); d <= radius {
+			result = append(result, StopDistance{x.stops[i], d})
+		}
+	})
+	sortStopDistances(result)
+	return result
+}
+
+// InBoundingBox returns the stops inside b, sorted by id.
+func (x *StopIndex) InBoundingBox(b BoundingBox) []Stop {
+	var result []Stop
+	x.visitCells(b, func(i int) {
+		if b.Contains(x.points[i]) {
+			result = append(result, x.stops[i])
+		}
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	return result
+}
+
+// Nearest returns the k stops closest to p, sorted by distance.
+// The search visits rings of cells around p, until the k closest stops
+// found are closer than any stop in the rings not visited yet, or the
+// rings cover the bounds of the stops indexed. If the rings would visit
+// more cells than stops indexed (p far from the stops), every stop is
+// checked instead.
+func (x *StopIndex) Nearest(p Point, k int) []StopDistance {
+	if k <= 0 || len(x.stops) == 0 || !isFinite(p.Lat) || !isFinite(p.Long) {
+		return nil
+	}
+
+	// Narrowest side of the cells, in meters, to bound the distance
+	// to the stops outside the rings visited.
+	cellMeters := x.cellSize * metersPerDegreeLat * math.Max(math.Cos(p.Lat*math.Pi/180), 0.01)
+	center := x.cell(p)
+	min, max := x.cell(x.bounds.Min), x.cell(x.bounds.Max)
+	lastRing := maxInt(maxInt(center.row-min.row, max.row-center.row), maxInt(center.col-min.col, max.col-center.col))
+	var candidates []StopDistance
+	for ring := 0; ring <= lastRing; ring++ {
+		if cells := (2*ring + 1) * (2*ring + 1); cells > len(x.stops) {
+			return x.nearestByScan(p, k)
+		}
+		for row := center.row - ring; row <= center.row+ring; row++ {
+			for col := center.col - ring; col <= center.col+ring; col++ {
+				if row != center.row-ring && row != center.row+ring && col != center.col-ring && col != center.col+ring {
+					continue // Visited in previous rings
+				}
+				for _, i := range x.cells[gridCell{row, col}] {
+					candidates = append(candidates, StopDistance{x.stops[i], Distance(p, x.points[i])})
+				}
+			}
+		}
+
+		sortStopDistances(candidates)
+		if len(candidates) >= k && candidates[k-1].Distance <= float64(ring)*cellMeters {
+			break
+		}
+	}
+
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// nearestByScan returns the k stops closest to p, checking every stop.
+func (x *StopIndex) nearestByScan(p Point, k int) []StopDistance {
+	candidates := make([]StopDistance, len(x.stops))
+	for i, s := range x.stops {
+		candidates[i] = StopDistance{s, Distance(p, x.points[i])}
+	}
+	sortStopDistances(candidates)
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// visitCells calls visit with the position of every stop in the
+// cells overlapping b. Only the cells within the bounds of the stops
+// indexed are visited.
+func (x *StopIndex) visitCells(b BoundingBox, visit func(i int)) {
+	b = BoundingBox{Point{math.Max(b.Min.Lat, x.bounds.Min.Lat), math.Max(b.Min.Long, x.bounds.Min.Long)},
+		Point{math.Min(b.Max.Lat, x.bounds.Max.Lat), math.Min(b.Max.Long, x.bounds.Max.Long)}}
+	if len(x.stops) == 0 || b.Min.Lat > b.Max.Lat || b.Min.Long > b.Max.Long {
+		return
+	}
+	min := x.cell(b.Min)
+	max := x.cell(b.Max)
+	for row := min.row; row <= max.row; row++ {
+		for col := min.col; col <= max.col; col++ {
+			for _, i := range x.cells[gridCell{row, col}] {
+				visit(i)
+			}
+		}
+	}
+}
+
+func (x *StopIndex) cell(p Point) gridCell {
+	return gridCell{int(math.Floor(p.Lat / x.cellSize)), int(math.Floor(p.Long / x.cellSize))}
+}
+
+// sortStopDistances sorts by distance, then by stop id.
+func sortStopDistances(s []StopDistance) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Distance != s[j].Distance {
+			return s[i].Distance < s[j].Distance
+		}
+		return s[i].Stop.Id < s[j].Stop.Id
+	})
+}
@@ -0,0 +1,185 @@
+package transit
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestToPoint(t *testing.T) {
+	log.Printf("---------- TestToPoint ------------ ")
+	testCases := []struct {
+		input    Coordinates
+		expected Point
+		valid    bool
+	}{
+		{Coordinates{"43.2630", "-2.9350"}, Point{43.2630, -2.9350}, true},
+		{Coordinates{" 43.2630", "-2.9350 "}, Point{43.2630, -2.9350}, true},
+		{Coordinates{"", "-2.9350"}, Point{}, false},
+		{Coordinates{"43.2630", "a"}, Point{}, false},
+		{Coordinates{"93.2630", "-2.9350"}, Point{}, false},
+		{Coordinates{"NaN", "-2.9350"}, Point{}, false},
+		{Coordinates{"43.2630", "-Inf"}, Point{}, false},
+	}
+	for _, tc := range testCases {
+		actual, err := tc.input.ToPoint()
+		if tc.valid && (err != nil || actual != tc.expected) {
+			t.Errorf("%v: expected %v, actual %v (%v)", tc.input, tc.expected, actual, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%v: expected error", tc.input)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	log.Printf("---------- TestDistance ------------ ")
+	testCases := []struct {
+		a, b     Point
+		expected float64 // meters
+	}{
+		{Point{43.2630, -2.9350}, Point{43.2630, -2.9350}, 0},
+		{Point{43.2630, -2.9350}, Point{43.2640, -2.9350}, 111.2},
+		{Point{43.2630, -2.9350}, Point{43.2630, -2.9370}, 161.9},
+		{Point{43.2630, -2.9350}, Point{43.2610, -2.9270}, 684.9},
+	}
+	for _, tc := range testCases {
+		if actual := Distance(tc.a, tc.b); math.Abs(actual-tc.expected) > 0.1 {
+			t.Errorf("Distance(%v, %v): expected %v, actual %v", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
+// randomStops returns n stops at random locations around Bilbao,
+// plus one without location.
+func randomStops(n int) []Stop {
+	r := rand.New(rand.NewSource(1))
+	var stops []Stop
+	for i := 0; i < n; i++ {
+		lat := 43.20 + r.Float64()*0.1
+		long := -3.00 + r.Float64()*0.1
+		stops = append(stops, Stop{Id: strconv.Itoa(i), Location: Coordinates{strconv.FormatFloat(lat, 'f', 6, 64), strconv.FormatFloat(long, 'f', 6, 64)}})
+	}
+	return append(stops, Stop{Id: "nolocation"})
+}
+
+// nearestByBruteForce returns the distances to the k stops nearest to p.
+func nearestByBruteForce(stops []Stop, p Point, k int) []float64 {
+	var distances []float64
+	for _, s := range stops {
+		if sp, err := s.Location.ToPoint(); err == nil {
+			distances = append(distances, Distance(p, sp))
+		}
+	}
+	sort.Float64s(distances)
+	if len(distances) > k {
+		distances = distances[:k]
+	}
+	return distances
+}
+
+func TestBoundingBox(t *testing.T) {
+	log.Printf("---------- TestBoundingBox ------------ ")
+	b := NewBoundingBox(Point{43.26, -2.93}, Point{43.25, -2.95}, Point{43.27, -2.94})
+	if b != (BoundingBox{Point{43.25, -2.95}, Point{43.27, -2.93}}) {
+		t.Errorf("Unexpected bounding box %v", b)
+	}
+	if !b.Contains(Point{43.26, -2.94}) || !b.Contains(b.Min) || b.Contains(Point{43.28, -2.94}) {
+		t.Errorf("Unexpected Contains results for %v", b)
+	}
+
+	around := BoundingBoxAround(Point{43.26, -2.94}, 1000)
+	north := Point{around.Max.Lat, -2.94}
+	east := Point{43.26, around.Max.Long}
+	if d := Distance(Point{43.26, -2.94}, north); math.Abs(d-1000) > 10 {
+		t.Errorf("Expected 1000 m to the north border, actual %v", d)
+	}
+	if d := Distance(Point{43.26, -2.94}, east); math.Abs(d-1000) > 10 {
+		t.Errorf("Expected 1000 m to the east border, actual %v", d)
+	}
+
+	stops := randomStops(200)
+	index := NewStopIndex(stops, 300)
+	expected := 0
+	for _, s := range stops {
+		if p, err := s.Location.ToPoint(); err == nil && b.Contains(p) {
+			expected++
+		}
+	}
+	if actual := index.InBoundingBox(b); len(actual) != expected {
+		t.Errorf("Expected %v stops in bounding box, actual %v", expected, len(actual))
+	}
+}
+
+func TestStopIndexNearest(t *testing.T) {
+	log.Printf("---------- TestStopIndexNearest ------------ ")
+	stops := randomStops(500)
+	index := NewStopIndex(stops, 100)
+	testCases := []struct {
+		p Point
+		k int
+	}{
+		{Point{43.25, -2.95}, 1},
+		{Point{43.25, -2.95}, 10},
+		{Point{43.21, -2.99}, 25},
+		{Point{43.50, -2.50}, 3}, // Far away from every stop
+		{Point{0, 0}, 2},
+		{Point{89.99, 179.99}, 1}, // Near the pole
+		{Point{43.25, -2.95}, 1000},
+		{Point{43.25, -2.95}, 0},
+	}
+	for _, tc := range testCases {
+		expected := nearestByBruteForce(stops, tc.p, tc.k)
+		actual := index.Nearest(tc.p, tc.k)
+		if len(actual) != len(expected) {
+			t.Errorf("%v k=%v: expected %v stops, actual %v", tc.p, tc.k, len(expected), len(actual))
+			continue
+		}
+		for i := range actual {
+			if actual[i].Distance != expected[i] {
+				t.Errorf("%v k=%v: stop %v expected distance %v, actual %v", tc.p, tc.k, i, expected[i], actual[i].Distance)
+				break
+			}
+		}
+	}
+
+	if actual := index.Nearest(Point{math.NaN(), -2.95}, 3); actual != nil {
+		t.Errorf("Expected no stops near NaN, actual %v", actual)
+	}
+	if actual := NewStopIndex(nil, 100).Nearest(Point{43.25, -2.95}, 3); actual != nil {
+		t.Errorf("Expected no stops from empty index, actual %v", actual)
+	}
+}
+
+func TestStopIndexWithinRadius(t *testing.T) {
+	log.Printf("---------- TestStopIndexWithinRadius ------------ ")
+	stops := randomStops(500)
+
+	index := NewStopIndex(stops, 200)
+	if index.Len() != 500 {
+		t.Errorf("Expected 500 stops indexed, actual %v", index.Len())
+	}
+
+	center := Point{43.25, -2.95}
+	for _, radius := range []float64{50, 300, 1000} {
+		expected := 0
+		for _, s := range stops[:500] {
+			p, _ := s.Location.ToPoint()
+			if Distance(center, p) <= radius {
+				expected++
+			}
+		}
+		actual := index.WithinRadius(center, radius)
+		if len(actual) != expected {
+			t.Errorf("Radius %v: expected %v stops, actual %v", radius, expected, len(actual))
+		}
+		for i := 1; i < len(actual); i++ {
+			if actual[i].Distance < actual[i-1].Distance {
+				t.Errorf("Radius %v: stops not sorted by distance", radius)
+			}
+		}
+	}
+}
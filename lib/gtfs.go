@@ -0,0 +1,605 @@
+package transit
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants
+const SourceGTFS string = "GTFS"
+const gtfsArchiveName string = "gtfs.zip"
+const gtfsFolderName string = "gtfs"
+const gtfsDirectionBackward string = "1"
+const gtfsServiceAdded string = "1"
+const gtfsDateLayout string = "20060102"
+
+// GTFSAgency is a parser of transit information of agencies publishing
+// a GTFS feed (e.g. Bizkaibus, Metro Bilbao or Euskotren).
+type GTFSAgency struct {
+	name       string
+	envSources string
+	data       TransitData
+}
+
+// gtfsStopTime is the time a trip departs from a stop.
+type gtfsStopTime struct {
+	stopId    string
+	sequence  int
+	departure int // Seconds since the start of the service day
+}
+
+// gtfsTrip is a trip of a route with its stop times sorted by sequence.
+type gtfsTrip struct {
+	id        string
+	routeId   string
+	serviceId string
+	direction string
+	shapeId   string
+	stopTimes []gtfsStopTime
+}
+
+// gtfsDayTypes tells on which types of day a service runs.
+type gtfsDayTypes struct {
+	mondayToThursday, friday, saturday, sunday bool
+}
+
+// weekday tells whether the service runs on any day from Monday to Friday.
+func (d gtfsDayTypes) weekday() bool {
+	return d.mondayToThursday || d.friday
+}
+
+// gtfsService tells the types of day a service runs, the ones of its
+// calendar and, apart, the ones of the dates added to it. Dates added
+// from Sunday to Thursday are eves of holidays for night services.
+type gtfsService struct {
+	calendar, added gtfsDayTypes
+}
+
+// days returns the types of day of the calendar and the dates added.
+func (s gtfsService) days() gtfsDayTypes {
+	return gtfsDayTypes{s.calendar.mondayToThursday || s.added.mondayToThursday, s.calendar.friday || s.added.friday,
+		s.calendar.saturday || s.added.saturday, s.calendar.sunday || s.added.sunday}
+}
+
+// fridayDiffers tells whether the service runs on Fridays but not from
+// Monday to Thursday, or the other way around.
+func (s gtfsService) fridayDiffers() bool {
+	days := s.days()
+	return days.friday != days.mondayToThursday
+}
+
+// NewGTFSAgency creates a GTFS agency named name, whose sources
+// are defined in the env variable envSources.
+func NewGTFSAgency(name, envSources string) *GTFSAgency {
+	return &GTFSAgency{name: name, envSources: envSources}
+}
+
+// Name returns the identifier of the agency.
+func (p GTFSAgency) Name() string {
+	return p.name
+}
+
+// Read the data sources from the env var.
+// Returns the list of sources.
+func (p GTFSAgency) GetSources() []TransitSource {
+	return GetSourcesFromEnv(p.envSources)
+}
+
+// Digest fetches and parses the GTFS feeds of the sources and builds
+// the data model. Sources that fail are skipped and their errors
+// returned together.
+func (p *GTFSAgency) Digest(ctx context.Context, sources []TransitSource) error {
+	var errs DigestErrors
+	lineNumbers := LoadLineRegistry(p.name, nil)
+	for _, s := range sources {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		var err error
+		switch s.Id {
+		case SourceGTFS:
+			var lines []Line
+			lines, err = parseGTFSSource(s, lineNumbers)
+			p.data.lines = append(p.data.lines, lines...)
+		case SourceShapes:
+			err = ShapesParser(ctx, &p.data.lines, s)
+		default:
+			log.Printf("%v: Unknown source id %v", p.name, s.Id)
+			errs = append(errs, fmt.Errorf("Unknown source id %v", s.Id))
+			continue
+		}
+		if err != nil {
+			log.Printf("%v: Error while processing source %v from path %v. Error: %v ", p.name, s.Id, s.Path, err)
+			errs = append(errs, fmt.Errorf("Source %v: %v", s.Id, err))
+		}
+	}
+
+	// All sources processed. Keep the line numbers, normalize names,
+	// apply the remediation rules, sort the lines and add the list of stops
+	errs = append(errs, lineNumbers.Errors()...)
+	errs = append(errs, lineNumbers.Save()...)
+	dictionary, err := LoadNameDictionary()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	NormalizeNames(p.data.lines, dictionary)
+	if p.data.remediations, err = remediate(p.name, &p.data.lines); err != nil {
+		errs = append(errs, err)
+	}
+	SortLines(p.data.lines)
+	tagNightLines(&p.data)
+	p.data.stops, _ = extractStops(p.name, p.data.lines)
+	BuildStations(&p.data)
+	return errs.ErrorOrNil()
+}
+
+// Observer that returns the list of lines for this transit.
+func (p GTFSAgency) Data() TransitData {
+	p.data.metadata = buildMetadata()
+	return p.data
+}
+
+// Lines returns the lines digested (all directions).
+func (p GTFSAgency) Lines() []Line {
+	return p.data.Lines()
+}
+
+// Line returns the digested line with the given id (e.g. I3411), if found.
+func (p GTFSAgency) Line(id string) (Line, bool) {
+	return p.data.Line(id)
+}
+
+// Stops returns the stops of line lineId (e.g. 3411) in direction.
+func (p GTFSAgency) Stops(lineId string, direction string) []Stop {
+	return p.data.Stops(lineId, direction)
+}
+
+// Stop returns the digested stop with the given id, if found.
+func (p GTFSAgency) Stop(id string) (Stop, bool) {
+	return p.data.Stop(id)
+}
+
+// parseGTFSSource downloads (unless cached) and extracts the GTFS feed of
+// the source in folder ts.Path and returns the lines it defines.
+func parseGTFSSource(ts TransitSource, lineNumbers *LineRegistry) ([]Line, error) {
+	archive := path.Join(ts.Path, gtfsArchiveName)
+	if !UseCachedData() || !Exists(archive) {
+		if err := Download(ts.Uri, archive, ValidateGTFSArchive); err != nil {
+			return nil, err
+		}
+	}
+
+	folder := path.Join(ts.Path, gtfsFolderName)
+	if _, err := ExtractFromArchive(archive, folder); err != nil {
+		return nil, err
+	}
+	return ParseGTFSFeed(folder, lineNumbers)
+}
+
+// ParseGTFSFeed parses the GTFS files in folder and returns the lines
+// (one per route and direction) with their stops and timetables. Lines
+// without a numeric id get their numbers from lineNumbers.
+func ParseGTFSFeed(folder string, lineNumbers *LineRegistry) ([]Line, error) {
+	stops, err := readGTFSStops(path.Join(folder, "stops.txt"))
+	if err != nil {
+		return nil, err
+	}
+	services, err := readGTFSServices(folder)
+	if err != nil {
+		return nil, err
+	}
+	night := LoadNightLineDetector("")
+	trips, err := readGTFSTrips(path.Join(folder, "trips.txt"), path.Join(folder, "stop_times.txt"))
+	if err != nil {
+		return nil, err
+	}
+	routes, err := readGTFSFile(path.Join(folder, "routes.txt"))
+	if err != nil {
+		return nil, err
+	}
+	shapes := make(map[string][]Point)
+	if shapesPath := path.Join(folder, "shapes.txt"); Exists(shapesPath) {
+		if shapes, err = readGTFSShapes(shapesPath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Trips per route and direction
+	tripsByLine := make(map[string][]*gtfsTrip)
+	for _, t := range trips {
+		key := t.routeId + "|" + t.direction
+		tripsByLine[key] = append(tripsByLine[key], t)
+	}
+
+	var lines []Line
+	for _, r := range routes {
+		agencyId := r["route_short_name"]
+		if len(agencyId) == 0 {
+			agencyId = r["route_id"]
+		}
+		name := r["route_long_name"]
+		if len(name) == 0 {
+			name = agencyId
+		}
+
+		for _, direction := range Directions {
+			gtfsDirection := "0"
+			lineName := name
+			if direction == DirectionBackward {
+				gtfsDirection = gtfsDirectionBackward
+				if reversed, err := ReverseLineName(name); err == nil {
+					lineName = reversed
+				}
+			}
+			lineTrips := tripsByLine[r["route_id"]+"|"+gtfsDirection]
+			if len(lineTrips) == 0 {
+				continue
+			}
+
+			isNightly := night.IsNight(agencyId) || IsNightSchedule(gtfsDepartures(lineTrips))
+			lineId := BuildLineIdWithDirection(agencyId, direction)
+			l := Line{lineId, agencyId, lineNumbers.Number(agencyId, lineId),
+				lineName, direction, nil, nil, &isNightly, nil}
+			longest := longestGTFSTrip(lineTrips)
+			l.Stops = buildGTFSLineStops(longest, lineTrips, stops, services, isNightly)
+			if shape := shapes[longest.shapeId]; len(shape) > 1 {
+				applyShape(&l, shape)
+			} else {
+				l.MapRoute = generateMapRoute(l)
+			}
+			lines = append(lines, l)
+		}
+	}
+
+	addGTFSConnections(lines)
+	log.Printf("Found %v lines (backwards and forward) in GTFS feed %v", len(lines), folder)
+	return lines, nil
+}
+
+// gtfsDepartures returns the departures of the trips from all their stops.
+func gtfsDepartures(trips []*gtfsTrip) []int {
+	var departures []int
+	for _, t := range trips {
+		for _, st := range t.stopTimes {
+			departures = append(departures, st.departure)
+		}
+	}
+	return departures
+}
+
+// longestGTFSTrip returns the trip with more stops (first by id on ties).
+func longestGTFSTrip(trips []*gtfsTrip) *gtfsTrip {
+	longest := trips[0]
+	for _, t := range trips[1:] {
+		if len(t.stopTimes) > len(longest.stopTimes) || (len(t.stopTimes) == len(longest.stopTimes) && t.id < longest.id) {
+			longest = t
+		}
+	}
+	return longest
+}
+
+// buildGTFSLineStops returns the stops of the longest trip of the line,
+// each with the timetable of all the trips of the line. Trips of night
+// lines on Fridays, Saturdays and added dates go to the night types of day.
+// Trips of day lines go to Monday to Thursday and Friday instead of weekday
+// if any service of the line runs on Fridays differently.
+func buildGTFSLineStops(longest *gtfsTrip, trips []*gtfsTrip, stops map[string]Stop, services map[string]gtfsService, night bool) []Stop {
+	split := false
+	for _, t := range trips {
+		if services[t.serviceId].fridayDiffers() {
+			split = true
+		}
+	}
+
+	// Departures per stop and type of day (field of the timetable)
+	var timetable Timetable
+	departures := make(map[string]map[*string][]int)
+	for _, t := range trips {
+		fields := gtfsTimetableFields(&timetable, services[t.serviceId], night, split)
+		for _, st := range t.stopTimes {
+			if departures[st.stopId] == nil {
+				departures[st.stopId] = make(map[*string][]int)
+			}
+			for _, f := range fields {
+				departures[st.stopId][f] = append(departures[st.stopId][f], st.departure)
+			}
+		}
+	}
+
+	var lineStops []Stop
+	for _, st := range longest.stopTimes {
+		s, found := stops[st.stopId]
+		if !found {
+			log.Printf("GTFS: Unknown stop %v in trip %v", st.stopId, longest.id)
+			continue
+		}
+		timetable = Timetable{}
+		for f, times := range departures[st.stopId] {
+			*f = formatGTFSTimes(times)
+		}
+		s.Schedule = timetable
+		lineStops = append(lineStops, s)
+	}
+	return lineStops
+}
+
+// gtfsTimetableFields returns the fields of t of the types of day the
+// service runs. split tells whether Fridays go apart from weekdays.
+func gtfsTimetableFields(t *Timetable, service gtfsService, night, split bool) []*string {
+	var fields []*string
+	add := func(runs bool, f *string) {
+		if runs {
+			fields = append(fields, f)
+		}
+	}
+	days := service.days()
+	if night {
+		add(service.calendar.mondayToThursday, &t.MondayToThrusday)
+		add(days.friday, &t.FridayNight)
+		add(days.saturday, &t.SaturdayNight)
+		add(service.calendar.sunday, &t.Sunday)
+		add(service.added.sunday || service.added.mondayToThursday, &t.HolidayEve)
+		return fields
+	}
+	if split {
+		add(days.mondayToThursday, &t.MondayToThrusday)
+		add(days.friday, &t.Friday)
+	} else {
+		add(days.weekday(), &t.Weekday)
+	}
+	add(days.saturday, &t.Saturday)
+	add(days.sunday, &t.Sunday)
+	return fields
+}
+
+// addGTFSConnections fills the connections of every stop with the
+// other lines visiting it.
+func addGTFSConnections(lines []Line) {
+	linesByStop := make(map[string][]Line)
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			linesByStop[s.Id] = append(linesByStop[s.Id], l)
+		}
+	}
+
+	for i, l := range lines {
+		for j, s := range l.Stops {
+			var connections Connections
+			for _, other := range linesByStop[s.Id] {
+				if other.AgencyId != l.AgencyId {
+					connections = connections.Add(Connection{LineId: other.Id, Direction: other.Direction})
+				}
+			}
+			sort.Slice(connections, func(a, b int) bool { return connections[a].LineId < connections[b].LineId })
+			lines[i].Stops[j].Connections = connections
+		}
+	}
+}
+
+// formatGTFSTimes returns the departures sorted and formatted as HH:MM.
+// Times past midnight of the service day are shown as the clock does.
+func formatGTFSTimes(departures []int) string {
+	sort.Ints(departures)
+	var times []string
+	last := ""
+	for _, d := range departures {
+		t := fmt.Sprintf("%02d:%02d", (d/3600)%24, (d/60)%60)
+		if t != last {
+			times = append(times, t)
+			last = t
+		}
+	}
+	return strings.Join(times, ",")
+}
+
+// parseGTFSTime parses a GTFS time (H:MM:SS, hours can exceed 24)
+// and returns the seconds since the start of the service day.
+func parseGTFSTime(t string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(t), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Invalid GTFS time %v", t)
+	}
+	var seconds int
+	for _, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid GTFS time %v", t)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+func readGTFSStops(p string) (map[string]Stop, error) {
+	records, err := readGTFSFile(p)
+	if err != nil {
+		return nil, err
+	}
+	stops := make(map[string]Stop)
+	for _, r := range records {
+		stops[r["stop_id"]] = Stop{Id: r["stop_id"], Name: r["stop_name"],
+			Location: Coordinates{r["stop_lat"], r["stop_lon"]}}
+	}
+	return stops, nil
+}
+
+// readGTFSShapes returns the points of every shape, sorted by sequence.
+func readGTFSShapes(p string) (map[string][]Point, error) {
+	records, err := readGTFSFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	type shapePoint struct {
+		sequence int
+		point    Point
+	}
+	points := make(map[string][]shapePoint)
+	for _, r := range records {
+		point, err := Coordinates{r["shape_pt_lat"], r["shape_pt_lon"]}.ToPoint()
+		if err != nil {
+			return nil, fmt.Errorf("Shape %v: %v", r["shape_id"], err)
+		}
+		sequence, _ := strconv.Atoi(r["shape_pt_sequence"])
+		points[r["shape_id"]] = append(points[r["shape_id"]], shapePoint{sequence, point})
+	}
+
+	shapes := make(map[string][]Point)
+	for id, sp := range points {
+		sort.SliceStable(sp, func(i, j int) bool { return sp[i].sequence < sp[j].sequence })
+		for _, p := range sp {
+			shapes[id] = append(shapes[id], p.point)
+		}
+	}
+	return shapes, nil
+}
+
+// readGTFSServices returns the types of day each service runs, from
+// calendar.txt and the dates added in calendar_dates.txt.
+func readGTFSServices(folder string) (map[string]gtfsService, error) {
+	services := make(map[string]gtfsService)
+	calendar := path.Join(folder, "calendar.txt")
+	if Exists(calendar) {
+		records, err := readGTFSFile(calendar)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			services[r["service_id"]] = gtfsService{calendar: gtfsDayTypes{
+				mondayToThursday: r["monday"] == "1" || r["tuesday"] == "1" || r["wednesday"] == "1" || r["thursday"] == "1",
+				friday:           r["friday"] == "1",
+				saturday:         r["saturday"] == "1",
+				sunday:           r["sunday"] == "1",
+			}}
+		}
+	}
+
+	dates := path.Join(folder, "calendar_dates.txt")
+	if Exists(dates) {
+		records, err := readGTFSFile(dates)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if r["exception_type"] != gtfsServiceAdded {
+				continue
+			}
+			date, err := time.Parse(gtfsDateLayout, r["date"])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid date %v in %v", r["date"], dates)
+			}
+			service := services[r["service_id"]]
+			switch date.Weekday() {
+			case time.Saturday:
+				service.added.saturday = true
+			case time.Sunday:
+				service.added.sunday = true
+			case time.Friday:
+				service.added.friday = true
+			default:
+				service.added.mondayToThursday = true
+			}
+			services[r["service_id"]] = service
+		}
+	}
+	return services, nil
+}
+
+func readGTFSTrips(tripsPath, stopTimesPath string) ([]*gtfsTrip, error) {
+	records, err := readGTFSFile(tripsPath)
+	if err != nil {
+		return nil, err
+	}
+	trips := make(map[string]*gtfsTrip)
+	var ordered []*gtfsTrip
+	for _, r := range records {
+		t := &gtfsTrip{id: r["trip_id"], routeId: r["route_id"], serviceId: r["service_id"],
+			direction: r["direction_id"], shapeId: r["shape_id"]}
+		if len(t.direction) == 0 {
+			t.direction = "0"
+		}
+		trips[t.id] = t
+		ordered = append(ordered, t)
+	}
+
+	records, err = readGTFSFile(stopTimesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		t, found := trips[r["trip_id"]]
+		if !found {
+			continue
+		}
+		departure := r["departure_time"]
+		if len(departure) == 0 {
+			departure = r["arrival_time"]
+		}
+		if len(departure) == 0 {
+			continue // Not timepoint
+		}
+		seconds, err := parseGTFSTime(departure)
+		if err != nil {
+			return nil, err
+		}
+		sequence, _ := strconv.Atoi(r["stop_sequence"])
+		t.stopTimes = append(t.stopTimes, gtfsStopTime{r["stop_id"], sequence, seconds})
+	}
+
+	for _, t := range ordered {
+		sort.SliceStable(t.stopTimes, func(i, j int) bool { return t.stopTimes[i].sequence < t.stopTimes[j].sequence })
+	}
+	return ordered, nil
+}
+
+// readGTFSFile reads a GTFS csv file and returns its records as
+// maps of values by column name.
+func readGTFSFile(p string) ([]map[string]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		log.Printf("Error reading file %v. Error: %v ", p, err)
+		return nil, err
+	}
+	defer f.Close()
+
+	csvr := csv.NewReader(f)
+	csvr.FieldsPerRecord = -1 // No checks
+	csvr.LazyQuotes = true
+
+	header, err := csvr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading header of %v: %v", p, err)
+	}
+	for i, h := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+	}
+
+	var records []map[string]string
+	for {
+		row, err := csvr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Error reading %v: %v", p, err)
+		}
+		r := make(map[string]string, len(header))
+		for i, v := range row {
+			if i < len(header) {
+				r[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
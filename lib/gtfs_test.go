@@ -0,0 +1,115 @@
+package transit
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+)
+
+// fakeGTFSFeed has a circular route (both directions) and a route
+// sharing stop 0002, running on weekdays and a Saturday.
+var fakeGTFSFeed = []archiveEntry{
+	{"agency.txt", "agency_id,agency_name,agency_url,agency_timezone\nBZK,Bizkaibus,http://bizkaibus.eus,Europe/Madrid\n", false},
+	{"stops.txt", "\ufeffstop_id,stop_name,stop_lat,stop_lon\n0001,Moyua,43.2630,-2.9350\n0002,Abando,43.2610,-2.9270\n0003,Deusto,43.2710,-2.9460\n", false},
+	{"routes.txt", "route_id,route_short_name,route_long_name,route_type\nR1,3411,Bilbao - Deusto,3\nR2,A3,Abando - Moyua,3\n", false},
+	{"trips.txt", "route_id,service_id,trip_id,direction_id,shape_id\nR1,LAB,T1,0,\nR1,LAB,T2,0,SH1\nR1,SAB,T3,1,\nR2,LAB,T4,0,\n", false},
+	{"shapes.txt", "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\n" +
+		"SH1,43.2610,-2.9270,3\nSH1,43.2630,-2.9350,1\nSH1,43.2610,-2.9350,2\nSH1,43.2710,-2.9460,4\n", false},
+	{"stop_times.txt", "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+		"T1,07:00:00,07:00:00,0001,1\nT1,07:05:00,07:05:00,0002,2\n" +
+		"T2,25:10:00,25:10:00,0003,3\nT2,06:00:00,06:00:00,0001,1\nT2,06:05:00,06:05:00,0002,2\n" +
+		"T3,09:00:00,09:00:00,0003,1\nT3,09:10:00,09:10:00,0001,2\n" +
+		"T4,08:00:00,08:00:00,0002,1\n", false},
+	{"calendar.txt", "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nLAB,1,1,1,1,1,0,0,20260101,20261231\n", false},
+	{"calendar_dates.txt", "service_id,date,exception_type\nSAB,20261017,1\n", false},
+}
+
+func TestGTFSAgencyDigest(t *testing.T) {
+	log.Printf("---------- TestGTFSAgencyDigest ------------ ")
+	dataPath := "TestGTFSAgencyDigest"
+	os.MkdirAll(dataPath, os.ModePerm)
+	defer os.RemoveAll(dataPath)
+	archive := "TestGTFSAgencyDigest_feed.zip"
+	writeZipArchive(archive, fakeGTFSFeed)
+	defer os.Remove(archive)
+
+	wd, _ := os.Getwd()
+	os.Setenv(EnvNameBizkaibus, `[{"Id":"GTFS","Uri":"file://`+wd+"/"+archive+`","Path":"`+dataPath+`"}]`)
+	defer os.Unsetenv(EnvNameBizkaibus)
+
+	a, err := NewAgency(AgencyBizkaibus)
+	if err != nil {
+		t.Fatalf("Unexpected error creating agency: %v", err)
+	}
+	if err := a.Digest(context.Background(), a.GetSources()); err != nil {
+		t.Fatalf("Unexpected error digesting feed: %v", err)
+	}
+
+	data := a.Data()
+	if len(a.Lines()) != 3 {
+		t.Fatalf("Expected 3 lines, actual %v", len(data.lines))
+	}
+	if len(data.stops) != 3 {
+		t.Errorf("Expected 3 stops, actual %v", len(data.stops))
+	}
+
+	forward, found := findLine(data.lines, "I3411")
+	if !found || forward.Number != 3411 || forward.Name != "Bilbao - Deusto" || len(forward.Stops) != 3 {
+		t.Fatalf("Unexpected forward line %v", forward)
+	}
+	moyua := forward.Stops[0]
+	if moyua.Id != "0001" || moyua.Schedule.Weekday != "06:00,07:00" || moyua.Schedule.Saturday != "" {
+		t.Errorf("Unexpected stop %v", moyua)
+	}
+	if forward.Stops[2].Schedule.Weekday != "01:10" {
+		t.Errorf("Expected time past midnight 01:10, actual %v", forward.Stops[2].Schedule.Weekday)
+	}
+	if forward.Stops[1].Connections.String() != "IA3" {
+		t.Errorf("Expected connection IA3, actual %v", forward.Stops[1].Connections)
+	}
+	if moyua.Location.Lat != "43.2630" || moyua.Location.Long != "-2.9350" {
+		t.Errorf("Unexpected location %v", moyua.Location)
+	}
+
+	if len(forward.MapRoute) != 4 || forward.MapRoute[1] != (Coordinates{"43.261000", "-2.935000"}) {
+		t.Errorf("Expected map route from shape SH1, actual %v", forward.MapRoute)
+	}
+	if forward.Stops[0].ShapeDistance != 0 || forward.Stops[1].ShapeDistance < 868 || forward.Stops[1].ShapeDistance > 872 {
+		t.Errorf("Unexpected distances along shape %v, %v", forward.Stops[0].ShapeDistance, forward.Stops[1].ShapeDistance)
+	}
+
+	backward, found := findLine(data.lines, "V3411")
+	if !found || backward.Name != "Deusto - Bilbao" || backward.Stops[0].Schedule.Saturday != "09:00" {
+		t.Errorf("Unexpected backward line %v", backward)
+	}
+
+	a3, found := findLine(data.lines, "IA3")
+	if !found || a3.Number != GeneratedBaseNumber+1 {
+		t.Errorf("Expected generated number for line A3, actual %v", a3)
+	}
+}
+
+func TestParseGTFSTime(t *testing.T) {
+	log.Printf("---------- TestParseGTFSTime ------------ ")
+	testCases := []struct {
+		time     string
+		expected int
+		valid    bool
+	}{
+		{"07:05:30", 7*3600 + 5*60 + 30, true},
+		{"6:00:00", 6 * 3600, true},
+		{"25:10:00", 25*3600 + 10*60, true},
+		{"07:05", 0, false},
+		{"aa:00:00", 0, false},
+	}
+	for _, tc := range testCases {
+		actual, err := parseGTFSTime(tc.time)
+		if tc.valid && (err != nil || actual != tc.expected) {
+			t.Errorf("%v: expected %v, actual %v (%v)", tc.time, tc.expected, actual, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%v: expected error", tc.time)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Constants
+const envLineRegistry string = "LINE_REGISTRY" // Path to JSON file
+
+// Serializes the updates of the registry file by agencies digested in parallel
+var lineRegistryMutex sync.Mutex
+
+// LineRegistryEntry is the number assigned to a line of an agency and the
+// ids (e.g. IA3, VA3) the line has been published with.
+type LineRegistryEntry struct {
+	Agency   string
+	AgencyId string
+	Number   int
+	Ids      []string `json:",omitempty"`
+}
+
+// LineRegistry keeps the numbers of the lines without a numeric agency id
+// across runs, so they do not depend on the order the lines are found.
+// Entries are only appended: numbers are never changed nor reused.
+// Lines whose agency id is a number keep it and are not registered,
+// unless mapped to another number.
+type LineRegistry struct {
+	path    string
+	agency  string
+	mapping map[string]int      // Numbers by configuration per agency id
+	entries []LineRegistryEntry // Entries of the agency
+	index   map[string]int      // Entry per agency id
+	numbers map[int]string      // Agency id per number registered or mapped
+	changed bool
+	errs    []error
+}
+
+// LoadLineRegistry reads the registry of the agency from the JSON file
+// (list of LineRegistryEntry) in env variable LINE_REGISTRY and checks it
+// against the numbers mapped by configuration (agency id to number). If
+// the variable is not defined, numbers are kept only during the run.
+// The registry returned is usable even if there are errors, see Errors.
+func LoadLineRegistry(agency string, mapping map[string]string) *LineRegistry {
+	r := &LineRegistry{
+		path:    os.Getenv(envLineRegistry),
+		agency:  agency,
+		mapping: make(map[string]int),
+		index:   make(map[string]int),
+		numbers: make(map[int]string),
+	}
+	if len(r.path) == 0 {
+		log.Printf("Env variable %v is empty. Line numbers are not kept across runs.", envLineRegistry)
+	}
+
+	entries, err := readLineRegistry(r.path)
+	if err != nil {
+		r.errs = append(r.errs, err)
+	}
+	for _, e := range entries {
+		if e.Agency == agency {
+			r.index[strings.ToUpper(e.AgencyId)] = len(r.entries)
+			r.entries = append(r.entries, e)
+			r.numbers[e.Number] = strings.ToUpper(e.AgencyId)
+		}
+	}
+
+	ids := make([]string, 0, len(mapping))
+	for id := range mapping {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		number := mapping[id]
+		id = strings.ToUpper(id)
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("Invalid number %v of line %v in %v", number, id, envMapLineNumbers))
+			continue
+		}
+		r.mapping[id] = n
+		if i, found := r.index[id]; found && r.entries[i].Number != n {
+			r.errs = append(r.errs, fmt.Errorf("Line %v mapped to number %v in %v, registered with number %v",
+				id, n, envMapLineNumbers, r.entries[i].Number))
+		}
+		if other, found := r.numbers[n]; found && other != id {
+			r.errs = append(r.errs, fmt.Errorf("Line %v mapped to number %v in %v, registered for line %v",
+				id, n, envMapLineNumbers, other))
+		}
+	}
+	for _, err := range r.errs {
+		log.Printf("Line registry of %v: %v", agency, err)
+	}
+	return r
+}
+
+// Errors returns the errors reading the registry and the conflicts
+// between the registry and the numbers mapped by configuration.
+func (r *LineRegistry) Errors() []error {
+	return r.errs
+}
+
+// Number returns the number of line lineId (e.g. IA3) with agency id
+// agencyId (e.g. A3): the one mapped by configuration, the agency id if
+// it is a number or the one registered. Lines not registered yet get the
+// next number from GeneratedBaseNumber not in use.
+func (r *LineRegistry) Number(agencyId, lineId string) int {
+	id := strings.ToUpper(agencyId)
+	n, mapped := r.mapping[id]
+	if !mapped {
+		if number, err := strconv.Atoi(agencyId); err == nil {
+			return number
+		}
+	}
+
+	i, registered := r.index[id]
+	if !registered {
+		if !mapped {
+			n = GeneratedBaseNumber + 1
+			for r.isUsed(n) {
+				n++
+			}
+		}
+		if other, found := r.numbers[n]; found && other != id {
+			return n // Conflict reported on load
+		}
+		i = len(r.entries)
+		r.index[id] = i
+		r.entries = append(r.entries, LineRegistryEntry{Agency: r.agency, AgencyId: id, Number: n})
+		r.numbers[n] = id
+		r.changed = true
+		log.Printf("Line registry of %v: Line %v registered with number %v", r.agency, id, n)
+	} else if !mapped {
+		n = r.entries[i].Number
+	}
+
+	if !containsString(r.entries[i].Ids, lineId) {
+		r.entries[i].Ids = append(r.entries[i].Ids, lineId)
+		r.changed = true
+	}
+	return n
+}
+
+// isUsed tells whether number n is registered or mapped to any line.
+func (r *LineRegistry) isUsed(n int) bool {
+	if _, found := r.numbers[n]; found {
+		return true
+	}
+	for _, mapped := range r.mapping {
+		if mapped == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Save appends to the registry file the lines registered and the ids
+// found in this run. Entries in the file are kept as they are, also the
+// ones added by other runs since the registry was loaded. Lines numbered
+// meanwhile by other runs with other numbers, or whose numbers have been
+// registered meanwhile for other lines, are not saved and returned as
+// errors along with the error writing the file (if any).
+func (r *LineRegistry) Save() []error {
+	if len(r.path) == 0 || !r.changed {
+		return nil
+	}
+	lineRegistryMutex.Lock()
+	defer lineRegistryMutex.Unlock()
+
+	entries, err := readLineRegistry(r.path)
+	if err != nil {
+		return []error{err} // Never overwrite a registry that can not be read
+	}
+	index := make(map[string]int)
+	numbers := make(map[string]string)
+	for i, e := range entries {
+		index[e.Agency+"|"+strings.ToUpper(e.AgencyId)] = i
+		numbers[e.Agency+"|"+strconv.Itoa(e.Number)] = strings.ToUpper(e.AgencyId)
+	}
+	var errs []error
+	for _, e := range r.entries {
+		i, found := index[e.Agency+"|"+e.AgencyId]
+		if !found {
+			if other, used := numbers[e.Agency+"|"+strconv.Itoa(e.Number)]; used {
+				errs = append(errs, fmt.Errorf("Line %v numbered %v, registered meanwhile for line %v", e.AgencyId, e.Number, other))
+				continue
+			}
+			numbers[e.Agency+"|"+strconv.Itoa(e.Number)] = e.AgencyId
+			entries = append(entries, e)
+			continue
+		}
+		if entries[i].Number != e.Number {
+			errs = append(errs, fmt.Errorf("Line %v numbered %v, registered meanwhile with number %v", e.AgencyId, e.Number, entries[i].Number))
+			continue
+		}
+		for _, id := range e.Ids {
+			if !containsString(entries[i].Ids, id) {
+				entries[i].Ids = append(entries[i].Ids, id)
+			}
+		}
+	}
+	for _, err := range errs {
+		log.Printf("Line registry of %v: %v", r.agency, err)
+	}
+
+	b, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return append(errs, err)
+	}
+	if err := CreateFile(r.path, string(b)); err != nil {
+		log.Printf("Error writing line registry %v. Error: %v ", r.path, err)
+		return append(errs, err)
+	}
+	r.changed = false
+	return errs
+}
+
+// readLineRegistry reads the entries of the registry file p. No entries
+// if p is empty or the file does not exist yet.
+func readLineRegistry(p string) ([]LineRegistryEntry, error) {
+	if len(p) == 0 || !Exists(p) {
+		return nil, nil
+	}
+	f, err := ioutil.ReadFile(p)
+	if err != nil {
+		log.Printf("Error reading line registry %v. Error: %v ", p, err)
+		return nil, err
+	}
+	var entries []LineRegistryEntry
+	if err := json.Unmarshal(f, &entries); err != nil {
+		return nil, fmt.Errorf("Error parsing line registry %v: %v", p, err)
+	}
+	return entries, nil
+}
+
+// containsString tells whether value is in values.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package transit
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLineRegistry(t *testing.T) {
+	log.Printf("---------- TestLineRegistry ------------ ")
+	dir, err := ioutil.TempDir("", "lineregistry")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	registry := path.Join(dir, "lines.json")
+	os.Setenv(envLineRegistry, registry)
+	defer os.Unsetenv(envLineRegistry)
+
+	// Numbers are kept across runs whatever the order of the lines
+	runs := []struct {
+		lines    []string
+		expected []int
+	}{
+		{[]string{"A3", "01", "G1"}, []int{GeneratedBaseNumber + 1, 1, GeneratedBaseNumber + 2}},
+		{[]string{"B5", "G1", "A3"}, []int{GeneratedBaseNumber + 3, GeneratedBaseNumber + 2, GeneratedBaseNumber + 1}},
+	}
+	for i, run := range runs {
+		r := LoadLineRegistry(AgencyBilbobus, nil)
+		for j, id := range run.lines {
+			if n := r.Number(id, "I"+id); n != run.expected[j] {
+				t.Errorf("Run %v, line %v: expected %v, actual %v", i, id, run.expected[j], n)
+			}
+		}
+		if errs := r.Save(); len(errs) > 0 || len(r.Errors()) > 0 {
+			t.Errorf("Run %v: unexpected errors %v, %v", i, errs, r.Errors())
+		}
+	}
+	entries, err := readLineRegistry(registry)
+	if err != nil || len(entries) != 3 || entries[2].AgencyId != "B5" || entries[0].Number != GeneratedBaseNumber+1 {
+		t.Errorf("Unexpected registry %v (%v)", entries, err)
+	}
+
+	// Other agencies have their own numbers
+	if n := LoadLineRegistry("Bizkaibus", nil).Number("A3", "IA3"); n != GeneratedBaseNumber+1 {
+		t.Errorf("Expected number %v for another agency, actual %v", GeneratedBaseNumber+1, n)
+	}
+
+	// Mapping by configuration wins, conflicts are reported
+	r := LoadLineRegistry(AgencyBilbobus, map[string]string{"A3": "300", "c1": "9002", "D4": "9004"})
+	expected := []string{"Line A3 mapped to number 300", "Line C1 mapped to number 9002 in " + envMapLineNumbers + ", registered for line G1"}
+	if len(r.Errors()) != len(expected) {
+		t.Fatalf("Expected conflicts %v, actual %v", expected, r.Errors())
+	}
+	for i, err := range r.Errors() {
+		if !strings.HasPrefix(err.Error(), expected[i]) {
+			t.Errorf("Expected conflict %v, actual %v", expected[i], err)
+		}
+	}
+	if n := r.Number("A3", "VA3"); n != 300 {
+		t.Errorf("Expected mapped number 300, actual %v", n)
+	}
+	// Numbers mapped are not generated
+	if n := r.Number("E6", "IE6"); n != GeneratedBaseNumber+5 {
+		t.Errorf("Expected number %v, actual %v", GeneratedBaseNumber+5, n)
+	}
+	if errs := r.Save(); len(errs) > 0 {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+	entries, _ = readLineRegistry(registry)
+	if len(entries) != 4 || entries[0].Number != GeneratedBaseNumber+1 || len(entries[0].Ids) != 2 {
+		t.Errorf("Unexpected registry %v", entries)
+	}
+
+	// Numbers assigned meanwhile by another run are not saved
+	first := LoadLineRegistry(AgencyBilbobus, nil)
+	second := LoadLineRegistry(AgencyBilbobus, nil)
+	first.Number("H8", "IH8")
+	second.Number("J9", "IJ9")
+	second.Number("A3", "IA3")
+	if errs := first.Save(); len(errs) > 0 {
+		t.Errorf("Unexpected errors %v", errs)
+	}
+	errs := second.Save()
+	expected = []string{"Line J9 numbered " + strconv.Itoa(GeneratedBaseNumber+4) + ", registered meanwhile for line H8"}
+	if len(errs) != len(expected) || errs[0].Error() != expected[0] {
+		t.Errorf("Expected collisions %v, actual %v", expected, errs)
+	}
+	entries, _ = readLineRegistry(registry)
+	if len(entries) != 5 || entries[4].AgencyId != "H8" || len(entries[0].Ids) != 2 {
+		t.Errorf("Unexpected registry %v", entries)
+	}
+
+	// Registry not readable is never overwritten
+	CreateFile(registry, "{")
+	r = LoadLineRegistry(AgencyBilbobus, nil)
+	r.Number("A3", "IA3")
+	if len(r.Errors()) != 1 || len(r.Save()) != 1 {
+		t.Errorf("Expected errors reading registry")
+	}
+	if b, _ := ioutil.ReadFile(registry); string(b) != "{" {
+		t.Errorf("Registry overwritten: %v", string(b))
+	}
+}
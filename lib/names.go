@@ -0,0 +1,218 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Constants
+const envNamesDictionary string = "NAMES_DICTIONARY" // Path to JSON file
+const LanguageSpanish string = "es"
+const LanguageBasque string = "eu"
+const lineNameSeparator string = " - "
+
+// Abbreviations found in names (lower case) and the word they stand for.
+var nameAbbreviations = map[string]string{
+	"avda.": "Avenida", "avda": "Avenida", "av.": "Avenida", "avd.": "Avenida",
+	"pza.": "Plaza", "pza": "Plaza", "pl.": "Plaza", "plza.": "Plaza",
+	"c/": "Calle", "c.": "Calle",
+	"ctra.": "Carretera", "ctra": "Carretera",
+	"sta.": "Santa", "sto.": "Santo", "gral.": "General",
+	"hosp.": "Hospital", "estac.": "Estación", "urb.": "Urbanización",
+	"bº": "Barrio", "b.º": "Barrio", "pº": "Paseo", "p.º": "Paseo",
+}
+
+// Words written in lower case unless they start the name.
+var nameParticles = map[string]bool{
+	"de": true, "del": true, "la": true, "las": true, "los": true, "el": true,
+	"y": true, "e": true, "a": true, "en": true, "con": true, "por": true,
+}
+
+// "C/Iparraguirre" has no space after the abbreviation.
+var streetAbbreviationPattern = regexp.MustCompile(`(?i)(^|\s)c/\s*`)
+var romanNumeralPattern = regexp.MustCompile(`^[IVX]+$`)
+
+// Removes the accents of the letters used in Spanish and Basque names.
+var accentsReplacer = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u",
+	"Á", "a", "É", "e", "Í", "i", "Ó", "o", "Ú", "u", "Ü", "u")
+
+// NameEntry is the canonical form of a name, as defined in the dictionary.
+type NameEntry struct {
+	Match string // Name published (case, accents, punctuation and abbreviations ignored)
+	Name  string // Canonical name
+	Es    string // Spanish name, if it differs from the canonical one
+	Eu    string // Basque name, if it differs from the canonical one
+}
+
+// NameDictionary maps the key of published names (see nameKey) to
+// their canonical form.
+type NameDictionary map[string]NameEntry
+
+// LoadNameDictionary reads the dictionary of canonical names from the
+// JSON file (list of NameEntry) in env variable NAMES_DICTIONARY.
+// Empty dictionary if the variable is not defined.
+func LoadNameDictionary() (NameDictionary, error) {
+	d := make(NameDictionary)
+	p := os.Getenv(envNamesDictionary)
+	if len(p) == 0 {
+		log.Printf("Env variable %v is empty. No dictionary of names.", envNamesDictionary)
+		return d, nil
+	}
+
+	f, err := ioutil.ReadFile(p)
+	if err != nil {
+		log.Printf("Error reading dictionary of names %v. Error: %v ", p, err)
+		return d, err
+	}
+	var entries []NameEntry
+	if err := json.Unmarshal(f, &entries); err != nil {
+		return d, fmt.Errorf("Error parsing dictionary of names %v: %v", p, err)
+	}
+	for _, e := range entries {
+		d[nameKey(e.Match)] = e
+	}
+	return d, nil
+}
+
+// NormalizeNames normalizes the names of the lines and their stops.
+func NormalizeNames(lines []Line, d NameDictionary) {
+	for i, l := range lines {
+		lines[i].Name, lines[i].Names = d.NormalizeLineName(l.Name)
+		for j, s := range l.Stops {
+			lines[i].Stops[j].Name, lines[i].Stops[j].Names = d.NormalizeName(s.Name)
+		}
+	}
+}
+
+// NormalizeName returns the canonical form of name: the one in the
+// dictionary if there, otherwise name with abbreviations expanded and
+// casing fixed. Names are returned if Spanish and Basque ones differ.
+func (d NameDictionary) NormalizeName(name string) (string, *Names) {
+	expanded := expandAbbreviations(name)
+	if e, found := d[nameKey(expanded)]; found {
+		var names *Names
+		if len(e.Es) > 0 || len(e.Eu) > 0 {
+			names = &Names{e.Es, e.Eu}
+		}
+		if len(e.Name) == 0 {
+			return titleCase(expanded), names
+		}
+		return e.Name, names
+	}
+	return titleCase(expanded), nil
+}
+
+// NormalizeLineName normalizes origin and destination of the line name
+// ("origin - destination") independently. Parts without name in a
+// language keep the normalized one in that language.
+func (d NameDictionary) NormalizeLineName(name string) (string, *Names) {
+	parts := strings.Split(name, lineNameSeparator)
+	var es, eu []string
+	localized := false
+	for i, part := range parts {
+		n, names := d.NormalizeName(part)
+		parts[i] = n
+		if names != nil {
+			localized = true
+			es = append(es, nameOrDefault(names.In(LanguageSpanish), n))
+			eu = append(eu, nameOrDefault(names.In(LanguageBasque), n))
+		} else {
+			es = append(es, n)
+			eu = append(eu, n)
+		}
+	}
+
+	var names *Names
+	if localized {
+		names = &Names{strings.Join(es, lineNameSeparator), strings.Join(eu, lineNameSeparator)}
+	}
+	return strings.Join(parts, lineNameSeparator), names
+}
+
+func nameOrDefault(name, defaultName string) string {
+	if len(name) == 0 {
+		return defaultName
+	}
+	return name
+}
+
+// In returns the name in language, empty if not defined.
+func (n *Names) In(language string) string {
+	if n == nil {
+		return ""
+	}
+	switch language {
+	case LanguageSpanish:
+		return n.Es
+	case LanguageBasque:
+		return n.Eu
+	}
+	return ""
+}
+
+// expandAbbreviations replaces the abbreviations in name by the full
+// word and collapses spaces.
+func expandAbbreviations(name string) string {
+	name = streetAbbreviationPattern.ReplaceAllString(name, "${1}c/ ")
+	words := strings.Fields(name)
+	for i, w := range words {
+		if full, found := nameAbbreviations[strings.ToLower(w)]; found {
+			words[i] = full
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// titleCase capitalizes the words of name, except particles (de, la,...)
+// that are not the first word. Roman numerals and words with digits are
+// kept as they are.
+func titleCase(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		lower := strings.ToLower(w)
+		switch {
+		case i > 0 && nameParticles[lower]:
+			words[i] = lower
+		case romanNumeralPattern.MatchString(w) || strings.IndexFunc(w, unicode.IsDigit) >= 0:
+			continue
+		default:
+			words[i] = capitalize(lower)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// capitalize upper cases the first letter of w and the ones after
+// a hyphen, slash or parenthesis (e.g. Santutxu-Bolueta).
+func capitalize(w string) string {
+	runes := []rune(w)
+	upper := true
+	for i, r := range runes {
+		if upper && unicode.IsLetter(r) {
+			runes[i] = unicode.ToUpper(r)
+			upper = false
+		}
+		if strings.ContainsRune("-/(", r) {
+			upper = true
+		}
+	}
+	return string(runes)
+}
+
+// nameKey returns the key of name in the dictionary.
+func nameKey(name string) string {
+	return strings.Join(nameTokens(expandAbbreviations(name)), " ")
+}
+
+// nameTokens returns the words of name in lower case without accents.
+func nameTokens(name string) []string {
+	return strings.FieldsFunc(strings.ToLower(accentsReplacer.Replace(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
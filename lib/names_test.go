@@ -0,0 +1,109 @@
+package transit
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+var normalizeNameTestCases = []struct {
+	input    string
+	expected string
+}{
+	{"MOYUA", "Moyua"},
+	{"  plaza   MOYUA ", "Plaza Moyua"},
+	{"Avda. Madariaga 12", "Avenida Madariaga 12"},
+	{"PZA. DE LA CASILLA", "Plaza de la Casilla"},
+	{"C/Iparraguirre", "Calle Iparraguirre"},
+	{"c/ autonomía, 45", "Calle Autonomía, 45"},
+	{"SANTUTXU-BOLUETA", "Santutxu-Bolueta"},
+	{"Hosp. de Basurto (URGENCIAS)", "Hospital de Basurto (Urgencias)"},
+	{"Carlos VII", "Carlos VII"},
+	{"Los Caños", "Los Caños"},
+	{"San Mamés", "San Mamés"},
+}
+
+func TestNormalizeName(t *testing.T) {
+	log.Printf("---------- TestNormalizeName ------------ ")
+	d := make(NameDictionary)
+	for _, tc := range normalizeNameTestCases {
+		if actual, names := d.NormalizeName(tc.input); actual != tc.expected || names != nil {
+			t.Errorf("NormalizeName(%q): expected %q, actual %q (%v)", tc.input, tc.expected, actual, names)
+		}
+	}
+}
+
+func TestNameDictionary(t *testing.T) {
+	log.Printf("---------- TestNameDictionary ------------ ")
+	p := "TestNameDictionary.json"
+	defer os.Remove(p)
+	ioutil.WriteFile(p, []byte(`[
+		{"Match": "Plaza Circular", "Name": "Plaza Circular", "Es": "Plaza Circular", "Eu": "Plaza Biribila"},
+		{"Match": "Pza. Biribila", "Name": "Plaza Circular", "Es": "Plaza Circular", "Eu": "Plaza Biribila"},
+		{"Match": "Sarriko", "Name": "Sarriko (Metro)"},
+		{"Match": "Plaza Moyua", "Eu": "Moyua Plaza"}
+	]`), 0644)
+	os.Setenv(envNamesDictionary, p)
+	defer os.Unsetenv(envNamesDictionary)
+
+	d, err := LoadNameDictionary()
+	if err != nil || len(d) != 4 {
+		t.Fatalf("Expected 4 entries, actual %v (%v)", d, err)
+	}
+
+	testCases := []struct {
+		input         string
+		expected      string
+		expectedNames *Names
+	}{
+		{"PLAZA CIRCULAR", "Plaza Circular", &Names{"Plaza Circular", "Plaza Biribila"}},
+		{"Plaza  Biribila", "Plaza Circular", &Names{"Plaza Circular", "Plaza Biribila"}},
+		{"SARRIKO", "Sarriko (Metro)", nil},
+		{"Moyua", "Moyua", nil},
+	}
+	for _, tc := range testCases {
+		actual, names := d.NormalizeName(tc.input)
+		if actual != tc.expected || (names == nil) != (tc.expectedNames == nil) || (names != nil && *names != *tc.expectedNames) {
+			t.Errorf("NormalizeName(%q): expected %q %v, actual %q %v", tc.input, tc.expected, tc.expectedNames, actual, names)
+		}
+	}
+
+	name, names := d.NormalizeLineName("PZA. BIRIBILA - ARANGOITI")
+	if name != "Plaza Circular - Arangoiti" || names == nil || names.Eu != "Plaza Biribila - Arangoiti" || names.Es != "Plaza Circular - Arangoiti" {
+		t.Errorf("Unexpected line name %q %v", name, names)
+	}
+	// Only Basque name in the dictionary: the Spanish one is the normalized
+	name, names = d.NormalizeLineName("PZA. MOYUA - SANTUTXU")
+	if name != "Plaza Moyua - Santutxu" || names == nil || names.Es != "Plaza Moyua - Santutxu" || names.Eu != "Moyua Plaza - Santutxu" {
+		t.Errorf("Unexpected line name %q %v", name, names)
+	}
+
+	ioutil.WriteFile(p, []byte(`[{"Match":`), 0644)
+	if _, err := LoadNameDictionary(); err == nil {
+		t.Errorf("Expected error with malformed dictionary")
+	}
+}
+
+func TestJsonPresenterLanguage(t *testing.T) {
+	log.Printf("---------- TestJsonPresenterLanguage ------------ ")
+	isNightly := false
+	stop := Stop{Id: "0001", Name: "Plaza Circular", Names: &Names{"Plaza Circular", "Plaza Biribila"}}
+	line := Line{"I01", "01", 1, "Plaza Circular - Arangoiti", DirectionForward, []Stop{stop, {Id: "0002", Name: "Arangoiti"}}, nil, &isNightly,
+		&Names{"Plaza Circular - Arangoiti", "Plaza Biribila - Arangoiti"}}
+
+	basque, err := JsonPresenter{Language: LanguageBasque}.FormatList([]Line{line})
+	if err != nil || !strings.Contains(basque, `"Name": "Plaza Biribila - Arangoiti"`) || !strings.Contains(basque, `"Na": "Plaza Biribila"`) ||
+		!strings.Contains(basque, `"Na": "Arangoiti"`) || strings.Contains(basque, `"Nm"`) {
+		t.Errorf("Unexpected names in Basque: %v (%v)", basque, err)
+	}
+
+	all, _ := JsonPresenter{}.Format(line)
+	if !strings.Contains(all, `"Name": "Plaza Circular - Arangoiti"`) || !strings.Contains(all, `"eu": "Plaza Biribila"`) {
+		t.Errorf("Expected canonical names and names per language: %v", all)
+	}
+	if line.Stops[0].Name != "Plaza Circular" || line.Names == nil {
+		t.Errorf("Presenter modified the line: %v", line)
+	}
+}
@@ -0,0 +1,83 @@
+package transit
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Constants
+const envNightLinesPattern string = "NIGHT_LINES_PATTERN" // Regexp matching agency ids (e.g. ^G)
+const envNightLines string = "NIGHT_LINES"                // Agency ids, comma separated
+const secondsPerDay int = 24 * 3600
+
+// Night service window. A line whose departures are all in it is a night
+// line. Times are seconds since the start of the service day.
+var NightServiceStart = 22 * 3600
+var NightServiceEnd = 6 * 3600
+
+// NightLineDetector tells which lines are night lines: the ones whose
+// agency id matches the pattern, the ones in the list and the ones the
+// agency data (see IsNightSchedule) says so.
+type NightLineDetector struct {
+	pattern *regexp.Regexp
+	lines   map[string]bool
+}
+
+// LoadNightLineDetector reads the pattern (NIGHT_LINES_PATTERN) and the
+// list (NIGHT_LINES) of night lines from environment. defaultPattern is
+// used if the pattern is not defined. Empty pattern matches no line.
+func LoadNightLineDetector(defaultPattern string) NightLineDetector {
+	d := NightLineDetector{lines: make(map[string]bool)}
+	pattern, defined := os.LookupEnv(envNightLinesPattern)
+	if !defined {
+		pattern = defaultPattern
+	}
+	if len(pattern) > 0 {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("Invalid pattern of night lines %v. Error: %v", pattern, err)
+		} else {
+			d.pattern = regex
+		}
+	}
+	for _, id := range strings.Split(os.Getenv(envNightLines), ",") {
+		if id = strings.ToUpper(strings.TrimSpace(id)); len(id) > 0 {
+			d.lines[id] = true
+		}
+	}
+	return d
+}
+
+// IsNight tells whether the line with agencyId is a night line by its id.
+func (d NightLineDetector) IsNight(agencyId string) bool {
+	id := strings.ToUpper(agencyId)
+	return d.lines[id] || (d.pattern != nil && d.pattern.MatchString(id))
+}
+
+// IsNightSchedule tells whether all the departures (seconds since the
+// start of the service day) are in the night service window.
+func IsNightSchedule(departures []int) bool {
+	for _, d := range departures {
+		t := d % secondsPerDay
+		if d < secondsPerDay && t < NightServiceStart && t >= NightServiceEnd || d >= secondsPerDay && t >= NightServiceEnd {
+			return false
+		}
+	}
+	return len(departures) > 0
+}
+
+// tagNightLines sets the lists of day and night lines of td from the
+// night flag of its lines.
+func tagNightLines(td *TransitData) {
+	td.dayLines, td.nightLines = nil, nil
+	for _, l := range td.lines {
+		if l.IsNightLine != nil && *l.IsNightLine {
+			td.nightLines = append(td.nightLines, l)
+		} else {
+			td.dayLines = append(td.dayLines, l)
+		}
+	}
+	log.Printf("Found %v night lines (backwards and forward)", len(td.nightLines))
+}
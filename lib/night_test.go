@@ -0,0 +1,131 @@
+package transit
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+func TestNightLineDetector(t *testing.T) {
+	log.Printf("---------- TestNightLineDetector ------------ ")
+	defer os.Unsetenv(envNightLinesPattern)
+	defer os.Unsetenv(envNightLines)
+
+	testCases := []struct {
+		pattern  *string // nil if not defined
+		lines    string
+		expected map[string]bool // agency id: is night
+	}{
+		{nil, "", map[string]bool{"G1": true, "g2": true, "01": false, "A3": false}},
+		{nil, "A3, 01", map[string]bool{"G1": true, "01": true, "A3": true, "A4": false}},
+		{new(string), "a3", map[string]bool{"G1": false, "A3": true}},
+		{stringPointer("^N[0-9]+$"), "", map[string]bool{"G1": false, "N1": true, "NA": false}},
+		{stringPointer("(G"), "", map[string]bool{"G1": false}},
+	}
+	for _, tc := range testCases {
+		os.Unsetenv(envNightLinesPattern)
+		if tc.pattern != nil {
+			os.Setenv(envNightLinesPattern, *tc.pattern)
+		}
+		os.Setenv(envNightLines, tc.lines)
+		d := LoadNightLineDetector(bilbobusNightLinesPattern)
+		for id, expected := range tc.expected {
+			if d.IsNight(id) != expected {
+				t.Errorf("Pattern %v, lines %v: expected %v night %v", tc.pattern, tc.lines, id, expected)
+			}
+		}
+	}
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestIsNightSchedule(t *testing.T) {
+	log.Printf("---------- TestIsNightSchedule ------------ ")
+	h := 3600
+	testCases := []struct {
+		departures []int
+		expected   bool
+	}{
+		{[]int{22 * h, 23*h + 30*60, 24*h + 30*60, 29 * h}, true},
+		{[]int{30 * 60, 5 * h}, true},  // Service day starting at midnight
+		{[]int{23 * h, 30 * h}, false}, // 06:00 of next day
+		{[]int{21 * h, 23 * h}, false},
+		{[]int{6 * h}, false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		if actual := IsNightSchedule(tc.departures); actual != tc.expected {
+			t.Errorf("%v: expected %v, actual %v", tc.departures, tc.expected, actual)
+		}
+	}
+}
+
+func TestFindAgencyNightLines(t *testing.T) {
+	log.Printf("---------- TestFindAgencyNightLines ------------ ")
+	page := `<select name="linea" id="linea" class="select">
+            <optgroup label="Diurnas">
+            <option value="01">01 - Plaza Biribila - Arangoiti</option>
+            <option value="A3">A3 - Otxarkoaga - Santutxu</option>
+            </optgroup>
+            <optgroup label="Nocturnas">
+            <option value="G1">G1 - Moyua - Santutxu</option>
+            <option value="A3">A3 - Otxarkoaga - Santutxu</option>
+            <option value="N5">N5 - Moyua - Rekalde</option>
+            </optgroup>
+        </select>`
+	night := findAgencyNightLines(page)
+	if len(night) != 2 || !night["G1"] || !night["N5"] {
+		t.Errorf("Expected night lines G1 and N5, actual %v", night)
+	}
+}
+
+func TestGTFSNightTimetable(t *testing.T) {
+	log.Printf("---------- TestGTFSNightTimetable ------------ ")
+	h := 3600
+	stops := map[string]Stop{"0001": {Id: "0001"}}
+	services := map[string]gtfsService{
+		"FRI": {calendar: gtfsDayTypes{friday: true}},
+		"SAT": {calendar: gtfsDayTypes{saturday: true}},
+		"EVE": {added: gtfsDayTypes{sunday: true}},
+		"LAB": {calendar: gtfsDayTypes{mondayToThursday: true, friday: true}},
+		"SUN": {calendar: gtfsDayTypes{sunday: true}, added: gtfsDayTypes{mondayToThursday: true}},
+	}
+	trips := []*gtfsTrip{
+		{id: "T1", serviceId: "FRI", stopTimes: []gtfsStopTime{{"0001", 1, 23 * h}}},
+		{id: "T2", serviceId: "FRI", stopTimes: []gtfsStopTime{{"0001", 1, 24*h + 30*60}}},
+		{id: "T3", serviceId: "SAT", stopTimes: []gtfsStopTime{{"0001", 1, 25 * h}}},
+		{id: "T4", serviceId: "EVE", stopTimes: []gtfsStopTime{{"0001", 1, 26 * h}}},
+		{id: "T5", serviceId: "LAB", stopTimes: []gtfsStopTime{{"0001", 1, 23*h + 30*60}}},
+	}
+	// Sundays and an added date (eve of holiday): the added date does not
+	// take the departures of Sundays
+	sundays := []*gtfsTrip{{id: "T6", serviceId: "SUN", stopTimes: []gtfsStopTime{{"0001", 1, 27 * h}}}}
+
+	testCases := []struct {
+		trips    []*gtfsTrip
+		night    bool
+		expected Timetable
+	}{
+		{trips, true, Timetable{MondayToThrusday: "23:30", FridayNight: "23:00,23:30,00:30", SaturdayNight: "01:00", HolidayEve: "02:00"}},
+		{trips, false, Timetable{MondayToThrusday: "23:30", Friday: "23:00,23:30,00:30", Saturday: "01:00", Sunday: "02:00"}},
+		{trips[2:], false, Timetable{Weekday: "23:30", Saturday: "01:00", Sunday: "02:00"}},
+		{sundays, true, Timetable{Sunday: "03:00", HolidayEve: "03:00"}},
+		{sundays, false, Timetable{MondayToThrusday: "03:00", Sunday: "03:00"}},
+	}
+	for _, tc := range testCases {
+		s := buildGTFSLineStops(tc.trips[0], tc.trips, stops, services, tc.night)
+		if len(s) != 1 || s[0].Schedule != tc.expected {
+			t.Errorf("Night %v: expected %v, actual %v", tc.night, tc.expected, s)
+		}
+	}
+
+	td := TransitData{lines: []Line{shapeTestLine("IG1"), shapeTestLine("I01")}}
+	night := true
+	td.lines[0].IsNightLine = &night
+	tagNightLines(&td)
+	if len(td.nightLines) != 1 || td.nightLines[0].Id != "IG1" || len(td.dayLines) != 1 {
+		t.Errorf("Unexpected night lines %v and day lines %v", td.nightLines, td.dayLines)
+	}
+}
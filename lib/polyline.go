@@ -0,0 +1,192 @@
+package transit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const EnvPublishPolyline string = "PUBLISH_POLYLINE"                      // true to publish map routes as polylines
+const EnvPublishSimplifyTolerances string = "PUBLISH_SIMPLIFY_TOLERANCES" // Meters, comma separated (e.g. 5,20,80)
+const polylinePrecision float64 = 1e5                                     // 5 decimals, as Google encoded polylines
+const polyline6Precision float64 = 1e6                                    // 6 decimals, as the map routes
+
+// GeometryOptions tells how the presenters publish the map route of the lines.
+type GeometryOptions struct {
+	Polyline   bool      // Map route as encoded polylines (standard and polyline6) instead of coordinates
+	Tolerances []float64 // Meters. A simplified route is published for each
+}
+
+// SimplifiedRoute is the map route simplified with a tolerance in meters,
+// as encoded polyline.
+type SimplifiedRoute struct {
+	Tolerance float64 `json:"Tol"`
+	Polyline  string  `json:"Pl"`
+}
+
+// GeometryOptionsFromEnv reads the geometry options from the environment
+// variables PUBLISH_POLYLINE and PUBLISH_SIMPLIFY_TOLERANCES.
+func GeometryOptionsFromEnv() GeometryOptions {
+	o := GeometryOptions{Polyline: GetEnvVariableValueBool(EnvPublishPolyline)}
+	for _, v := range strings.Split(os.Getenv(EnvPublishSimplifyTolerances), ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		tolerance, err := strconv.ParseFloat(v, 64)
+		if err != nil || tolerance <= 0 {
+			log.Printf("Invalid simplify tolerance %v in %v. Ignored", v, EnvPublishSimplifyTolerances)
+			continue
+		}
+		o.Tolerances = append(o.Tolerances, tolerance)
+	}
+	return o
+}
+
+// EncodePolyline encodes the points with the Google encoded polyline
+// algorithm (precision of 5 decimals).
+func EncodePolyline(points []Point) string {
+	return encodePolyline(points, polylinePrecision)
+}
+
+// EncodePolyline6 encodes the points with the Google encoded polyline
+// algorithm with precision of 6 decimals (polyline6), the one of the map
+// routes. Standard decoders (5 decimals) do not decode it right.
+func EncodePolyline6(points []Point) string {
+	return encodePolyline(points, polyline6Precision)
+}
+
+func encodePolyline(points []Point, precision float64) string {
+	var b strings.Builder
+	var lastLat, lastLong int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * precision))
+		long := int64(math.Round(p.Long * precision))
+		encodePolylineValue(&b, lat-lastLat)
+		encodePolylineValue(&b, long-lastLong)
+		lastLat, lastLong = lat, long
+	}
+	return b.String()
+}
+
+// encodePolylineValue writes the signed delta v in chunks of 5 bits.
+func encodePolylineValue(b *strings.Builder, v int64) {
+	u := v << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte((0x20 | (u & 0x1f)) + 63))
+		u >>= 5
+	}
+	b.WriteByte(byte(u + 63))
+}
+
+// DecodePolyline decodes a Google encoded polyline.
+func DecodePolyline(s string) ([]Point, error) {
+	return decodePolyline(s, polylinePrecision)
+}
+
+// DecodePolyline6 decodes a Google encoded polyline of precision 6.
+func DecodePolyline6(s string) ([]Point, error) {
+	return decodePolyline(s, polyline6Precision)
+}
+
+func decodePolyline(s string, precision float64) ([]Point, error) {
+	var points []Point
+	var lat, long int64
+	for i := 0; i < len(s); {
+		var dLat, dLong int64
+		var err error
+		if dLat, i, err = decodePolylineValue(s, i); err != nil {
+			return nil, err
+		}
+		if dLong, i, err = decodePolylineValue(s, i); err != nil {
+			return nil, err
+		}
+		lat, long = lat+dLat, long+dLong
+		points = append(points, Point{float64(lat) / precision, float64(long) / precision})
+	}
+	return points, nil
+}
+
+// decodePolylineValue decodes the value starting at position i of s.
+// Returns the value and the position of the next one.
+func decodePolylineValue(s string, i int) (int64, int, error) {
+	var u int64
+	shift := uint(0)
+	for {
+		if i >= len(s) {
+			return 0, i, fmt.Errorf("Truncated polyline %v", s)
+		}
+		c := int64(s[i]) - 63
+		if c < 0 || c > 0x3f {
+			return 0, i, fmt.Errorf("Invalid character %q in polyline", s[i])
+		}
+		i++
+		u |= (c & 0x1f) << shift
+		shift += 5
+		if c < 0x20 {
+			break
+		}
+	}
+	if u&1 != 0 {
+		return ^(u >> 1), i, nil
+	}
+	return u >> 1, i, nil
+}
+
+// SimplifyRoute simplifies the route with the Douglas-Peucker algorithm:
+// points closer than tolerance meters to the simplified route are removed.
+// First and last points are always kept.
+func SimplifyRoute(points []Point, tolerance float64) []Point {
+	if len(points) < 3 {
+		return points
+	}
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	simplifySection(points, 0, len(points)-1, tolerance, keep)
+
+	var simplified []Point
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// simplifySection keeps the farthest point between first and last if
+// farther than tolerance, and simplifies the sections at both sides.
+func simplifySection(points []Point, first, last int, tolerance float64, keep []bool) {
+	farthest, maxDistance := -1, tolerance
+	for i := first + 1; i < last; i++ {
+		if _, d := projectOnSegment(points[i], points[first], points[last]); d > maxDistance {
+			farthest, maxDistance = i, d
+		}
+	}
+	if farthest < 0 {
+		return
+	}
+	keep[farthest] = true
+	simplifySection(points, first, farthest, tolerance, keep)
+	simplifySection(points, farthest, last, tolerance, keep)
+}
+
+// routePoints returns the map route of l as points. Invalid
+// coordinates are skipped.
+func routePoints(l Line) []Point {
+	points := make([]Point, 0, len(l.MapRoute))
+	for _, c := range l.MapRoute {
+		p, err := c.ToPoint()
+		if err != nil {
+			continue
+		}
+		points = append(points, p)
+	}
+	return points
+}
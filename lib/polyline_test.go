@@ -0,0 +1,124 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	log.Printf("---------- TestEncodePolyline ------------ ")
+	testCases := []struct {
+		points   []Point
+		expected string
+	}{
+		// Example of the algorithm documentation
+		{[]Point{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{[]Point{{43.2630, -2.9350}}, "wx`gGvf|P"},
+		{nil, ""},
+	}
+	for _, tc := range testCases {
+		actual := EncodePolyline(tc.points)
+		if actual != tc.expected {
+			t.Errorf("%v: expected %v, actual %v", tc.points, tc.expected, actual)
+		}
+		decoded, err := DecodePolyline(actual)
+		if err != nil || len(decoded) != len(tc.points) {
+			t.Errorf("%v: unexpected decoded points %v (%v)", tc.expected, decoded, err)
+			continue
+		}
+		for i := range decoded {
+			if math.Abs(decoded[i].Lat-tc.points[i].Lat) > 1e-6 || math.Abs(decoded[i].Long-tc.points[i].Long) > 1e-6 {
+				t.Errorf("%v: point %v decoded as %v", tc.expected, tc.points[i], decoded[i])
+			}
+		}
+	}
+
+	for _, invalid := range []string{"_p~iF~ps|", "_p~iF ~ps|U"} {
+		if _, err := DecodePolyline(invalid); err == nil {
+			t.Errorf("Expected error decoding %v", invalid)
+		}
+	}
+
+	// Polyline6 keeps the 6 decimals of the map routes
+	points := []Point{{38.5, -120.2}, {43.263012, -2.935047}}
+	actual := EncodePolyline6(points)
+	if expected := "_izlhA~rlgdFgwuaHqbht~E"; actual != expected {
+		t.Errorf("Expected polyline6 %v, actual %v", expected, actual)
+	}
+	decoded, err := DecodePolyline6(actual)
+	if err != nil || len(decoded) != 2 || math.Abs(decoded[1].Lat-43.263012) > 1e-9 || math.Abs(decoded[1].Long+2.935047) > 1e-9 {
+		t.Errorf("Unexpected decoded polyline6 %v (%v)", decoded, err)
+	}
+}
+
+func TestSimplifyRoute(t *testing.T) {
+	log.Printf("---------- TestSimplifyRoute ------------ ")
+	// Almost straight south, 3 m off at the second point, then east.
+	route := []Point{{43.2630, -2.9350}, {43.2620, -2.93504}, {43.2610, -2.9350}, {43.2610, -2.9330}}
+	testCases := []struct {
+		tolerance float64
+		expected  int // points
+	}{
+		{1, 4},
+		{10, 3},
+		{1000, 2},
+	}
+	for _, tc := range testCases {
+		actual := SimplifyRoute(route, tc.tolerance)
+		if len(actual) != tc.expected || actual[0] != route[0] || actual[len(actual)-1] != route[len(route)-1] {
+			t.Errorf("Tolerance %v: expected %v points, actual %v", tc.tolerance, tc.expected, actual)
+		}
+	}
+}
+
+func TestJsonPresenterGeometry(t *testing.T) {
+	log.Printf("---------- TestJsonPresenterGeometry ------------ ")
+	os.Setenv(EnvPublishPolyline, "true")
+	os.Setenv(EnvPublishSimplifyTolerances, "10, x,1000")
+	defer os.Unsetenv(EnvPublishPolyline)
+	defer os.Unsetenv(EnvPublishSimplifyTolerances)
+
+	l := shapeTestLine("I01")
+	l.MapRoute = []Coordinates{{"43.2630", "-2.9350"}, {"43.2620", "-2.93504"}, {"43.2610", "-2.9350"}, {"43.2610", "-2.9330"}}
+	p := JsonPresenter{Geometry: GeometryOptionsFromEnv()}
+	s, err := p.FormatList([]Line{l})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	var actual []struct {
+		Id  string
+		Map []Coordinates
+		Pl  string
+		Pl6 string
+		Pz  []SimplifiedRoute
+	}
+	if err := json.Unmarshal([]byte(s), &actual); err != nil || len(actual) != 1 {
+		t.Fatalf("Unexpected presented lines %v (%v)", s, err)
+	}
+	if actual[0].Id != "I01" || actual[0].Map != nil {
+		t.Errorf("Expected line I01 without map route, actual %v", actual[0])
+	}
+	if points, _ := DecodePolyline(actual[0].Pl); len(points) != 4 {
+		t.Errorf("Expected 4 points in polyline, actual %v", points)
+	}
+	if points, _ := DecodePolyline6(actual[0].Pl6); len(points) != 4 || math.Abs(points[1].Long+2.93504) > 1e-9 {
+		t.Errorf("Expected 4 points in polyline6, actual %v", points)
+	}
+	if len(actual[0].Pz) != 2 || actual[0].Pz[0].Tolerance != 10 || actual[0].Pz[1].Tolerance != 1000 {
+		t.Fatalf("Unexpected simplified routes %v", actual[0].Pz)
+	}
+	if points, _ := DecodePolyline(actual[0].Pz[1].Polyline); len(points) != 2 {
+		t.Errorf("Expected 2 points in route simplified at 1000 m, actual %v", points)
+	}
+
+	// Map route as coordinates by default
+	s, _ = JsonPresenter{}.Format(l)
+	actual = nil
+	if err := json.Unmarshal([]byte("["+s+"]"), &actual); err != nil || len(actual[0].Map) != 4 || len(actual[0].Pl) > 0 {
+		t.Errorf("Unexpected default presentation %v (%v)", s, err)
+	}
+}
@@ -3,18 +3,94 @@ package transit
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"os"
+	"strings"
 )
 
-// Bilbobus is a parser of transit information of Bilbao bus agency.
+// Constants
+const EnvPublishLanguage string = "PUBLISH_LANGUAGE"
+const EnvPublishFormats string = "PUBLISH_FORMATS"                      // Comma separated, e.g. json,geojson,kml,protobuf
+const EnvPublishConnectionsString string = "PUBLISH_CONNECTIONS_STRING" // true to publish connections as "I03 V27"
+const FormatJSON string = "json"
+const FormatGeoJSON string = "geojson"
+const FormatKML string = "kml"
+const FormatProtobuf string = "protobuf"
+
+// PresentersFromEnv returns the presenters of the formats in env variable
+// PUBLISH_FORMATS (JSON if not defined), configured from the environment.
+// Presenters are canonical (see CanonicalPresenter) if PUBLISH_CANONICAL.
+func PresentersFromEnv() ([]Presenter, error) {
+	formats := os.Getenv(EnvPublishFormats)
+	if len(strings.TrimSpace(formats)) == 0 {
+		formats = FormatJSON
+	}
+
+	language := os.Getenv(EnvPublishLanguage)
+	var presenters []Presenter
+	for _, f := range strings.Split(formats, ",") {
+		switch strings.ToLower(strings.TrimSpace(f)) {
+		case FormatJSON:
+			presenters = append(presenters, JsonPresenter{Language: language, Geometry: GeometryOptionsFromEnv(),
+				ConnectionsString: GetEnvVariableValueBool(EnvPublishConnectionsString)})
+		case FormatGeoJSON:
+			presenters = append(presenters, GeoJsonPresenter{Language: language})
+		case FormatKML:
+			presenters = append(presenters, KmlPresenter{Language: language})
+		case FormatProtobuf:
+			presenters = append(presenters, ProtoPresenter{Language: language, Metadata: readMetadata()})
+		default:
+			return nil, fmt.Errorf("Unknown publish format %v in %v", f, EnvPublishFormats)
+		}
+	}
+	if GetEnvVariableValueBool(EnvPublishCanonical) {
+		for i, p := range presenters {
+			presenters[i] = CanonicalPresenter{p}
+		}
+	}
+	return presenters, nil
+}
+
+// JsonPresenter formats lines as JSON, with the names in Language (see
+// localize). Geometry selects the format of the map routes.
+// ConnectionsString is the compatibility mode that presents the
+// connections of the stops as line ids separated by spaces.
 type JsonPresenter struct {
+	Language          string
+	Geometry          GeometryOptions
+	ConnectionsString bool
+}
+
+// presentedLine is a line with its map route as encoded polylines and
+// its stops as presented (e.g. with connections as string).
+type presentedLine struct {
+	Line
+	Stops      interface{}       `json:"Stops,omitempty"`
+	Polyline   string            `json:"Pl,omitempty"`
+	Polyline6  string            `json:"Pl6,omitempty"`
+	Simplified []SimplifiedRoute `json:"Pz,omitempty"`
+}
+
+// presentedStop is a stop with its connections as string.
+type presentedStop struct {
+	Stop
+	Connections string `json:"Co,omitempty"`
+}
+
+// presentedAggregatedStop is a stop of the stops document with the ids
+// of the lines serving it as string, as the connections of the stops
+// of the lines.
+type presentedAggregatedStop struct {
+	AggregatedStop
+	Connections string `json:"Co,omitempty"`
 }
 
 // Returns line with the right format to be presented.
 // Tipically the chosen format is json.
 func (p JsonPresenter) Format(l Line) (string, error) {
-	b, err := json.MarshalIndent(l, "", "    ")
+	b, err := json.MarshalIndent(p.present(l), "", "    ")
 	if err != nil {
-		fmt.Println(err)
+		log.Printf("Error formatting line %v as JSON. Error: %v", l.Id, err)
 		return "", err
 	}
 	return string(b), nil
@@ -23,10 +99,106 @@ func (p JsonPresenter) Format(l Line) (string, error) {
 // Returns the array of lines with the right format to be presented.
 // Tipically the chosen format is json.
 func (p JsonPresenter) FormatList(l []Line) (string, error) {
-	b, err := json.MarshalIndent(l, "", "    ")
+	presented := make([]interface{}, len(l))
+	for i, line := range l {
+		presented[i] = p.present(line)
+	}
+	b, err := json.MarshalIndent(presented, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting lines as JSON. Error: %v", err)
+		return "", err
+	}
+	return string(b), nil
+}
+
+// OutputName returns the name of the file with the list of lines.
+func (p JsonPresenter) OutputName() string {
+	return formmatedLinesOutputName
+}
+
+// Returns the stops, with the lines serving them, as JSON. In
+// compatibility mode the stops have also the ids of those lines as string.
+func (p JsonPresenter) FormatStops(s []AggregatedStop) (string, error) {
+	localized := localizeStops(s, p.Language)
+	var presented interface{} = localized
+	if p.ConnectionsString {
+		stops := make([]presentedAggregatedStop, len(localized))
+		for i, stop := range localized {
+			connections := make(Connections, len(stop.Services))
+			for j, service := range stop.Services {
+				connections[j] = Connection{LineId: service.LineId, Direction: service.Direction}
+			}
+			stops[i] = presentedAggregatedStop{stop, connections.String()}
+		}
+		presented = stops
+	}
+	b, err := json.MarshalIndent(presented, "", "    ")
 	if err != nil {
-		fmt.Println(err)
+		log.Printf("Error formatting stops as JSON. Error: %v", err)
 		return "", err
 	}
 	return string(b), nil
 }
+
+// StopsOutputName returns the name of the file with the list of stops.
+func (p JsonPresenter) StopsOutputName() string {
+	return formmatedStopsOutputName
+}
+
+// present returns l localized, with the map route in the geometry
+// format and the connections in the format of the presenter.
+func (p JsonPresenter) present(l Line) interface{} {
+	l = localize(l, p.Language)
+	if !p.Geometry.Polyline && len(p.Geometry.Tolerances) == 0 && !p.ConnectionsString {
+		return l
+	}
+
+	pl := presentedLine{Line: l}
+	if len(l.Stops) > 0 {
+		pl.Stops = l.Stops
+	}
+	if p.ConnectionsString && len(l.Stops) > 0 {
+		stops := make([]presentedStop, len(l.Stops))
+		for i, s := range l.Stops {
+			stops[i] = presentedStop{s, s.Connections.String()}
+		}
+		pl.Stops = stops
+	}
+	points := routePoints(l)
+	if p.Geometry.Polyline {
+		pl.Polyline = EncodePolyline(points)
+		pl.Polyline6 = EncodePolyline6(points)
+		pl.MapRoute = nil
+	}
+	for _, tolerance := range p.Geometry.Tolerances {
+		pl.Simplified = append(pl.Simplified, SimplifiedRoute{tolerance, EncodePolyline(SimplifyRoute(points, tolerance))})
+	}
+	return pl
+}
+
+// localize returns a copy of l with the names in language (es, eu), the
+// one presenters select the names of lines and stops to present in.
+// Canonical names when not available in that language. l, with the
+// names per language, if language is empty.
+func localize(l Line, language string) Line {
+	if len(language) == 0 {
+		return l
+	}
+
+	if name := l.Names.In(language); len(name) > 0 {
+		l.Name = name
+	}
+	l.Names = nil
+	stops := make([]Stop, len(l.Stops))
+	for i, s := range l.Stops {
+		if name := s.Names.In(language); len(name) > 0 {
+			s.Name = name
+		}
+		s.Names = nil
+		stops[i] = s
+	}
+	if l.Stops != nil {
+		l.Stops = stops
+	}
+	return l
+}
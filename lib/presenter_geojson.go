@@ -0,0 +1,163 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+)
+
+// GeoJsonPresenter formats lines as a GeoJSON FeatureCollection: a
+// LineString feature per line (and direction) and a Point feature per stop,
+// with the names in Language (see localize). Lines without at least two
+// located points have no geometry (null), as a LineString needs two.
+type GeoJsonPresenter struct {
+	Language string
+}
+
+type geoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   *geoJsonGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJsonGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// gisStop is a stop with the ids of the lines stopping at it.
+type gisStop struct {
+	Stop
+	Lines []string
+}
+
+// Returns the line and its stops as GeoJSON.
+func (p GeoJsonPresenter) Format(l Line) (string, error) {
+	return p.FormatList([]Line{l})
+}
+
+// Returns the lines and their stops as GeoJSON.
+func (p GeoJsonPresenter) FormatList(l []Line) (string, error) {
+	lines := make([]Line, len(l))
+	for i, line := range l {
+		lines[i] = localize(line, p.Language)
+	}
+
+	features := make([]geoJsonFeature, 0)
+	for _, line := range lines {
+		var geometry *geoJsonGeometry
+		if route := gisLineRoute(line); len(route) > 1 {
+			geometry = &geoJsonGeometry{"LineString", geoJsonPositions(route)}
+		}
+		features = append(features, geoJsonFeature{"Feature", geometry, gisLineProperties(line)})
+	}
+	for _, s := range gisStops(lines) {
+		p, _ := s.Location.ToPoint()
+		features = append(features, geoJsonFeature{"Feature",
+			&geoJsonGeometry{"Point", []float64{p.Long, p.Lat}},
+			map[string]interface{}{"Id": s.Id, "Name": s.Name, "Connections": s.Connections, "Lines": s.Lines}})
+	}
+
+	b, err := json.MarshalIndent(map[string]interface{}{"type": "FeatureCollection", "features": features}, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting lines as GeoJSON. Error: %v", err)
+		return "", err
+	}
+	return string(b), nil
+}
+
+// OutputName returns the name of the file with the list of lines.
+func (p GeoJsonPresenter) OutputName() string {
+	return geoJsonLinesOutputName
+}
+
+// Returns the stops as GeoJSON Point features, with the lines serving
+// them and their timetables.
+func (p GeoJsonPresenter) FormatStops(s []AggregatedStop) (string, error) {
+	features := make([]geoJsonFeature, 0)
+	for _, stop := range localizeStops(s, p.Language) {
+		point, err := stop.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		features = append(features, geoJsonFeature{"Feature",
+			&geoJsonGeometry{"Point", []float64{point.Long, point.Lat}},
+			map[string]interface{}{"Id": stop.Id, "Name": stop.Name, "StationId": stop.StationId, "Lines": stop.Services}})
+	}
+
+	b, err := json.MarshalIndent(map[string]interface{}{"type": "FeatureCollection", "features": features}, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting stops as GeoJSON. Error: %v", err)
+		return "", err
+	}
+	return string(b), nil
+}
+
+// StopsOutputName returns the name of the file with the list of stops.
+func (p GeoJsonPresenter) StopsOutputName() string {
+	return geoJsonStopsOutputName
+}
+
+// geoJsonPositions returns the points as GeoJSON positions (long, lat).
+func geoJsonPositions(points []Point) [][]float64 {
+	positions := make([][]float64, len(points))
+	for i, p := range points {
+		positions[i] = []float64{p.Long, p.Lat}
+	}
+	return positions
+}
+
+// gisLineRoute returns the map route of l, or the location of its stops
+// if it has no map route.
+func gisLineRoute(l Line) []Point {
+	if points := routePoints(l); len(points) > 1 {
+		return points
+	}
+	points := make([]Point, 0, len(l.Stops))
+	for _, s := range l.Stops {
+		if p, err := s.Location.ToPoint(); err == nil {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// gisLineProperties returns the properties of the feature of l.
+func gisLineProperties(l Line) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":          l.Id,
+		"Name":        l.Name,
+		"Number":      l.Number,
+		"AgencyId":    l.AgencyId,
+		"Direction":   l.Direction,
+		"IsNightLine": l.IsNightLine != nil && *l.IsNightLine,
+	}
+}
+
+// gisStops returns the stops of the lines with valid location, once
+// each and sorted by id, with the lines stopping at them.
+func gisStops(lines []Line) []gisStop {
+	byId := make(map[string]*gisStop)
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			if _, err := s.Location.ToPoint(); err != nil {
+				continue
+			}
+			gs, found := byId[s.Id]
+			if !found {
+				gs = &gisStop{Stop: s}
+				byId[s.Id] = gs
+			}
+			if len(gs.Lines) == 0 || gs.Lines[len(gs.Lines)-1] != l.Id {
+				gs.Lines = append(gs.Lines, l.Id)
+			}
+		}
+	}
+
+	stops := make([]gisStop, 0, len(byId))
+	for _, gs := range byId {
+		stops = append(stops, *gs)
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+	return stops
+}
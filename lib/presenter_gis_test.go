@@ -0,0 +1,143 @@
+package transit
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func gisTestLines() []Line {
+	night := true
+	forward := shapeTestLine("I01")
+	forward.MapRoute = []Coordinates{{"43.2630", "-2.9350"}, {"43.2610", "-2.9350"}, {"43.2610", "-2.9310"}}
+	forward.Stops[0].Names = &Names{"Moyua", "Moiua"}
+	backward := shapeTestLine("V01")
+	backward.Direction = DirectionBackward
+	backward.IsNightLine = &night
+	backward.Stops = []Stop{backward.Stops[2], {Id: "0004", Location: Coordinates{"43.2640", "-2.9350"}}}
+	return []Line{forward, backward}
+}
+
+func TestGeoJsonPresenter(t *testing.T) {
+	log.Printf("---------- TestGeoJsonPresenter ------------ ")
+	s, err := GeoJsonPresenter{Language: LanguageBasque}.FormatList(gisTestLines())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	var fc struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates json.RawMessage
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal([]byte(s), &fc); err != nil || fc.Type != "FeatureCollection" || len(fc.Features) != 6 {
+		t.Fatalf("Unexpected feature collection %v (%v)", s, err)
+	}
+
+	testCases := []struct {
+		feature  int
+		geometry string
+		property string
+		expected string
+	}{
+		{0, "LineString", "Id", "I01"},
+		{0, "LineString", "AgencyId", "01"},
+		{0, "LineString", "IsNightLine", "false"},
+		{1, "LineString", "Number", "1"},
+		{1, "LineString", "IsNightLine", "true"},
+		{2, "Point", "Name", "Moiua"},
+		{4, "Point", "Id", "0003"},
+		{4, "Point", "Lines", "[I01 V01]"},
+		{5, "Point", "Lines", "[V01]"},
+	}
+	for _, tc := range testCases {
+		f := fc.Features[tc.feature]
+		if actual := fmtProperty(f.Properties[tc.property]); f.Geometry.Type != tc.geometry || actual != tc.expected {
+			t.Errorf("Feature %v (%v): expected %v %v, actual %v", tc.feature, f.Geometry.Type, tc.property, tc.expected, actual)
+		}
+	}
+
+	shapes, err := readGeoJSONShapes(strings.NewReader(s))
+	if err != nil || len(shapes["I01"]) != 3 || shapes["I01"][2] != (Point{43.2610, -2.9310}) || len(shapes["V01"]) != 2 {
+		t.Errorf("Unexpected routes of lines %v (%v)", shapes, err)
+	}
+
+	// Line with a single located stop and no map route has no geometry
+	single := shapeTestLine("I02")
+	single.Stops = single.Stops[:1]
+	s, _ = GeoJsonPresenter{}.Format(single)
+	if !strings.Contains(s, `"geometry": null`) || strings.Contains(s, `"LineString"`) {
+		t.Errorf("Expected line without geometry in %v", s)
+	}
+	if shapes, err := readGeoJSONShapes(strings.NewReader(s)); err != nil || len(shapes) != 0 {
+		t.Errorf("Unexpected routes of lines %v (%v)", shapes, err)
+	}
+}
+
+func TestKmlPresenter(t *testing.T) {
+	log.Printf("---------- TestKmlPresenter ------------ ")
+	s, err := KmlPresenter{}.FormatList(gisTestLines())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	shapes, err := readKMLShapes(strings.NewReader(s))
+	if err != nil || len(shapes) != 1 || len(shapes["Moyua - Abando"]) != 5 {
+		t.Errorf("Unexpected routes of lines %v (%v)", shapes, err)
+	}
+	for _, expected := range []string{
+		`<Data name="Number">`, `<value>01</value>`, `<value>I01,V01</value>`,
+		`<coordinates>-2.935000,43.264000</coordinates>`, `<name>Stops</name>`,
+	} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("Expected %v in KML document", expected)
+		}
+	}
+}
+
+func TestPresentersFromEnv(t *testing.T) {
+	log.Printf("---------- TestPresentersFromEnv ------------ ")
+	defer os.Unsetenv(EnvPublishFormats)
+	testCases := []struct {
+		formats  string
+		expected []string // output names
+		valid    bool
+	}{
+		{"", []string{"alllines.json"}, true},
+		{"json, GeoJSON,kml", []string{"alllines.json", "alllines.geojson", "alllines.kml"}, true},
+		{"protobuf", []string{"alllines.pb"}, true},
+		{"json,shp", nil, false},
+	}
+	for _, tc := range testCases {
+		os.Setenv(EnvPublishFormats, tc.formats)
+		presenters, err := PresentersFromEnv()
+		if (err == nil) != tc.valid || len(presenters) != len(tc.expected) {
+			t.Errorf("%v: expected %v, actual %v (%v)", tc.formats, tc.expected, presenters, err)
+			continue
+		}
+		for i, p := range presenters {
+			if p.OutputName() != tc.expected[i] {
+				t.Errorf("%v: expected %v, actual %v", tc.formats, tc.expected[i], p.OutputName())
+			}
+		}
+	}
+}
+
+func fmtProperty(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		s := make([]string, len(list))
+		for i, item := range list {
+			s[i] = fmtProperty(item)
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	}
+	b, _ := json.Marshal(v)
+	return strings.Trim(string(b), "\"")
+}
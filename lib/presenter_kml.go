@@ -0,0 +1,137 @@
+package transit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const kmlNamespace string = "http://www.opengis.net/kml/2.2"
+
+// KmlPresenter formats lines as a KML document: a folder with a
+// LineString placemark per line (and direction) and a folder with a
+// Point placemark per stop, with the names in Language (see localize).
+type KmlPresenter struct {
+	Language string
+}
+
+type kmlDocument struct {
+	XMLName xml.Name    `xml:"kml"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Folders []kmlFolder `xml:"Document>Folder"`
+}
+
+type kmlFolder struct {
+	Name       string       `xml:"name"`
+	Placemarks []kmlFeature `xml:"Placemark"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlFeature struct {
+	Name        string    `xml:"name"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"LineString>coordinates,omitempty"`
+	Point       string    `xml:"Point>coordinates,omitempty"`
+}
+
+// Returns the line and its stops as KML.
+func (p KmlPresenter) Format(l Line) (string, error) {
+	return p.FormatList([]Line{l})
+}
+
+// Returns the lines and their stops as KML.
+func (p KmlPresenter) FormatList(l []Line) (string, error) {
+	lines := make([]Line, len(l))
+	for i, line := range l {
+		lines[i] = localize(line, p.Language)
+	}
+
+	linesFolder := kmlFolder{Name: "Lines"}
+	for _, line := range lines {
+		linesFolder.Placemarks = append(linesFolder.Placemarks, kmlFeature{
+			Name:        line.Name,
+			Data:        kmlProperties(gisLineProperties(line), "Id", "Number", "AgencyId", "Direction", "IsNightLine"),
+			Coordinates: kmlCoordinates(gisLineRoute(line)...),
+		})
+	}
+	stopsFolder := kmlFolder{Name: "Stops"}
+	for _, s := range gisStops(lines) {
+		p, _ := s.Location.ToPoint()
+		stopsFolder.Placemarks = append(stopsFolder.Placemarks, kmlFeature{
+			Name:  s.Name,
+			Data:  []kmlData{{"Id", s.Id}, {"Connections", s.Connections.String()}, {"Lines", strings.Join(s.Lines, ",")}},
+			Point: kmlCoordinates(p),
+		})
+	}
+
+	b, err := xml.MarshalIndent(kmlDocument{Xmlns: kmlNamespace, Folders: []kmlFolder{linesFolder, stopsFolder}}, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting lines as KML. Error: %v", err)
+		return "", err
+	}
+	return xml.Header + string(b), nil
+}
+
+// OutputName returns the name of the file with the list of lines.
+func (p KmlPresenter) OutputName() string {
+	return kmlLinesOutputName
+}
+
+// Returns the stops as KML Point placemarks, with the timetable of every
+// line serving them as data named after the line.
+func (p KmlPresenter) FormatStops(s []AggregatedStop) (string, error) {
+	stopsFolder := kmlFolder{Name: "Stops"}
+	for _, stop := range localizeStops(s, p.Language) {
+		point, err := stop.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		data := []kmlData{{"Id", stop.Id}, {"StationId", stop.StationId}, {"Lines", strings.Join(stop.LineIds(), ",")}}
+		for _, service := range stop.Services {
+			var days []string
+			for _, d := range timetableDays(service.Schedule) {
+				days = append(days, d.name+": "+d.times)
+			}
+			data = append(data, kmlData{service.LineId, strings.Join(days, "; ")})
+		}
+		stopsFolder.Placemarks = append(stopsFolder.Placemarks, kmlFeature{Name: stop.Name, Data: data, Point: kmlCoordinates(point)})
+	}
+
+	b, err := xml.MarshalIndent(kmlDocument{Xmlns: kmlNamespace, Folders: []kmlFolder{stopsFolder}}, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting stops as KML. Error: %v", err)
+		return "", err
+	}
+	return xml.Header + string(b), nil
+}
+
+// StopsOutputName returns the name of the file with the list of stops.
+func (p KmlPresenter) StopsOutputName() string {
+	return kmlStopsOutputName
+}
+
+// kmlCoordinates formats the points as KML coordinates (long,lat).
+func kmlCoordinates(points ...Point) string {
+	tuples := make([]string, len(points))
+	for i, p := range points {
+		tuples[i] = strconv.FormatFloat(p.Long, 'f', 6, 64) + "," + strconv.FormatFloat(p.Lat, 'f', 6, 64)
+	}
+	return strings.Join(tuples, " ")
+}
+
+// kmlProperties returns the properties with the given names as KML data,
+// in that order.
+func kmlProperties(properties map[string]interface{}, names ...string) []kmlData {
+	data := make([]kmlData, len(names))
+	for i, name := range names {
+		data[i] = kmlData{name, fmt.Sprint(properties[name])}
+	}
+	return data
+}
@@ -0,0 +1,348 @@
+package transit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Constants
+const protoCoordinatesPrecision float64 = 1e6 // Millionths of degree
+const protoWireVarint int = 0
+const protoWireBytes int = 2
+
+// Field numbers of the messages of proto/transit.proto
+const (
+	protoTransitMetadata = 1
+	protoTransitStops    = 2
+	protoTransitLines    = 3
+
+	protoStopsStops = 1
+
+	protoStopId        = 1
+	protoStopName      = 2
+	protoStopLat       = 3
+	protoStopLong      = 4
+	protoStopStationId = 5
+	protoStopNames     = 6
+	protoStopLines     = 7 // AggregatedStop only
+
+	protoNamesEs = 1
+	protoNamesEu = 2
+
+	protoConnectionLineId    = 1
+	protoConnectionDirection = 2
+	protoConnectionAgency    = 3
+
+	protoLineStopStop          = 1
+	protoLineStopSchedule      = 2
+	protoLineStopConnections   = 3
+	protoLineStopShapeDistance = 4
+
+	protoLineId        = 1
+	protoLineAgencyId  = 2
+	protoLineNumber    = 3
+	protoLineName      = 4
+	protoLineDirection = 5
+	protoLineStops     = 6
+	protoLineMapRoute  = 7
+	protoLineNight     = 8
+	protoLineNames     = 9
+
+	protoServiceLineId    = 1
+	protoServiceAgencyId  = 2
+	protoServiceNumber    = 3
+	protoServiceName      = 4
+	protoServiceDirection = 5
+	protoServiceSchedule  = 6
+	protoServiceNames     = 7
+)
+
+// Values of enum Direction
+const protoDirectionForward uint64 = 1
+const protoDirectionBackward uint64 = 2
+
+// ProtoPresenter formats lines and stops as Protocol Buffers, messages
+// Transit and Stops of proto/transit.proto, a compact binary alternative
+// to JSON for mobile clients. Metadata is presented along with the lines
+// as it is, so the same data is always presented byte by byte the same.
+// Language selects the names presented (see localize).
+type ProtoPresenter struct {
+	Language string
+	Metadata []MetadataItem
+}
+
+// Returns the line as a Transit message.
+func (p ProtoPresenter) Format(l Line) (string, error) {
+	return p.FormatList([]Line{l})
+}
+
+// Returns the lines as a Transit message. Stops are written once and
+// referenced from the lines.
+func (p ProtoPresenter) FormatList(l []Line) (string, error) {
+	var b protoBuffer
+	for _, m := range p.Metadata {
+		b.message(protoTransitMetadata, encodeProtoMetadata(m))
+	}
+
+	var stops []Stop
+	index := make(map[string]int)
+	var lines protoBuffer
+	for _, line := range l {
+		line = localize(line, p.Language)
+		refs := make([]int, len(line.Stops))
+		for i, s := range line.Stops {
+			key := protoStopKey(s)
+			ref, found := index[key]
+			if !found {
+				ref = len(stops)
+				index[key] = ref
+				stops = append(stops, s)
+			}
+			refs[i] = ref
+		}
+		encoded, err := encodeProtoLine(line, refs)
+		if err != nil {
+			return "", err
+		}
+		lines.message(protoTransitLines, encoded)
+	}
+	for _, s := range stops {
+		b.message(protoTransitStops, encodeProtoStop(s))
+	}
+	b = append(b, lines...)
+	return string(b), nil
+}
+
+// OutputName returns the name of the file with the list of lines.
+func (p ProtoPresenter) OutputName() string {
+	return protoLinesOutputName
+}
+
+// Returns the stops, with the lines serving them, as a Stops message.
+func (p ProtoPresenter) FormatStops(s []AggregatedStop) (string, error) {
+	var b protoBuffer
+	for _, stop := range localizeStops(s, p.Language) {
+		encoded, err := encodeProtoAggregatedStop(stop)
+		if err != nil {
+			return "", err
+		}
+		b.message(protoStopsStops, encoded)
+	}
+	return string(b), nil
+}
+
+// StopsOutputName returns the name of the file with the list of stops.
+func (p ProtoPresenter) StopsOutputName() string {
+	return protoStopsOutputName
+}
+
+// protoStopKey identifies the data of the stop written in a Stop message.
+// Stops with the same id but different data are written apart.
+func protoStopKey(s Stop) string {
+	return strings.Join([]string{s.Id, s.Name, s.Location.Lat, s.Location.Long, s.StationId, s.Names.In(LanguageSpanish), s.Names.In(LanguageBasque)}, "\x00")
+}
+
+func encodeProtoMetadata(m MetadataItem) []byte {
+	var b protoBuffer
+	for i, v := range []string{m.MinVersion, m.MaxVersion, m.PathData, m.Validity, m.UpdateClient, m.LastUpdate} {
+		b.string(i+1, v)
+	}
+	return b
+}
+
+func encodeProtoStop(s Stop) []byte {
+	var b protoBuffer
+	b.string(protoStopId, s.Id)
+	b.string(protoStopName, s.Name)
+	lat, long := protoCoordinates(s.Location)
+	b.sint(protoStopLat, lat)
+	b.sint(protoStopLong, long)
+	b.string(protoStopStationId, s.StationId)
+	b.names(protoStopNames, s.Names)
+	return b
+}
+
+func encodeProtoLine(l Line, stopRefs []int) ([]byte, error) {
+	var b protoBuffer
+	b.string(protoLineId, l.Id)
+	b.string(protoLineAgencyId, l.AgencyId)
+	b.varint(protoLineNumber, uint64(int64(l.Number)))
+	b.string(protoLineName, l.Name)
+	b.varint(protoLineDirection, protoDirection(l.Direction))
+	for i, s := range l.Stops {
+		var ls protoBuffer
+		ls.varint(protoLineStopStop, uint64(stopRefs[i]))
+		if err := ls.timetable(protoLineStopSchedule, s.Schedule); err != nil {
+			return nil, fmt.Errorf("Invalid schedule of stop %v of line %v: %v", s.Id, l.Id, err)
+		}
+		for _, c := range s.Connections {
+			var cb protoBuffer
+			cb.string(protoConnectionLineId, c.LineId)
+			cb.varint(protoConnectionDirection, protoDirection(c.Direction))
+			cb.string(protoConnectionAgency, c.Agency)
+			ls.message(protoLineStopConnections, cb)
+		}
+		ls.varint(protoLineStopShapeDistance, uint64(s.ShapeDistance))
+		b.message(protoLineStops, ls)
+	}
+
+	var route []uint64
+	var previousLat, previousLong int64
+	for _, c := range l.MapRoute {
+		lat, long := protoCoordinates(c)
+		route = append(route, protoZigZag(lat-previousLat), protoZigZag(long-previousLong))
+		previousLat, previousLong = lat, long
+	}
+	b.packed(protoLineMapRoute, route)
+	if l.IsNightLine != nil && *l.IsNightLine {
+		b.varint(protoLineNight, 1)
+	}
+	b.names(protoLineNames, l.Names)
+	return b, nil
+}
+
+func encodeProtoAggregatedStop(s AggregatedStop) ([]byte, error) {
+	var b protoBuffer
+	b.string(protoStopId, s.Id)
+	b.string(protoStopName, s.Name)
+	lat, long := protoCoordinates(s.Location)
+	b.sint(protoStopLat, lat)
+	b.sint(protoStopLong, long)
+	b.string(protoStopStationId, s.StationId)
+	b.names(protoStopNames, s.Names)
+	for _, service := range s.Services {
+		var sb protoBuffer
+		sb.string(protoServiceLineId, service.LineId)
+		sb.string(protoServiceAgencyId, service.AgencyId)
+		sb.varint(protoServiceNumber, uint64(int64(service.Number)))
+		sb.string(protoServiceName, service.Name)
+		sb.varint(protoServiceDirection, protoDirection(service.Direction))
+		if err := sb.timetable(protoServiceSchedule, service.Schedule); err != nil {
+			return nil, fmt.Errorf("Invalid schedule of line %v at stop %v: %v", service.LineId, s.Id, err)
+		}
+		sb.names(protoServiceNames, service.Names)
+		b.message(protoStopLines, sb)
+	}
+	return b, nil
+}
+
+// protoTimetableFields returns the fields of t in the order of the
+// fields of message Timetable.
+func protoTimetableFields(t *Timetable) []*string {
+	return []*string{&t.Weekday, &t.MondayToThrusday, &t.Friday, &t.Saturday, &t.Sunday,
+		&t.FridayNight, &t.SaturdayNight, &t.HolidayEve}
+}
+
+// protoCoordinates returns the coordinates in millionths of degree.
+// Zero if not valid.
+func protoCoordinates(c Coordinates) (int64, int64) {
+	p, err := c.ToPoint()
+	if err != nil {
+		return 0, 0
+	}
+	return int64(math.Round(p.Lat * protoCoordinatesPrecision)), int64(math.Round(p.Long * protoCoordinatesPrecision))
+}
+
+func protoDirection(direction string) uint64 {
+	switch direction {
+	case DirectionForward:
+		return protoDirectionForward
+	case DirectionBackward:
+		return protoDirectionBackward
+	}
+	return 0
+}
+
+func protoZigZag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// protoBuffer is a Protocol Buffers message being encoded. Fields with
+// default values (zero, empty) are not written, as proto3 does.
+type protoBuffer []byte
+
+func (b *protoBuffer) rawVarint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.rawVarint(uint64(field<<3 | wire))
+}
+
+func (b *protoBuffer) varint(field int, v uint64) {
+	if v != 0 {
+		b.tag(field, protoWireVarint)
+		b.rawVarint(v)
+	}
+}
+
+func (b *protoBuffer) sint(field int, v int64) {
+	b.varint(field, protoZigZag(v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, protoWireBytes)
+	b.rawVarint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	if len(v) > 0 {
+		b.bytes(field, []byte(v))
+	}
+}
+
+// message writes an embedded message, even if empty (e.g. element of a
+// repeated field).
+func (b *protoBuffer) message(field int, m protoBuffer) {
+	b.bytes(field, m)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	if len(values) == 0 {
+		return
+	}
+	var p protoBuffer
+	for _, v := range values {
+		p.rawVarint(v)
+	}
+	b.bytes(field, p)
+}
+
+func (b *protoBuffer) names(field int, n *Names) {
+	if n == nil {
+		return
+	}
+	var nb protoBuffer
+	nb.string(protoNamesEs, n.Es)
+	nb.string(protoNamesEu, n.Eu)
+	b.message(field, nb)
+}
+
+// timetable writes t as a Timetable message, with the departures of
+// every type of day as minutes since the start of the service day.
+// Nothing is written if any departure is not valid.
+func (b *protoBuffer) timetable(field int, t Timetable) error {
+	if t == (Timetable{}) {
+		return nil
+	}
+	var tb protoBuffer
+	for i, f := range protoTimetableFields(&t) {
+		minutes, err := parseDepartures(*f)
+		if err != nil {
+			return err
+		}
+		departures := make([]uint64, len(minutes))
+		for j, m := range minutes {
+			departures[j] = uint64(m)
+		}
+		tb.packed(i+1, departures)
+	}
+	b.message(field, tb)
+	return nil
+}
@@ -0,0 +1,476 @@
+package transit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Decoder of the messages ProtoPresenter formats, so tests can compare
+// them with the lines and stops presented.
+
+const protoSchemaPath string = "../proto"
+const protoSchemaFile string = "transit.proto"
+
+// checkProtoSchema returns an error unless b is a valid message of type
+// name of proto/transit.proto, without fields unknown to the schema and
+// encoded as the Protocol Buffers library would encode it.
+func checkProtoSchema(name string, b []byte) error {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{protoSchemaPath}}),
+	}
+	files, err := compiler.Compile(context.Background(), protoSchemaFile)
+	if err != nil {
+		return err
+	}
+	descriptor, ok := files[0].FindDescriptorByName(protoreflect.FullName(name)).(protoreflect.MessageDescriptor)
+	if !ok {
+		return fmt.Errorf("Unknown message %v in %v", name, protoSchemaFile)
+	}
+
+	m := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(b, m); err != nil {
+		return err
+	}
+	if err := checkProtoKnownFields(m); err != nil {
+		return err
+	}
+	expected, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected, b) {
+		return fmt.Errorf("Message %v encoded as %v, expected %v", name, b, expected)
+	}
+	return nil
+}
+
+// checkProtoKnownFields returns an error if m or any message in it has
+// fields unknown to the schema.
+func checkProtoKnownFields(m protoreflect.Message) error {
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		return fmt.Errorf("Unknown fields %v in message %v", []byte(unknown), m.Descriptor().FullName())
+	}
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil {
+			return true
+		}
+		if fd.IsList() {
+			for i := 0; i < v.List().Len() && err == nil; i++ {
+				err = checkProtoKnownFields(v.List().Get(i).Message())
+			}
+		} else {
+			err = checkProtoKnownFields(v.Message())
+		}
+		return err == nil
+	})
+	return err
+}
+
+// protoField is a field of an encoded message: its value if varint, its
+// content if length delimited.
+type protoField struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+var errProtoTruncated = errors.New("Truncated protocol buffers message")
+
+// parseProto returns the fields of the encoded message b.
+func parseProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := protoReadVarint(b)
+		if n == 0 {
+			return nil, errProtoTruncated
+		}
+		b = b[n:]
+		f := protoField{number: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case protoWireVarint:
+			if f.varint, n = protoReadVarint(b); n == 0 {
+				return nil, errProtoTruncated
+			}
+			b = b[n:]
+		case protoWireBytes:
+			length, n := protoReadVarint(b)
+			if n == 0 || uint64(len(b)-n) < length {
+				return nil, errProtoTruncated
+			}
+			f.bytes = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return nil, fmt.Errorf("Unsupported wire type %v of field %v", f.wire, f.number)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// protoReadVarint returns the varint at the start of b and its length.
+// Length 0 if b does not start with a valid varint.
+func protoReadVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// protoVarints returns the values of a repeated varint field, packed or not.
+func protoVarints(f protoField) ([]uint64, error) {
+	if f.wire == protoWireVarint {
+		return []uint64{f.varint}, nil
+	}
+	var values []uint64
+	for b := f.bytes; len(b) > 0; {
+		v, n := protoReadVarint(b)
+		if n == 0 {
+			return nil, errProtoTruncated
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+func protoUnZigZag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// decodeProtoLines returns the lines and metadata of an encoded Transit
+// message, as formatted by ProtoPresenter. The message is checked
+// against proto/transit.proto first.
+func decodeProtoLines(b []byte) ([]Line, []MetadataItem, error) {
+	if err := checkProtoSchema("transit.Transit", b); err != nil {
+		return nil, nil, err
+	}
+	fields, err := parseProto(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	var metadata []MetadataItem
+	var stops []Stop
+	var lines []Line
+	for _, f := range fields {
+		switch f.number {
+		case protoTransitMetadata:
+			m, err := decodeProtoMetadata(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			metadata = append(metadata, m)
+		case protoTransitStops:
+			s, err := decodeProtoStop(f.bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			stops = append(stops, s)
+		case protoTransitLines:
+			l, err := decodeProtoLine(f.bytes, stops)
+			if err != nil {
+				return nil, nil, err
+			}
+			lines = append(lines, l)
+		}
+	}
+	return lines, metadata, nil
+}
+
+// decodeProtoStops returns the stops of an encoded Stops message, as
+// formatted by ProtoPresenter. The message is checked against
+// proto/transit.proto first.
+func decodeProtoStops(b []byte) ([]AggregatedStop, error) {
+	if err := checkProtoSchema("transit.Stops", b); err != nil {
+		return nil, err
+	}
+	fields, err := parseProto(b)
+	if err != nil {
+		return nil, err
+	}
+	var stops []AggregatedStop
+	for _, f := range fields {
+		if f.number != protoStopsStops {
+			continue
+		}
+		s, err := decodeProtoAggregatedStop(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		stops = append(stops, s)
+	}
+	return stops, nil
+}
+
+func decodeProtoMetadata(b []byte) (MetadataItem, error) {
+	var m MetadataItem
+	fields, err := parseProto(b)
+	if err != nil {
+		return m, err
+	}
+	values := []*string{&m.MinVersion, &m.MaxVersion, &m.PathData, &m.Validity, &m.UpdateClient, &m.LastUpdate}
+	for _, f := range fields {
+		if f.number >= 1 && f.number <= len(values) {
+			*values[f.number-1] = string(f.bytes)
+		}
+	}
+	return m, nil
+}
+
+func decodeProtoStop(b []byte) (Stop, error) {
+	var s AggregatedStop
+	err := decodeProtoStopFields(b, &s)
+	return Stop{Id: s.Id, Name: s.Name, Location: s.Location, StationId: s.StationId, Names: s.Names}, err
+}
+
+func decodeProtoAggregatedStop(b []byte) (AggregatedStop, error) {
+	var s AggregatedStop
+	err := decodeProtoStopFields(b, &s)
+	return s, err
+}
+
+// decodeProtoStopFields decodes a Stop or AggregatedStop message in s.
+func decodeProtoStopFields(b []byte, s *AggregatedStop) error {
+	fields, err := parseProto(b)
+	if err != nil {
+		return err
+	}
+	var lat, long int64
+	for _, f := range fields {
+		switch f.number {
+		case protoStopId:
+			s.Id = string(f.bytes)
+		case protoStopName:
+			s.Name = string(f.bytes)
+		case protoStopLat:
+			lat = protoUnZigZag(f.varint)
+		case protoStopLong:
+			long = protoUnZigZag(f.varint)
+		case protoStopStationId:
+			s.StationId = string(f.bytes)
+		case protoStopNames:
+			if s.Names, err = decodeProtoNames(f.bytes); err != nil {
+				return err
+			}
+		case protoStopLines:
+			service, err := decodeProtoService(f.bytes)
+			if err != nil {
+				return err
+			}
+			s.Services = append(s.Services, service)
+		}
+	}
+	s.Location = protoLocation(lat, long)
+	return nil
+}
+
+func decodeProtoService(b []byte) (StopService, error) {
+	var s StopService
+	fields, err := parseProto(b)
+	if err != nil {
+		return s, err
+	}
+	for _, f := range fields {
+		switch f.number {
+		case protoServiceLineId:
+			s.LineId = string(f.bytes)
+		case protoServiceAgencyId:
+			s.AgencyId = string(f.bytes)
+		case protoServiceNumber:
+			s.Number = int(int32(f.varint))
+		case protoServiceName:
+			s.Name = string(f.bytes)
+		case protoServiceDirection:
+			s.Direction = directionOfProto(f.varint)
+		case protoServiceSchedule:
+			if s.Schedule, err = decodeProtoTimetable(f.bytes); err != nil {
+				return s, err
+			}
+		case protoServiceNames:
+			if s.Names, err = decodeProtoNames(f.bytes); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+func decodeProtoLine(b []byte, stops []Stop) (Line, error) {
+	night := false
+	l := Line{IsNightLine: &night}
+	fields, err := parseProto(b)
+	if err != nil {
+		return l, err
+	}
+	for _, f := range fields {
+		switch f.number {
+		case protoLineId:
+			l.Id = string(f.bytes)
+		case protoLineAgencyId:
+			l.AgencyId = string(f.bytes)
+		case protoLineNumber:
+			l.Number = int(int32(f.varint))
+		case protoLineName:
+			l.Name = string(f.bytes)
+		case protoLineDirection:
+			l.Direction = directionOfProto(f.varint)
+		case protoLineStops:
+			s, err := decodeProtoLineStop(f.bytes, stops)
+			if err != nil {
+				return l, err
+			}
+			l.Stops = append(l.Stops, s)
+		case protoLineMapRoute:
+			values, err := protoVarints(f)
+			if err != nil {
+				return l, err
+			}
+			var lat, long int64
+			for i := 0; i+1 < len(values); i += 2 {
+				lat += protoUnZigZag(values[i])
+				long += protoUnZigZag(values[i+1])
+				l.MapRoute = append(l.MapRoute, protoLocation(lat, long))
+			}
+		case protoLineNight:
+			night = f.varint != 0
+		case protoLineNames:
+			if l.Names, err = decodeProtoNames(f.bytes); err != nil {
+				return l, err
+			}
+		}
+	}
+	return l, nil
+}
+
+func decodeProtoLineStop(b []byte, stops []Stop) (Stop, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return Stop{}, err
+	}
+	ref := 0
+	var schedule Timetable
+	var connections Connections
+	distance := 0
+	for _, f := range fields {
+		switch f.number {
+		case protoLineStopStop:
+			ref = int(f.varint)
+		case protoLineStopSchedule:
+			if schedule, err = decodeProtoTimetable(f.bytes); err != nil {
+				return Stop{}, err
+			}
+		case protoLineStopConnections:
+			c, err := decodeProtoConnection(f.bytes)
+			if err != nil {
+				return Stop{}, err
+			}
+			connections = append(connections, c)
+		case protoLineStopShapeDistance:
+			distance = int(f.varint)
+		}
+	}
+	if ref >= len(stops) {
+		return Stop{}, fmt.Errorf("Reference to unknown stop %v", ref)
+	}
+	s := stops[ref]
+	s.Schedule, s.Connections, s.ShapeDistance = schedule, connections, distance
+	return s, nil
+}
+
+func decodeProtoConnection(b []byte) (Connection, error) {
+	var c Connection
+	fields, err := parseProto(b)
+	if err != nil {
+		return c, err
+	}
+	for _, f := range fields {
+		switch f.number {
+		case protoConnectionLineId:
+			c.LineId = string(f.bytes)
+		case protoConnectionDirection:
+			c.Direction = directionOfProto(f.varint)
+		case protoConnectionAgency:
+			c.Agency = string(f.bytes)
+		}
+	}
+	return c, nil
+}
+
+func decodeProtoTimetable(b []byte) (Timetable, error) {
+	var t Timetable
+	fields, err := parseProto(b)
+	if err != nil {
+		return t, err
+	}
+	days := protoTimetableFields(&t)
+	for _, f := range fields {
+		if f.number < 1 || f.number > len(days) {
+			continue
+		}
+		values, err := protoVarints(f)
+		if err != nil {
+			return t, err
+		}
+		departures := make([]string, len(values))
+		for i, v := range values {
+			departures[i] = formatMinutes(int(v))
+		}
+		day := days[f.number-1]
+		if len(*day) > 0 && len(departures) > 0 {
+			*day += ","
+		}
+		*day += strings.Join(departures, ",")
+	}
+	return t, nil
+}
+
+func decodeProtoNames(b []byte) (*Names, error) {
+	fields, err := parseProto(b)
+	if err != nil {
+		return nil, err
+	}
+	n := &Names{}
+	for _, f := range fields {
+		switch f.number {
+		case protoNamesEs:
+			n.Es = string(f.bytes)
+		case protoNamesEu:
+			n.Eu = string(f.bytes)
+		}
+	}
+	return n, nil
+}
+
+func directionOfProto(v uint64) string {
+	switch v {
+	case protoDirectionForward:
+		return DirectionForward
+	case protoDirectionBackward:
+		return DirectionBackward
+	}
+	return ""
+}
+
+// protoLocation returns the coordinates of lat and long in millionths of
+// degree. Empty if both are zero (no location).
+func protoLocation(lat, long int64) Coordinates {
+	if lat == 0 && long == 0 {
+		return Coordinates{}
+	}
+	return Coordinates{strconv.FormatFloat(float64(lat)/protoCoordinatesPrecision, 'f', 6, 64),
+		strconv.FormatFloat(float64(long)/protoCoordinatesPrecision, 'f', 6, 64)}
+}
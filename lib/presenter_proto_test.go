@@ -0,0 +1,172 @@
+package transit
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// protoNormalized returns a copy of the lines as decoded from protocol
+// buffers: coordinates with 6 decimals and night flag always defined.
+func protoNormalized(lines []Line) []Line {
+	normalized := make([]Line, len(lines))
+	for i, l := range lines {
+		night := l.IsNightLine != nil && *l.IsNightLine
+		l.IsNightLine = &night
+		l.Stops = append([]Stop(nil), l.Stops...)
+		for j := range l.Stops {
+			l.Stops[j].Location = protoNormalizedCoordinates(l.Stops[j].Location)
+		}
+		route := make([]Coordinates, len(l.MapRoute))
+		for j, c := range l.MapRoute {
+			route[j] = protoNormalizedCoordinates(c)
+		}
+		if l.MapRoute != nil {
+			l.MapRoute = route
+		}
+		normalized[i] = l
+	}
+	return normalized
+}
+
+func protoNormalizedCoordinates(c Coordinates) Coordinates {
+	p, err := c.ToPoint()
+	if err != nil {
+		return Coordinates{}
+	}
+	return Coordinates{strconv.FormatFloat(p.Lat, 'f', 6, 64), strconv.FormatFloat(p.Long, 'f', 6, 64)}
+}
+
+func TestProtoPresenterRoundTrip(t *testing.T) {
+	log.Printf("---------- TestProtoPresenterRoundTrip ------------ ")
+	agency := &fakeAgency{Lines: fakeBilbobusLines}
+	agency.Start()
+	defer agency.Close()
+	td := digestFakeAgency(t, agency)
+	lines := append(td.lines, stopsViewTestLines()...)
+	metadata := []MetadataItem{{MinVersion: "1", MaxVersion: "2", PathData: "data", Validity: "2026-12-31", LastUpdate: "2026-10-19"}}
+
+	encoded, err := ProtoPresenter{Metadata: metadata}.FormatList(lines)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	decoded, decodedMetadata, err := decodeProtoLines([]byte(encoded))
+	if err != nil || len(decodedMetadata) != 1 || decodedMetadata[0] != metadata[0] {
+		t.Fatalf("Unexpected metadata %v (%v)", decodedMetadata, err)
+	}
+	expected, _ := JsonPresenter{}.FormatList(protoNormalized(lines))
+	actual, _ := JsonPresenter{}.FormatList(decoded)
+	if actual != expected {
+		t.Errorf("Expected lines %v, actual %v", expected, actual)
+	}
+	if len(encoded) >= len(expected) {
+		t.Errorf("Expected binary smaller than JSON, actual %v and %v bytes", len(encoded), len(expected))
+	}
+
+	stops := AggregateStops(lines)
+	encoded, err = ProtoPresenter{}.FormatStops(stops)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	decodedStops, err := decodeProtoStops([]byte(encoded))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for i := range stops {
+		stops[i].Location = protoNormalizedCoordinates(stops[i].Location)
+	}
+	expected, _ = JsonPresenter{}.FormatStops(stops)
+	actual, _ = JsonPresenter{}.FormatStops(decodedStops)
+	if actual != expected {
+		t.Errorf("Expected stops %v, actual %v", expected, actual)
+	}
+}
+
+func TestProtoPresenter(t *testing.T) {
+	log.Printf("---------- TestProtoPresenter ------------ ")
+	lines := stopsViewTestLines()
+	lines[1].Stops[0].Schedule = Timetable{Weekday: "23:50,00:10", Friday: "06:00"}
+	encoded, _ := ProtoPresenter{}.FormatList(lines)
+
+	// Stops of both directions are written once, stop 0002 of line I05
+	// apart as it has other names
+	backward := shapeTestLine("V01")
+	backward.Direction = DirectionBackward
+	for i := range backward.Stops {
+		backward.Stops[i].StationId = lines[1].Stops[i].StationId
+	}
+	both, _ := ProtoPresenter{}.FormatList(append(lines, backward))
+	fields, _ := parseProto([]byte(both))
+	stops := 0
+	for _, f := range fields {
+		if f.number == protoTransitStops {
+			stops++
+		}
+	}
+	if stops != 4 {
+		t.Errorf("Expected 4 stops, actual %v", stops)
+	}
+	decoded, _, err := decodeProtoLines([]byte(encoded))
+	if err != nil || len(decoded) != 2 || decoded[1].Stops[0].Schedule != lines[1].Stops[0].Schedule {
+		t.Fatalf("Unexpected lines %v (%v)", decoded, err)
+	}
+
+	// Departures are minutes in service order
+	var timetable protoBuffer
+	timetable.timetable(1, lines[1].Stops[0].Schedule)
+	message, _ := parseProto(timetable)
+	days, _ := parseProto(message[0].bytes)
+	minutes, _ := protoVarints(days[0])
+	if len(minutes) != 2 || minutes[0] != 23*60+50 || minutes[1] != 24*60+10 {
+		t.Errorf("Unexpected departures %v", minutes)
+	}
+
+	localized, _ := ProtoPresenter{Language: LanguageBasque}.FormatStops(AggregateStops(lines))
+	aggregated, err := decodeProtoStops([]byte(localized))
+	if err != nil || len(aggregated) != 3 || aggregated[1].Name != "Moyua plaza" || aggregated[1].Names != nil {
+		t.Errorf("Unexpected localized stops %v (%v)", aggregated, err)
+	}
+
+	// Invalid departures are not presented
+	lines[1].Stops[0].Schedule.Saturday = "06:00,6h30"
+	if s, err := (ProtoPresenter{}).FormatList(lines); err == nil {
+		t.Errorf("Expected error presenting invalid schedule, actual %v", []byte(s))
+	}
+	if s, err := (ProtoPresenter{}).FormatStops(AggregateStops(lines)); err == nil {
+		t.Errorf("Expected error presenting stops with invalid schedule, actual %v", []byte(s))
+	}
+
+	for _, invalid := range [][]byte{{0x1a, 0x05, 0x0a}, {0x08}, {0x0d, 0, 0, 0, 0}, {0x20, 0x01}, {0x1a, 0x02, 0x08, 0x00}} {
+		if _, _, err := decodeProtoLines(invalid); err == nil {
+			t.Errorf("Expected error decoding %v", invalid)
+		}
+	}
+}
+
+func TestProtoPresenterFromEnv(t *testing.T) {
+	log.Printf("---------- TestProtoPresenterFromEnv ------------ ")
+	os.Setenv(EnvPublishFormats, FormatProtobuf)
+	defer os.Unsetenv(EnvPublishFormats)
+	os.Setenv(EnvMetadata, `[{"MinVersion": "1", "MaxVersion": "2"}]`)
+	defer os.Unsetenv(EnvMetadata)
+
+	// Metadata as defined, without timestamp, so the same lines are
+	// always presented the same
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		presenters, err := PresentersFromEnv()
+		if err != nil || len(presenters) != 1 {
+			t.Fatalf("Unexpected presenters %v (%v)", presenters, err)
+		}
+		s, _ := presenters[0].FormatList(stopsViewTestLines())
+		outputs = append(outputs, s)
+	}
+	_, metadata, err := decodeProtoLines([]byte(outputs[0]))
+	if err != nil || len(metadata) != 1 || metadata[0] != (MetadataItem{MinVersion: "1", MaxVersion: "2"}) {
+		t.Errorf("Unexpected metadata %v (%v)", metadata, err)
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("Expected same output, actual %v and %v", MD5(outputs[0]), MD5(outputs[1]))
+	}
+}
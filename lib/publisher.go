@@ -1,6 +1,7 @@
 package transit
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 	"path"
@@ -15,20 +16,32 @@ import (
 const envDatabaseURI string = "DATABASE_URI"
 const envDatabaseCredentials string = "DATABASE_CREDENTIALS_PATH"
 const formmatedLinesOutputName string = "alllines.json"
+const geoJsonLinesOutputName string = "alllines.geojson"
+const kmlLinesOutputName string = "alllines.kml"
+const protoLinesOutputName string = "alllines.pb"
+const formmatedStopsOutputName string = "allstops.json"
+const geoJsonStopsOutputName string = "allstops.geojson"
+const kmlStopsOutputName string = "allstops.kml"
+const protoStopsOutputName string = "allstops.pb"
 const envDryRun string = "DRY_RUN"
 
-// Publish deploys lines in the correct format in path.
-// The format is determined by the presenter.
-func Publish(td TransitData, destPath string, p Presenter) error {
+// Publish deploys the lines and stops of the agency in the correct format
+// in path. The formats are determined by the presenters, one file each.
+func Publish(a Parser, destPath string, presenters ...Presenter) error {
 
-	if err := publishLocally(td.lines, destPath, p); err != nil {
+	if err := publishLocally(a.Lines(), destPath, presenters); err != nil {
 		log.Printf("Error publishing lines locally: %v", err)
 		return err
 	}
 
+	if err := publishStations(a.Data(), destPath); err != nil {
+		log.Printf("Error publishing stations locally: %v", err)
+		return err
+	}
+
 	if !GetEnvVariableValueBool(envDryRun) {
 		log.Printf("Publishing data to remote source")
-		if err := publishRemote(td); err != nil {
+		if err := publishRemote(a.Data()); err != nil {
 			log.Printf("Error publishing lines remotely: %v", err)
 			return err
 		}
@@ -39,28 +52,91 @@ func Publish(td TransitData, destPath string, p Presenter) error {
 	return nil
 }
 
-func publishLocally(lines []Line, destPath string, p Presenter) error {
-	log.Printf("Publishing %d lines locally", len(lines))
-	os.MkdirAll(destPath, os.ModePerm)
+// PublishAgencies deploys the lines of every agency in its own folder
+// of destPath (e.g. destPath/Bilbobus) and the lines of all of them,
+// with ids namespaced per agency, in destPath. The transfers between
+// stops of different agencies are deployed in destPath too.
+func PublishAgencies(agencies []Parser, destPath string, presenters ...Presenter) error {
+	for _, a := range agencies {
+		if err := publishLocally(a.Lines(), path.Join(destPath, a.Name()), presenters); err != nil {
+			log.Printf("Error publishing lines of agency %v locally: %v", a.Name(), err)
+			return err
+		}
+	}
 
-	json, err := p.FormatList(lines)
+	config, err := LoadTransferConfig()
 	if err != nil {
-		log.Printf("Error formatting list of lines. Error:%v", err)
+		log.Printf("Error loading transfers configuration: %v", err)
+		return err
+	}
+	if err := Publish(MergeAgencies(agencies), destPath, presenters...); err != nil {
 		return err
 	}
+	if err := publishTransfers(BuildTransfers(agencies, config), destPath); err != nil {
+		log.Printf("Error publishing transfers locally: %v", err)
+		return err
+	}
+	return nil
+}
 
-	log.Printf("Data hash: %v", MD5(json))
+// publishLocally writes the lines, and the stops with the lines serving
+// them, in destPath in the format of every presenter.
+func publishLocally(lines []Line, destPath string, presenters []Presenter) error {
+	stops := AggregateStops(lines)
+	log.Printf("Publishing %d lines and %d stops locally", len(lines), len(stops))
+	os.MkdirAll(destPath, os.ModePerm)
 
-	// Write formatted line as a file in destination
-	err = CreateFile(path.Join(destPath, formmatedLinesOutputName), json)
-	if err != nil {
-		log.Printf("Error creating file for lines. Error:%v", err)
-		return err
+	for _, p := range presenters {
+		formatted, err := p.FormatList(lines)
+		if err != nil {
+			log.Printf("Error formatting list of lines. Error:%v", err)
+			return err
+		}
+
+		log.Printf("Data hash of %v: %v", p.OutputName(), MD5(formatted))
+
+		// Write formatted line as a file in destination
+		err = CreateFile(path.Join(destPath, p.OutputName()), formatted)
+		if err != nil {
+			log.Printf("Error creating file for lines. Error:%v", err)
+			return err
+		}
+
+		formatted, err = p.FormatStops(stops)
+		if err != nil {
+			log.Printf("Error formatting list of stops. Error:%v", err)
+			return err
+		}
+		log.Printf("Data hash of %v: %v", p.StopsOutputName(), MD5(formatted))
+		if err := CreateFile(path.Join(destPath, p.StopsOutputName()), formatted); err != nil {
+			log.Printf("Error creating file for stops. Error:%v", err)
+			return err
+		}
 	}
 
 	return nil
 }
 
+// publishStations writes the stations in destPath as JSON, and the
+// stations and stops as GTFS stops.txt.
+func publishStations(td TransitData, destPath string) error {
+	log.Printf("Publishing %d stations locally", len(td.stations))
+	stations := td.stations
+	if stations == nil {
+		stations = make([]Station, 0)
+	}
+	b, err := json.Marshal(stations)
+	if err != nil {
+		log.Printf("Error formatting stations. Error:%v", err)
+		return err
+	}
+	if err := CreateFile(path.Join(destPath, stationsOutputName), string(b)); err != nil {
+		log.Printf("Error creating file for stations. Error:%v", err)
+		return err
+	}
+	return CreateFile(path.Join(destPath, stopsGTFSOutputName), FormatStopsGTFS(td.stops, td.stations))
+}
+
 // publishRemote reads the json documents generated in the given paths
 // and publishes them in remote storage for the clients to consume.
 func publishRemote(td TransitData) error {
@@ -0,0 +1,316 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Constants
+const envRemediationRules string = "REMEDIATION_RULES" // Path to JSON file
+const RemediationRenameLine string = "rename-line"
+const RemediationDropStop string = "drop-stop"
+const RemediationSetLocation string = "set-location"
+const RemediationAddConnection string = "add-connection"
+const RemediationSetNight string = "set-night"
+const RemediationApplied string = "applied"
+const RemediationStale string = "stale"
+const RemediationFailed string = "failed"
+
+// RemediationRule is a fix of the data published by the agency. Action
+// tells which fix and the rest of the fields what to fix:
+//
+//	rename-line:    Name of line Line (agency id, e.g. 01). Name is the forward one.
+//	drop-stop:      Stop removed from line Line (all the lines if empty).
+//	set-location:   Location of Stop in line Line (all the lines if empty).
+//	add-connection: Connection (line id) added to Stop in line Line (all the lines if empty).
+//	set-night:      Night flag of line Line. Timetables move to the types of day of the flag.
+//
+// Line can be the line id (e.g. I01) or the agency id (e.g. 01, both directions).
+// Agency is the agency (e.g. Bizkaibus) whose lines the rule fixes, as
+// ids are only unique within an agency. Bilbobus if empty.
+type RemediationRule struct {
+	Action     string
+	Agency     string       `json:",omitempty"`
+	Line       string       `json:",omitempty"`
+	Stop       string       `json:",omitempty"`
+	Name       string       `json:",omitempty"`
+	Location   *Coordinates `json:",omitempty"`
+	Connection string       `json:",omitempty"`
+	Night      *bool        `json:",omitempty"`
+}
+
+// RemediationResult tells what applying a rule did: applied, stale
+// (changed nothing) or failed (invalid rule), with the reason.
+type RemediationResult struct {
+	Rule   RemediationRule
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+func (r RemediationRule) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// LoadRemediationRules reads the remediation rules from the JSON file
+// (list of RemediationRule) in env variable REMEDIATION_RULES.
+// No rules if the variable is not defined.
+func LoadRemediationRules() ([]RemediationRule, error) {
+	p := os.Getenv(envRemediationRules)
+	if len(p) == 0 {
+		log.Printf("Env variable %v is empty. No remediation rules.", envRemediationRules)
+		return nil, nil
+	}
+
+	f, err := ioutil.ReadFile(p)
+	if err != nil {
+		log.Printf("Error reading remediation rules %v. Error: %v ", p, err)
+		return nil, err
+	}
+	var rules []RemediationRule
+	if err := json.Unmarshal(f, &rules); err != nil {
+		return nil, fmt.Errorf("Error parsing remediation rules %v: %v", p, err)
+	}
+	return rules, nil
+}
+
+// remediate applies the remediation rules configured (see
+// LoadRemediationRules) for the agency to its lines.
+func remediate(agency string, lines *[]Line) ([]RemediationResult, error) {
+	rules, err := LoadRemediationRules()
+	if err != nil {
+		return nil, err
+	}
+	return ApplyRemediations(lines, RemediationRulesOf(rules, agency))
+}
+
+// RemediationRulesOf returns the rules that fix the lines of the agency.
+func RemediationRulesOf(rules []RemediationRule, agency string) []RemediationRule {
+	var result []RemediationRule
+	for _, r := range rules {
+		if r.Agency == agency || (len(r.Agency) == 0 && agency == AgencyBilbobus) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// ApplyRemediations applies the rules, in order, to the lines. Returns
+// the result of every rule. Stale rules are the ones that changed
+// nothing, either because what they fix is not in the data anymore or
+// because it is already fixed. Returns an error if any rule is invalid
+// or fails.
+func ApplyRemediations(lines *[]Line, rules []RemediationRule) ([]RemediationResult, error) {
+	var errs DigestErrors
+	results := make([]RemediationResult, len(rules))
+	for i, r := range rules {
+		results[i].Rule = r
+		changed, err := applyRemediation(lines, r)
+		switch {
+		case err != nil:
+			log.Printf("%v: Error applying rule %v: %v", RemediationTag, r, err)
+			errs = append(errs, fmt.Errorf("Remediation %v: %v", r, err))
+			results[i].Status, results[i].Error = RemediationFailed, err.Error()
+		case !changed:
+			log.Printf("%v: Stale rule %v matches nothing. Remove it", RemediationTag, r)
+			results[i].Status = RemediationStale
+		default:
+			log.Printf("%v: Applied rule %v", RemediationTag, r)
+			results[i].Status = RemediationApplied
+		}
+	}
+	return results, errs.ErrorOrNil()
+}
+
+// StaleRemediations returns the rules of the results that changed nothing.
+func StaleRemediations(results []RemediationResult) []RemediationRule {
+	var stale []RemediationRule
+	for _, r := range results {
+		if r.Status == RemediationStale {
+			stale = append(stale, r.Rule)
+		}
+	}
+	return stale
+}
+
+// applyRemediation applies rule r and tells whether it changed the lines.
+func applyRemediation(lines *[]Line, r RemediationRule) (bool, error) {
+	switch r.Action {
+	case RemediationRenameLine:
+		if len(r.Line) == 0 || len(r.Name) == 0 {
+			return false, fmt.Errorf("Line and Name required")
+		}
+		renamed := false
+		for _, l := range *lines {
+			if l.AgencyId == r.Line && l.Name != expectedLineName(l, r.Name) {
+				renamed = true
+			}
+		}
+		if !renamed {
+			return false, nil
+		}
+		return true, RemediateLineName(lines, r.Line, r.Name)
+
+	case RemediationDropStop:
+		if len(r.Stop) == 0 {
+			return false, fmt.Errorf("Stop required")
+		}
+		changed := false
+		for i, l := range *lines {
+			if !remediationMatchesLine(l, r.Line) {
+				continue
+			}
+			var stops []Stop
+			for _, s := range l.Stops {
+				if s.Id != r.Stop {
+					stops = append(stops, s)
+				}
+			}
+			if len(stops) != len(l.Stops) {
+				generated := isGeneratedMapRoute(l)
+				(*lines)[i].Stops = stops
+				if generated {
+					(*lines)[i].MapRoute = generateMapRoute((*lines)[i])
+				}
+				changed = true
+			}
+		}
+		return changed, nil
+
+	case RemediationSetLocation:
+		if len(r.Stop) == 0 || r.Location == nil {
+			return false, fmt.Errorf("Stop and Location required")
+		}
+		if _, err := r.Location.ToPoint(); err != nil {
+			return false, err
+		}
+		return updateRemediationStops(*lines, r, func(s *Stop) bool {
+			if s.Location == *r.Location {
+				return false
+			}
+			s.Location = *r.Location
+			return true
+		}), nil
+
+	case RemediationAddConnection:
+		if len(r.Stop) == 0 || len(r.Connection) == 0 {
+			return false, fmt.Errorf("Stop and Connection required")
+		}
+		return updateRemediationStops(*lines, r, func(s *Stop) bool {
+			if s.Connections.Contains(r.Connection) {
+				return false
+			}
+			s.Connections = s.Connections.Add(NewConnection(r.Connection))
+			return true
+		}), nil
+
+	case RemediationSetNight:
+		if len(r.Line) == 0 || r.Night == nil {
+			return false, fmt.Errorf("Line and Night required")
+		}
+		changed := false
+		for i, l := range *lines {
+			if remediationMatchesLine(l, r.Line) && (l.IsNightLine == nil || *l.IsNightLine != *r.Night) {
+				night := *r.Night
+				(*lines)[i].IsNightLine = &night
+				for j, s := range l.Stops {
+					(*lines)[i].Stops[j].Schedule = nightTimetable(s.Schedule, night)
+				}
+				changed = true
+			}
+		}
+		return changed, nil
+	}
+	return false, fmt.Errorf("Unknown action %v", r.Action)
+}
+
+// updateRemediationStops calls update with the stops of rule r and tells
+// whether any was changed. Map routes generated from the stops are
+// generated again with the stops updated.
+func updateRemediationStops(lines []Line, r RemediationRule, update func(s *Stop) bool) bool {
+	changed := false
+	for i, l := range lines {
+		if !remediationMatchesLine(l, r.Line) {
+			continue
+		}
+		generated := isGeneratedMapRoute(l)
+		for j, s := range l.Stops {
+			if s.Id == r.Stop && update(&lines[i].Stops[j]) {
+				changed = true
+				if generated {
+					lines[i].MapRoute = generateMapRoute(lines[i])
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// isGeneratedMapRoute tells whether the map route of l is the one
+// generated from the location of its stops (not a shape).
+func isGeneratedMapRoute(l Line) bool {
+	route := generateMapRoute(l)
+	if len(route) != len(l.MapRoute) {
+		return false
+	}
+	for i, c := range route {
+		if l.MapRoute[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// nightTimetable returns t with the departures in the types of day of
+// night lines if night (see timetableField), of day lines otherwise.
+// Departures that end up in the same type of day are merged in order.
+func nightTimetable(t Timetable, night bool) Timetable {
+	var result Timetable
+	fields := map[string]*string{DayWeekday: &result.Weekday, DayMondayToThursday: &result.MondayToThrusday,
+		DayFriday: &result.Friday, DaySaturday: &result.Saturday, DaySunday: &result.Sunday,
+		DayFridayNight: &result.FridayNight, DaySaturdayNight: &result.SaturdayNight, DayHolidayEve: &result.HolidayEve}
+	for _, d := range timetableDays(t) {
+		day := d.name
+		switch {
+		case night && (day == DayWeekday || day == DayFriday):
+			day = DayFridayNight
+		case night && day == DaySaturday:
+			day = DaySaturdayNight
+		case night && day == DaySunday:
+			day = DayHolidayEve
+		case !night && day == DayFridayNight && len(t.MondayToThrusday) > 0:
+			day = DayFriday
+		case !night && day == DayFridayNight:
+			day = DayWeekday
+		case !night && day == DaySaturdayNight:
+			day = DaySaturday
+		case !night && day == DayHolidayEve:
+			day = DaySunday
+		}
+		if f := fields[day]; len(*f) == 0 {
+			*f = d.times
+		} else {
+			*f = canonicalDepartures(*f + "," + d.times)
+		}
+	}
+	return result
+}
+
+// remediationMatchesLine tells whether l is the line (id or agency id)
+// of a rule. Empty id matches all the lines.
+func remediationMatchesLine(l Line, id string) bool {
+	return len(id) == 0 || l.Id == id || l.AgencyId == id
+}
+
+// expectedLineName returns the name of l after renaming its line with
+// the forward name.
+func expectedLineName(l Line, forwardName string) string {
+	if l.Direction == DirectionForward {
+		return forwardName
+	}
+	name, _ := ReverseLineName(forwardName)
+	return name
+}
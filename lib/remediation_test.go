@@ -0,0 +1,183 @@
+package transit
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+const remediationRulesTest = `[
+	{"Action": "rename-line", "Line": "01", "Name": "Moyua - Abando"},
+	{"Action": "drop-stop", "Line": "V01", "Stop": "0002"},
+	{"Action": "set-location", "Stop": "0003", "Location": {"La": "43.2611", "Lo": "-2.9312"}},
+	{"Action": "add-connection", "Line": "I01", "Stop": "0001", "Connection": "I03"},
+	{"Action": "set-night", "Line": "01", "Night": true},
+	{"Action": "drop-stop", "Stop": "0099"},
+	{"Action": "add-connection", "Line": "I01", "Stop": "0001", "Connection": "V01"}
+]`
+
+// remediationTestLines returns the lines I01 and V01 (agency id 01, the
+// line of the rules), with a shape as map route. Stop 0001 of I01
+// connects with V01.
+func remediationTestLines() []Line {
+	forward := shapeTestLine("I01")
+	forward.AgencyId = "01"
+	forward.MapRoute = []Coordinates{{"43.2630", "-2.9350"}, {"43.2610", "-2.9310"}}
+	backward := forward
+	backward.Id, backward.Direction = "V01", DirectionBackward
+	backward.Stops = append([]Stop(nil), forward.Stops...)
+	forward.Stops[0].Connections = ParseConnections("V01")
+	return []Line{forward, backward}
+}
+
+func TestApplyRemediations(t *testing.T) {
+	log.Printf("---------- TestApplyRemediations ------------ ")
+	p := "TestApplyRemediations.json"
+	ioutil.WriteFile(p, []byte(remediationRulesTest), 0644)
+	defer os.Remove(p)
+	os.Setenv(envRemediationRules, p)
+	defer os.Unsetenv(envRemediationRules)
+
+	rules, err := LoadRemediationRules()
+	if err != nil || len(rules) != 7 {
+		t.Fatalf("Unexpected rules %v (%v)", rules, err)
+	}
+	lines := remediationTestLines()
+	lines[0].Name, lines[1].Name = "Moyua - Abandoibarra", "Abandoibarra - Moyua"
+	results, err := ApplyRemediations(&lines, rules)
+	stale := StaleRemediations(results)
+	if err != nil || len(results) != len(rules) || results[0].Status != RemediationApplied {
+		t.Errorf("Unexpected results %v (%v)", results, err)
+	}
+
+	forward, backward := lines[0], lines[1]
+	if forward.Name != "Moyua - Abando" || backward.Name != "Abando - Moyua" {
+		t.Errorf("Unexpected names of lines %v, %v", forward.Name, backward.Name)
+	}
+	if len(forward.Stops) != 3 || len(backward.Stops) != 2 || backward.Stops[1].Id != "0003" {
+		t.Errorf("Unexpected stops %v, %v", forward.Stops, backward.Stops)
+	}
+	expected := Coordinates{"43.2611", "-2.9312"}
+	if forward.Stops[2].Location != expected || backward.Stops[1].Location != expected {
+		t.Errorf("Unexpected location of stop 0003 %v, %v", forward.Stops[2].Location, backward.Stops[1].Location)
+	}
+	if forward.Stops[0].Connections.String() != "V01 I03" || len(backward.Stops[0].Connections) != 0 {
+		t.Errorf("Unexpected connections of stop 0001 %v, %v", forward.Stops[0].Connections, backward.Stops[0].Connections)
+	}
+	if !*forward.IsNightLine || !*backward.IsNightLine {
+		t.Errorf("Expected night lines")
+	}
+
+	// Not found and already fixed
+	if len(stale) != 2 || stale[0].Stop != "0099" || stale[1].Connection != "V01" {
+		t.Errorf("Unexpected stale rules %v", stale)
+	}
+	if results, _ := ApplyRemediations(&lines, rules); len(StaleRemediations(results)) != len(rules) {
+		t.Errorf("Expected all rules stale once applied, actual %v", stale)
+	}
+}
+
+func TestApplyRemediationsInvalid(t *testing.T) {
+	log.Printf("---------- TestApplyRemediationsInvalid ------------ ")
+	rules := []RemediationRule{
+		{Action: "delete-line", Line: "I01"},
+		{Action: RemediationSetLocation, Stop: "0001", Location: &Coordinates{"x", "-2.9"}},
+		{Action: RemediationSetNight, Line: "I01"},
+		{Action: RemediationDropStop, Line: "I01", Stop: "0001"},
+	}
+	lines := remediationTestLines()
+	results, err := ApplyRemediations(&lines, rules)
+	if err == nil || !strings.HasPrefix(err.Error(), "3 errors") {
+		t.Errorf("Expected 3 errors, actual %v", err)
+	}
+	if results[0].Status != RemediationFailed || results[0].Error != "Unknown action delete-line" || results[3].Status != RemediationApplied {
+		t.Errorf("Unexpected results %v", results)
+	}
+	if len(lines[0].Stops) != 2 {
+		t.Errorf("Valid rules shall be applied, actual stops %v", lines[0].Stops)
+	}
+}
+
+func TestRemediationRulesOf(t *testing.T) {
+	log.Printf("---------- TestRemediationRulesOf ------------ ")
+	rules := []RemediationRule{
+		{Action: RemediationDropStop, Stop: "0001"},
+		{Action: RemediationDropStop, Agency: AgencyBilbobus, Stop: "0002"},
+		{Action: RemediationDropStop, Agency: AgencyBizkaibus, Stop: "0003"},
+	}
+	testCases := []struct {
+		agency   string
+		expected []string // stops
+	}{
+		{AgencyBilbobus, []string{"0001", "0002"}},
+		{AgencyBizkaibus, []string{"0003"}},
+		{AgencyEuskotren, nil},
+	}
+	for _, tc := range testCases {
+		var actual []string
+		for _, r := range RemediationRulesOf(rules, tc.agency) {
+			actual = append(actual, r.Stop)
+		}
+		if strings.Join(actual, " ") != strings.Join(tc.expected, " ") {
+			t.Errorf("%v: expected rules of stops %v, actual %v", tc.agency, tc.expected, actual)
+		}
+	}
+}
+
+func TestApplyRemediationsNamesAndMapRoute(t *testing.T) {
+	log.Printf("---------- TestApplyRemediationsNamesAndMapRoute ------------ ")
+	lines := remediationTestLines()
+	lines[0].Names = &Names{Es: "Moyua - Abandoibarra", Eu: "Moyua - Abandoibarra"}
+	lines[1].MapRoute = generateMapRoute(lines[1])
+	shape := append([]Coordinates(nil), lines[0].MapRoute...)
+	rules := []RemediationRule{
+		{Action: RemediationRenameLine, Line: "01", Name: "Moyua - Abando"},
+		{Action: RemediationSetLocation, Stop: "0003", Location: &Coordinates{"43.2611", "-2.9312"}},
+		{Action: RemediationDropStop, Line: "V01", Stop: "0002"},
+	}
+	if _, err := ApplyRemediations(&lines, rules); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if lines[0].Names != nil || lines[1].Names != nil {
+		t.Errorf("Expected no names per language once renamed, actual %v, %v", lines[0].Names, lines[1].Names)
+	}
+
+	// Shapes are kept, map routes generated from the stops follow them
+	if len(lines[0].MapRoute) != len(shape) || lines[0].MapRoute[1] != shape[1] {
+		t.Errorf("Expected shape %v kept, actual %v", shape, lines[0].MapRoute)
+	}
+	expected := []Coordinates{lines[1].Stops[0].Location, {"43.2611", "-2.9312"}}
+	if len(lines[1].MapRoute) != 2 || lines[1].MapRoute[0] != expected[0] || lines[1].MapRoute[1] != expected[1] {
+		t.Errorf("Expected map route %v, actual %v", expected, lines[1].MapRoute)
+	}
+}
+
+func TestApplyRemediationsNight(t *testing.T) {
+	log.Printf("---------- TestApplyRemediationsNight ------------ ")
+	day := Timetable{MondayToThrusday: "23:00", Friday: "23:30,00:30", Saturday: "01:00", Sunday: "02:00"}
+	lines := remediationTestLines()
+	lines[0].Stops[0].Schedule = day
+
+	night := true
+	if _, err := ApplyRemediations(&lines, []RemediationRule{{Action: RemediationSetNight, Line: "I01", Night: &night}}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := Timetable{MondayToThrusday: "23:00", FridayNight: "23:30,00:30", SaturdayNight: "01:00", HolidayEve: "02:00"}
+	if actual := lines[0].Stops[0].Schedule; actual != expected {
+		t.Errorf("Expected night timetable %v, actual %v", expected, actual)
+	}
+
+	night = false
+	ApplyRemediations(&lines, []RemediationRule{{Action: RemediationSetNight, Line: "I01", Night: &night}})
+	if actual := lines[0].Stops[0].Schedule; actual != day {
+		t.Errorf("Expected day timetable %v, actual %v", day, actual)
+	}
+
+	// Sundays and eves of holidays of night lines are merged
+	merged := nightTimetable(Timetable{FridayNight: "23:30", Sunday: "23:00", HolidayEve: "23:50,01:00"}, false)
+	if merged != (Timetable{Weekday: "23:30", Sunday: "23:00,23:50,01:00"}) {
+		t.Errorf("Unexpected day timetable %v", merged)
+	}
+}
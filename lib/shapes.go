@@ -0,0 +1,261 @@
+package transit
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const SourceShapes string = "Shapes"
+const shapeFormatKML string = ".kml"
+const shapeFormatGeoJSON string = ".geojson"
+const shapeFormatJSON string = ".json"
+const shapeFormatOSM string = ".osm"
+
+// ShapeProvider is a type of function that returns the geometry of
+// the route of line l, if it knows it.
+type ShapeProvider func(l Line) ([]Point, bool)
+
+// ShapesParser implements the signature of type Parse.
+// It's responsible for replacing the map route of the lines by the one
+// following the roads, read from the file in ts.Path (downloaded from
+// ts.Uri unless cached). The file can be KML or GeoJSON, with the line
+// id (e.g. I01) as name (KML) or Id property (GeoJSON) of the line
+// strings, or an OSM extract to match the stops of the lines against
+// the roads. Stops get their distance along the shape.
+func ShapesParser(ctx context.Context, l *[]Line, ts TransitSource) error {
+	if !UseCachedData() || !Exists(ts.Path) {
+		if err := Download(ts.Uri, ts.Path, ValidateFileNotEmpty); err != nil {
+			return err
+		}
+	}
+
+	provider, err := newShapeProvider(ts.Path)
+	if err != nil {
+		return err
+	}
+
+	shaped := 0
+	for i, line := range *l {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if points, found := provider(line); found {
+			applyShape(&(*l)[i], points)
+			shaped++
+		}
+	}
+	log.Printf("Shapes of %v found for %v of %v lines", ts.Path, shaped, len(*l))
+	return nil
+}
+
+// newShapeProvider returns the provider of the shapes in file p,
+// according to its format (extension).
+func newShapeProvider(p string) (ShapeProvider, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		log.Printf("Error opening file %v. Error: %v ", p, err)
+		return nil, err
+	}
+	defer f.Close()
+
+	var shapes map[string][]Point
+	switch strings.ToLower(path.Ext(p)) {
+	case shapeFormatKML:
+		shapes, err = readKMLShapes(f)
+	case shapeFormatGeoJSON, shapeFormatJSON:
+		shapes, err = readGeoJSONShapes(f)
+	case shapeFormatOSM:
+		g, err := readOSMGraph(f)
+		if err != nil {
+			return nil, err
+		}
+		return g.matchLine, nil
+	default:
+		return nil, fmt.Errorf("Unknown format of shapes file %v", p)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading shapes of %v: %v", p, err)
+	}
+	return func(l Line) ([]Point, bool) {
+		points, found := shapes[l.Id]
+		return points, found && len(points) > 1
+	}, nil
+}
+
+// applyShape sets the map route of l to the shape and the distance
+// along the shape of every stop.
+func applyShape(l *Line, shape []Point) {
+	route := make([]Coordinates, len(shape))
+	for i, p := range shape {
+		route[i] = p.ToCoordinates()
+	}
+	l.MapRoute = route
+
+	// Stops are projected in order, so a shape passing twice by the
+	// same place (e.g. circular lines) gets the right pass.
+	cumulative := make([]float64, len(shape))
+	for i := 1; i < len(shape); i++ {
+		cumulative[i] = cumulative[i-1] + Distance(shape[i-1], shape[i])
+	}
+	segment := 0
+	for i, s := range l.Stops {
+		p, err := s.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		best, bestOffset, bestDistance := segment, 0.0, math.MaxFloat64
+		for j := segment; j < len(shape)-1; j++ {
+			offset, d := projectOnSegment(p, shape[j], shape[j+1])
+			if d < bestDistance {
+				best, bestOffset, bestDistance = j, offset, d
+			}
+		}
+		segment = best
+		l.Stops[i].ShapeDistance = int(math.Round(cumulative[best] + bestOffset))
+	}
+}
+
+// projectOnSegment returns the distance from a to the projection of p on
+// segment a-b, and the distance from p to the segment, both in meters.
+// Uses an equirectangular projection, accurate for short segments.
+func projectOnSegment(p, a, b Point) (offset float64, distance float64) {
+	cosLat := math.Cos(a.Lat * math.Pi / 180)
+	toXY := func(q Point) (float64, float64) {
+		return (q.Long - a.Long) * cosLat * metersPerDegreeLat, (q.Lat - a.Lat) * metersPerDegreeLat
+	}
+	px, py := toXY(p)
+	bx, by := toXY(b)
+	length2 := bx*bx + by*by
+	t := 0.0
+	if length2 > 0 {
+		t = math.Max(0, math.Min(1, (px*bx+py*by)/length2))
+	}
+	dx, dy := px-t*bx, py-t*by
+	return t * math.Sqrt(length2), math.Sqrt(dx*dx + dy*dy)
+}
+
+// ToCoordinates formats the point as coordinates.
+func (p Point) ToCoordinates() Coordinates {
+	return Coordinates{strconv.FormatFloat(p.Lat, 'f', 6, 64), strconv.FormatFloat(p.Long, 'f', 6, 64)}
+}
+
+// kmlPlacemark is a KML feature with its line strings.
+type kmlPlacemark struct {
+	Name        string   `xml:"name"`
+	Coordinates []string `xml:"LineString>coordinates"`
+	Multi       []string `xml:"MultiGeometry>LineString>coordinates"`
+}
+
+// readKMLShapes reads the line strings of the placemarks of a KML
+// document, by placemark name. Placemarks can be nested in folders.
+func readKMLShapes(r io.Reader) (map[string][]Point, error) {
+	shapes := make(map[string][]Point)
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &start); err != nil {
+			return nil, err
+		}
+		for _, c := range append(pm.Coordinates, pm.Multi...) {
+			points, err := parseKMLCoordinates(c)
+			if err != nil {
+				return nil, fmt.Errorf("Placemark %v: %v", pm.Name, err)
+			}
+			id := strings.TrimSpace(pm.Name)
+			shapes[id] = append(shapes[id], points...)
+		}
+	}
+	return shapes, nil
+}
+
+// parseKMLCoordinates parses KML coordinates: tuples long,lat[,alt]
+// separated by spaces.
+func parseKMLCoordinates(c string) ([]Point, error) {
+	var points []Point
+	for _, tuple := range strings.Fields(c) {
+		values := strings.Split(tuple, ",")
+		if len(values) < 2 {
+			return nil, fmt.Errorf("Invalid coordinates %v", tuple)
+		}
+		p, err := Coordinates{values[1], values[0]}.ToPoint()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// geoJSONFeatureCollection is the part of GeoJSON used for shapes.
+type geoJSONFeatureCollection struct {
+	Features []struct {
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// readGeoJSONShapes reads the LineString and MultiLineString features
+// of a GeoJSON feature collection, by their Id property.
+func readGeoJSONShapes(r io.Reader) (map[string][]Point, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var fc geoJSONFeatureCollection
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, err
+	}
+
+	shapes := make(map[string][]Point)
+	for _, f := range fc.Features {
+		id := fmt.Sprint(f.Properties["Id"])
+		var lines [][][]float64
+		switch f.Geometry.Type {
+		case "LineString":
+			var line [][]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &line)
+			lines = append(lines, line)
+		case "MultiLineString":
+			err = json.Unmarshal(f.Geometry.Coordinates, &lines)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Feature %v: %v", id, err)
+		}
+		for _, line := range lines {
+			for _, position := range line {
+				if len(position) < 2 {
+					return nil, fmt.Errorf("Feature %v: invalid position %v", id, position)
+				}
+				shapes[id] = append(shapes[id], Point{position[1], position[0]})
+			}
+		}
+	}
+	return shapes, nil
+}
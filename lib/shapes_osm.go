@@ -0,0 +1,224 @@
+package transit
+
+import (
+	"container/heap"
+	"encoding/xml"
+	"io"
+	"log"
+	"strconv"
+)
+
+// Roads (OSM highway tag) buses can drive on.
+var osmBusRoads = map[string]bool{
+	"motorway": true, "trunk": true, "primary": true, "secondary": true, "tertiary": true,
+	"motorway_link": true, "trunk_link": true, "primary_link": true, "secondary_link": true, "tertiary_link": true,
+	"unclassified": true, "residential": true, "service": true, "living_street": true,
+	"road": true, "busway": true, "bus_guideway": true,
+}
+
+// OSMMaxSnapDistance is the maximum distance in meters from a stop to
+// the road it is matched to.
+var OSMMaxSnapDistance = 100.0
+
+// Routes between consecutive stops longer than this factor times the
+// straight line distance (plus osmMaxDetourMeters) are not searched.
+const osmMaxDetourFactor float64 = 10
+const osmMaxDetourMeters float64 = 2000
+
+type osmNode struct {
+	Id  int64   `xml:"id,attr"`
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type osmWay struct {
+	Nodes []struct {
+		Ref int64 `xml:"ref,attr"`
+	} `xml:"nd"`
+	Tags []struct {
+		K string `xml:"k,attr"`
+		V string `xml:"v,attr"`
+	} `xml:"tag"`
+}
+
+type osmEdge struct {
+	to     int64
+	length float64
+}
+
+// osmGraph is the graph of the roads of an OSM extract.
+type osmGraph struct {
+	nodes map[int64]Point
+	edges map[int64][]osmEdge
+	index *StopIndex // Nodes of the roads, with the node id as stop id
+}
+
+// readOSMGraph reads the roads of an OSM XML extract.
+func readOSMGraph(r io.Reader) (*osmGraph, error) {
+	g := &osmGraph{nodes: make(map[int64]Point), edges: make(map[int64][]osmEdge)}
+	var ways []osmWay
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "node":
+			var n osmNode
+			if err := dec.DecodeElement(&n, &start); err != nil {
+				return nil, err
+			}
+			g.nodes[n.Id] = Point{n.Lat, n.Lon}
+		case "way":
+			var w osmWay
+			if err := dec.DecodeElement(&w, &start); err != nil {
+				return nil, err
+			}
+			ways = append(ways, w)
+		}
+	}
+
+	inRoads := make(map[int64]bool)
+	for _, w := range ways {
+		tags := make(map[string]string)
+		for _, t := range w.Tags {
+			tags[t.K] = t.V
+		}
+		if !osmBusRoads[tags["highway"]] {
+			continue
+		}
+		forward, backward := true, true
+		switch tags["oneway"] {
+		case "yes", "true", "1":
+			backward = false
+		case "-1", "reverse":
+			forward = false
+		default:
+			if tags["junction"] == "roundabout" {
+				backward = false
+			}
+		}
+
+		for i := 1; i < len(w.Nodes); i++ {
+			a, b := w.Nodes[i-1].Ref, w.Nodes[i].Ref
+			pa, foundA := g.nodes[a]
+			pb, foundB := g.nodes[b]
+			if !foundA || !foundB {
+				continue // Node out of the extract
+			}
+			length := Distance(pa, pb)
+			if forward {
+				g.edges[a] = append(g.edges[a], osmEdge{b, length})
+			}
+			if backward {
+				g.edges[b] = append(g.edges[b], osmEdge{a, length})
+			}
+			inRoads[a], inRoads[b] = true, true
+		}
+	}
+
+	var roadNodes []Stop
+	for id := range inRoads {
+		roadNodes = append(roadNodes, Stop{Id: strconv.FormatInt(id, 10), Location: g.nodes[id].ToCoordinates()})
+	}
+	g.index = NewStopIndex(roadNodes, OSMMaxSnapDistance)
+	log.Printf("Read %v road nodes from OSM extract", len(roadNodes))
+	return g, nil
+}
+
+// matchLine implements the signature of type ShapeProvider. Stops are
+// matched to the nearest road node and joined by the shortest route.
+// Not found if any stop is far from the roads or there is no route
+// between two consecutive stops.
+func (g *osmGraph) matchLine(l Line) ([]Point, bool) {
+	if len(l.Stops) < 2 {
+		return nil, false
+	}
+
+	var shape []Point
+	var previous int64
+	for i, s := range l.Stops {
+		p, err := s.Location.ToPoint()
+		if err != nil {
+			return nil, false
+		}
+		nearest := g.index.Nearest(p, 1)
+		if len(nearest) == 0 || nearest[0].Distance > OSMMaxSnapDistance {
+			log.Printf("Stop %v of line %v is not near any road", s.Id, l.Id)
+			return nil, false
+		}
+		node, _ := strconv.ParseInt(nearest[0].Stop.Id, 10, 64)
+		if i == 0 {
+			shape = append(shape, g.nodes[node])
+		} else if node != previous {
+			route, found := g.shortestRoute(previous, node)
+			if !found {
+				log.Printf("No route to stop %v of line %v", s.Id, l.Id)
+				return nil, false
+			}
+			for _, n := range route[1:] {
+				shape = append(shape, g.nodes[n])
+			}
+		}
+		previous = node
+	}
+	return shape, len(shape) > 1
+}
+
+// shortestRoute returns the nodes of the shortest route from a to b
+// (Dijkstra), both included.
+func (g *osmGraph) shortestRoute(a, b int64) ([]int64, bool) {
+	limit := Distance(g.nodes[a], g.nodes[b])*osmMaxDetourFactor + osmMaxDetourMeters
+	distances := map[int64]float64{a: 0}
+	previous := make(map[int64]int64)
+	queue := &osmQueue{{a, 0}}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(osmQueueItem)
+		if current.node == b {
+			route := []int64{b}
+			for n := b; n != a; {
+				n = previous[n]
+				route = append([]int64{n}, route...)
+			}
+			return route, true
+		}
+		if current.distance > distances[current.node] || current.distance > limit {
+			continue // Stale item or too far
+		}
+		for _, e := range g.edges[current.node] {
+			d := current.distance + e.length
+			if known, found := distances[e.to]; !found || d < known {
+				distances[e.to] = d
+				previous[e.to] = current.node
+				heap.Push(queue, osmQueueItem{e.to, d})
+			}
+		}
+	}
+	return nil, false
+}
+
+// osmQueue is a priority queue of nodes by distance (container/heap).
+type osmQueueItem struct {
+	node     int64
+	distance float64
+}
+
+type osmQueue []osmQueueItem
+
+func (q osmQueue) Len() int            { return len(q) }
+func (q osmQueue) Less(i, j int) bool  { return q[i].distance < q[j].distance }
+func (q osmQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *osmQueue) Push(x interface{}) { *q = append(*q, x.(osmQueueItem)) }
+func (q *osmQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
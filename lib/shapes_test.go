@@ -0,0 +1,141 @@
+package transit
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Stops on a grid of roads around Moyua: 0.001 degrees are ~111 m
+// north-south and ~81 m east-west.
+func shapeTestLine(id string) Line {
+	isNightly := false
+	return Line{id, "01", 1, "Moyua - Abando", DirectionForward, []Stop{
+		{Id: "0001", Location: Coordinates{"43.2630", "-2.9350"}},
+		{Id: "0002", Location: Coordinates{"43.2610", "-2.9330"}},
+		{Id: "0003", Location: Coordinates{"43.2610", "-2.9310"}},
+	}, nil, &isNightly, nil}
+}
+
+const shapeTestKML = `<?xml version="1.0" encoding="UTF-8"?>
+<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Folder>
+<Placemark><name>I01</name><LineString><coordinates>
+-2.9350,43.2630,0 -2.9350,43.2610,0 -2.9330,43.2610,0 -2.9310,43.2610,0
+</coordinates></LineString></Placemark>
+<Placemark><name>Stop</name><Point><coordinates>-2.9350,43.2630,0</coordinates></Point></Placemark>
+</Folder></Document></kml>`
+
+const shapeTestGeoJSON = `{"type": "FeatureCollection", "features": [
+{"type": "Feature", "properties": {"Id": "I01"}, "geometry": {"type": "MultiLineString",
+ "coordinates": [[[-2.9350, 43.2630], [-2.9350, 43.2610]], [[-2.9330, 43.2610], [-2.9310, 43.2610]]]}},
+{"type": "Feature", "properties": {"Id": "stop"}, "geometry": {"type": "Point", "coordinates": [-2.9350, 43.2630]}}
+]}`
+
+// Roads: the avenue (west to east) along 43.2610 is one way, so
+// westbound routes take the street along 43.2620.
+const shapeTestOSM = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+<node id="1" lat="43.2630" lon="-2.9350"/>
+<node id="2" lat="43.2620" lon="-2.9350"/>
+<node id="3" lat="43.2610" lon="-2.9350"/>
+<node id="4" lat="43.2610" lon="-2.9330"/>
+<node id="5" lat="43.2610" lon="-2.9310"/>
+<node id="6" lat="43.2625" lon="-2.9310"/>
+<node id="7" lat="43.2640" lon="-2.9400"/>
+<way id="10"><nd ref="1"/><nd ref="2"/><nd ref="3"/><tag k="highway" v="residential"/></way>
+<way id="11"><nd ref="3"/><nd ref="4"/><nd ref="5"/><tag k="highway" v="primary"/><tag k="oneway" v="yes"/></way>
+<way id="12"><nd ref="5"/><nd ref="6"/><nd ref="2"/><tag k="highway" v="secondary"/></way>
+<way id="13"><nd ref="1"/><nd ref="7"/><tag k="highway" v="footway"/></way>
+</osm>`
+
+func TestApplyShape(t *testing.T) {
+	log.Printf("---------- TestApplyShape ------------ ")
+	l := shapeTestLine("I01")
+	applyShape(&l, []Point{{43.2630, -2.9350}, {43.2610, -2.9350}, {43.2610, -2.9310}})
+
+	if len(l.MapRoute) != 3 || l.MapRoute[1] != (Coordinates{"43.261000", "-2.935000"}) {
+		t.Errorf("Unexpected map route %v", l.MapRoute)
+	}
+	expected := []int{0, 385, 547} // 222 m south, then east
+	for i, s := range l.Stops {
+		if s.ShapeDistance < expected[i]-2 || s.ShapeDistance > expected[i]+2 {
+			t.Errorf("Stop %v: expected distance along shape %v, actual %v", s.Id, expected[i], s.ShapeDistance)
+		}
+	}
+}
+
+func TestShapesParser(t *testing.T) {
+	log.Printf("---------- TestShapesParser ------------ ")
+	os.Setenv(EnvNameReuseLocalData, "true")
+	defer os.Unsetenv(EnvNameReuseLocalData)
+
+	testCases := []struct {
+		file          string
+		content       string
+		expectedRoute int    // points in map route of line I01
+		expectedLast  string // longitude of the second to last point
+	}{
+		{"TestShapesParser.kml", shapeTestKML, 4, "-2.933000"},
+		{"TestShapesParser.geojson", shapeTestGeoJSON, 4, "-2.933000"},
+		{"TestShapesParser.osm", shapeTestOSM, 5, "-2.933000"},
+	}
+	for _, tc := range testCases {
+		ioutil.WriteFile(tc.file, []byte(tc.content), 0644)
+		lines := []Line{shapeTestLine("I01"), shapeTestLine("V01")}
+		lines[1].Stops = lines[1].Stops[:1]
+		err := ShapesParser(context.Background(), &lines, TransitSource{Path: tc.file, Id: SourceShapes})
+		os.Remove(tc.file)
+
+		route := lines[0].MapRoute
+		if err != nil || len(route) != tc.expectedRoute || route[len(route)-2].Long != tc.expectedLast {
+			t.Errorf("%v: unexpected map route %v (%v)", tc.file, route, err)
+		}
+		if s := lines[0].Stops[2]; s.ShapeDistance < 545 || s.ShapeDistance > 549 {
+			t.Errorf("%v: unexpected distance along shape of last stop %v", tc.file, s.ShapeDistance)
+		}
+		if lines[1].MapRoute != nil {
+			t.Errorf("%v: unexpected map route for line V01 %v", tc.file, lines[1].MapRoute)
+		}
+	}
+
+	if err := ShapesParser(context.Background(), &[]Line{}, TransitSource{Path: "shapes_test.go", Id: SourceShapes}); err == nil {
+		t.Errorf("Expected error with unknown format of shapes")
+	}
+}
+
+func TestOSMShortestRoute(t *testing.T) {
+	log.Printf("---------- TestOSMShortestRoute ------------ ")
+	p := "TestOSMShortestRoute.osm"
+	ioutil.WriteFile(p, []byte(shapeTestOSM), 0644)
+	defer os.Remove(p)
+	f, _ := os.Open(p)
+	defer f.Close()
+	g, err := readOSMGraph(f)
+	if err != nil {
+		t.Fatalf("Unexpected error reading graph: %v", err)
+	}
+
+	testCases := []struct {
+		from, to int64
+		expected []int64
+	}{
+		{1, 5, []int64{1, 2, 3, 4, 5}},
+		{5, 3, []int64{5, 6, 2, 3}}, // One way avenue
+		{1, 7, nil},                 // Footway
+	}
+	for _, tc := range testCases {
+		route, found := g.shortestRoute(tc.from, tc.to)
+		if found != (tc.expected != nil) || len(route) != len(tc.expected) {
+			t.Errorf("%v to %v: expected %v, actual %v", tc.from, tc.to, tc.expected, route)
+			continue
+		}
+		for i := range route {
+			if route[i] != tc.expected[i] {
+				t.Errorf("%v to %v: expected %v, actual %v", tc.from, tc.to, tc.expected, route)
+				break
+			}
+		}
+	}
+}
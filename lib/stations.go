@@ -0,0 +1,220 @@
+package transit
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Constants
+const envStationMaxDistance string = "STATION_MAX_DISTANCE"       // Meters
+const envStationNameSimilarity string = "STATION_NAME_SIMILARITY" // From 0 to 1
+const StationIdPrefix string = "S"
+const stationsOutputName string = "stations.json"
+const stopsGTFSOutputName string = "stops.txt"
+const gtfsStopsHeader string = "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station"
+const gtfsLocationTypeStop string = "0"
+const gtfsLocationTypeStation string = "1"
+
+var DefaultStationMaxDistance = 60.0
+var DefaultStationNameSimilarity = 0.8
+
+// BuildStations groups the stops of td into stations and sets the
+// station id of the stops (in td.stops and in the stops of the lines).
+// Distance and name similarity thresholds are read from environment.
+func BuildStations(td *TransitData) {
+	maxDistance := GetEnvVariableValueFloat(envStationMaxDistance, DefaultStationMaxDistance)
+	similarity := GetEnvVariableValueFloat(envStationNameSimilarity, DefaultStationNameSimilarity)
+	td.stations = ClusterStations(td.stops, maxDistance, similarity)
+
+	stationOf := make(map[string]string)
+	for _, st := range td.stations {
+		for _, id := range st.StopIds {
+			stationOf[id] = st.Id
+		}
+	}
+	for i, s := range td.stops {
+		td.stops[i].StationId = stationOf[s.Id]
+	}
+	for i, l := range td.lines {
+		for j, s := range l.Stops {
+			td.lines[i].Stops[j].StationId = stationOf[s.Id]
+		}
+	}
+	log.Printf("Grouped %v stops in %v stations", len(td.stops), len(td.stations))
+}
+
+// ClusterStations groups the stops at maxDistance meters or less whose
+// names are similar (see similarNames) in stations. Groups are transitive:
+// if A groups with B and B with C, the three are the same station. Only
+// groups of two or more stops make a station. The station takes the id
+// of the lowest stop id (prefixed with StationIdPrefix), the most common
+// name and the centroid of the stops. Stations are sorted by id.
+func ClusterStations(stops []Stop, maxDistance, similarity float64) []Station {
+	if maxDistance <= 0 {
+		return nil
+	}
+
+	index := NewStopIndex(stops, maxDistance)
+	parent := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		if p, found := parent[id]; found && p != id {
+			parent[id] = find(p)
+			return parent[id]
+		}
+		return id
+	}
+
+	byId := make(map[string]Stop)
+	for _, s := range stops {
+		p, err := s.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		byId[s.Id] = s
+		for _, near := range index.WithinRadius(p, maxDistance) {
+			if near.Stop.Id != s.Id && similarNames(s.Name, near.Stop.Name, similarity) {
+				a, b := find(s.Id), find(near.Stop.Id)
+				if a != b {
+					parent[a] = b
+				}
+			}
+		}
+	}
+
+	groups := make(map[string][]Stop)
+	for id, s := range byId {
+		root := find(id)
+		groups[root] = append(groups[root], s)
+	}
+
+	var stations []Station
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		stations = append(stations, newStation(group))
+	}
+	sort.Slice(stations, func(i, j int) bool { return stations[i].Id < stations[j].Id })
+	return stations
+}
+
+// newStation builds the station grouping stops.
+func newStation(stops []Stop) Station {
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+
+	var lat, long float64
+	names := make(map[string]int)
+	st := Station{Id: StationIdPrefix + stops[0].Id}
+	for _, s := range stops {
+		p, _ := s.Location.ToPoint()
+		lat += p.Lat
+		long += p.Long
+		names[s.Name]++
+		st.StopIds = append(st.StopIds, s.Id)
+	}
+
+	// Most common name. Shortest (then alphabetically first) on ties.
+	for name, count := range names {
+		best := names[st.Name]
+		if len(st.Name) == 0 || count > best || (count == best && (len(name) < len(st.Name) ||
+			(len(name) == len(st.Name) && name < st.Name))) {
+			st.Name = name
+		}
+	}
+
+	n := float64(len(stops))
+	st.Location = Coordinates{strconv.FormatFloat(lat/n, 'f', 6, 64), strconv.FormatFloat(long/n, 'f', 6, 64)}
+	return st
+}
+
+// similarNames tells whether a and b name the same place: ignoring case,
+// accents and punctuation, either the words of one are all in the other
+// (e.g. "Moyua" and "Moyua 2") or the edit distance is small enough for
+// the similarity (from 0, any name, to 1, same name) required.
+func similarNames(a, b string, similarity float64) bool {
+	ta, tb := nameTokens(a), nameTokens(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return false
+	}
+	if containsTokens(ta, tb) || containsTokens(tb, ta) {
+		return true
+	}
+
+	na, nb := []rune(strings.Join(ta, " ")), []rune(strings.Join(tb, " "))
+	longest := len(na)
+	if len(nb) > longest {
+		longest = len(nb)
+	}
+	return 1-float64(levenshtein(na, nb))/float64(longest) >= similarity
+}
+
+// containsTokens tells whether all the tokens of b are in a.
+func containsTokens(a, b []string) bool {
+	present := make(map[string]bool)
+	for _, t := range a {
+		present[t] = true
+	}
+	for _, t := range b {
+		if !present[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// FormatStopsGTFS returns the stations and stops (sorted by id) as a
+// GTFS stops.txt file. Stops grouped in a station have it as parent_station.
+func FormatStopsGTFS(stops []Stop, stations []Station) string {
+	stops = append([]Stop(nil), stops...)
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+
+	var b strings.Builder
+	b.WriteString(gtfsStopsHeader + "\n")
+	for _, st := range stations {
+		fmt.Fprintf(&b, "%v,%v,%v,%v,%v,\n", st.Id, gtfsField(st.Name), st.Location.Lat, st.Location.Long, gtfsLocationTypeStation)
+	}
+	for _, s := range stops {
+		fmt.Fprintf(&b, "%v,%v,%v,%v,%v,%v\n", s.Id, gtfsField(s.Name), s.Location.Lat, s.Location.Long, gtfsLocationTypeStop, s.StationId)
+	}
+	return b.String()
+}
+
+// gtfsField quotes v if it has commas or quotes, as csv requires.
+func gtfsField(v string) string {
+	if strings.ContainsAny(v, ",\"\n") {
+		return "\"" + strings.Replace(v, "\"", "\"\"", -1) + "\""
+	}
+	return v
+}
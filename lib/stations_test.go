@@ -0,0 +1,101 @@
+package transit
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+// Stops around Moyua: four of them are the same square.
+var stationTestStops = []Stop{
+	{Id: "0011", Name: "Moyua", Location: Coordinates{"43.26300", "-2.93500"}},
+	{Id: "0012", Name: "Moyua 2", Location: Coordinates{"43.26310", "-2.93520"}},
+	{Id: "0013", Name: "Plaza Moyúa", Location: Coordinates{"43.26320", "-2.93480"}},
+	{Id: "0014", Name: "Moyua", Location: Coordinates{"43.26340", "-2.93500"}}, // Groups with 0013
+	{Id: "0015", Name: "Ercilla", Location: Coordinates{"43.26305", "-2.93505"}},
+	{Id: "0016", Name: "Moyua", Location: Coordinates{"43.27300", "-2.93500"}}, // 1 km away
+	{Id: "0017", Name: "Moyua"}, // Without location
+	{Id: "0021", Name: "Abando", Location: Coordinates{"43.26100", "-2.92700"}},
+	{Id: "0022", Name: "Abandoo", Location: Coordinates{"43.26110", "-2.92710"}},
+}
+
+func TestClusterStations(t *testing.T) {
+	log.Printf("---------- TestClusterStations ------------ ")
+	stations := ClusterStations(stationTestStops, 40, 0.8)
+	expected := []Station{
+		{"S0011", "Moyua", Coordinates{"43.263175", "-2.935000"}, []string{"0011", "0012", "0013", "0014"}},
+		{"S0021", "Abando", Coordinates{"43.261050", "-2.927050"}, []string{"0021", "0022"}},
+	}
+	if !reflect.DeepEqual(stations, expected) {
+		t.Errorf("Expected stations %v, actual %v", expected, stations)
+	}
+
+	if stations := ClusterStations(stationTestStops, 40, 1); len(stations) != 1 {
+		t.Errorf("Expected only Moyua station requiring same names, actual %v", stations)
+	}
+	if stations := ClusterStations(stationTestStops, 0, 0.8); stations != nil {
+		t.Errorf("Expected no stations when disabled, actual %v", stations)
+	}
+}
+
+func TestSimilarNames(t *testing.T) {
+	log.Printf("---------- TestSimilarNames ------------ ")
+	testCases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"Moyua", "MOYUA", true},
+		{"Moyua", "Moyúa", true},
+		{"Moyua", "Plaza Moyua", true},
+		{"Sabino Arana, 12", "Sabino Arana 12", true},
+		{"Abando", "Abandoo", true},
+		{"Moyua", "Ercilla", false},
+		{"Indautxu", "Indautxu 2", true},
+		{"Zabalburu", "Zabala", false},
+		{"", "Moyua", false},
+	}
+	for _, tc := range testCases {
+		if actual := similarNames(tc.a, tc.b, 0.8); actual != tc.expected {
+			t.Errorf("similarNames(%q, %q): expected %v, actual %v", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
+func TestBuildStations(t *testing.T) {
+	log.Printf("---------- TestBuildStations ------------ ")
+	isNightly := false
+	td := TransitData{lines: []Line{{"I01", "01", 1, "Moyua - Abando", DirectionForward, stationTestStops, nil, &isNightly, nil}}}
+	td.stops, _ = extractStops("", td.lines)
+	BuildStations(&td)
+
+	if len(td.Stations()) != 2 {
+		t.Fatalf("Expected 2 stations, actual %v", td.Stations())
+	}
+	if s, _ := td.Stop("0013"); s.StationId != "S0011" {
+		t.Errorf("Expected station S0011 for stop 0013, actual %v", s.StationId)
+	}
+	if s, _ := td.Stop("0015"); s.StationId != "" {
+		t.Errorf("Expected no station for stop 0015, actual %v", s.StationId)
+	}
+	if s := td.lines[0].Stops[7]; s.StationId != "S0021" {
+		t.Errorf("Expected station S0021 in stop of line, actual %v", s)
+	}
+}
+
+func TestFormatStopsGTFS(t *testing.T) {
+	log.Printf("---------- TestFormatStopsGTFS ------------ ")
+	stops := []Stop{
+		{Id: "0012", Name: "Moyua 2", Location: Coordinates{"43.2631", "-2.9352"}, StationId: "S0011"},
+		{Id: "0011", Name: "Moyua", Location: Coordinates{"43.2630", "-2.9350"}, StationId: "S0011"},
+		{Id: "0015", Name: "Ercilla, 1", Location: Coordinates{"43.2630", "-2.9350"}},
+	}
+	stations := []Station{{"S0011", "Moyua", Coordinates{"43.26305", "-2.9351"}, []string{"0011", "0012"}}}
+	expected := "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" +
+		"S0011,Moyua,43.26305,-2.9351,1,\n" +
+		"0011,Moyua,43.2630,-2.9350,0,S0011\n" +
+		"0012,Moyua 2,43.2631,-2.9352,0,S0011\n" +
+		"0015,\"Ercilla, 1\",43.2630,-2.9350,0,\n"
+	if actual := FormatStopsGTFS(stops, stations); actual != expected {
+		t.Errorf("Expected %q, actual %q", expected, actual)
+	}
+}
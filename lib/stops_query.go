@@ -0,0 +1,93 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// Constants
+const DefaultNearestStops int = 5
+const DefaultQueryRadius float64 = 300 // Meters
+const queryNearest string = "nearest"
+const queryWithin string = "within"
+
+// StopQueryHandler serves geo queries over the stops of the index as JSON
+// lists of stops and distances (meters), e.g.:
+//
+//	/nearest?lat=43.2630&long=-2.9350&k=5
+//	/within?lat=43.2630&long=-2.9350&radius=300
+type StopQueryHandler struct {
+	Index *StopIndex
+}
+
+// ReadPublishedStops reads the lines published in linesFile (e.g.
+// gen/alllines.json) and returns their stops.
+func ReadPublishedStops(linesFile string) ([]Stop, error) {
+	f, err := ioutil.ReadFile(linesFile)
+	if err != nil {
+		log.Printf("Error reading file %v. Error: %v ", linesFile, err)
+		return nil, err
+	}
+
+	var lines []Line
+	if err := json.Unmarshal(f, &lines); err != nil {
+		return nil, fmt.Errorf("Error parsing lines of %v: %v", linesFile, err)
+	}
+	return extractStops("", lines)
+}
+
+// QueryStops runs the query (nearest or within) around p. param is the
+// number of stops for nearest and the radius in meters for within.
+func QueryStops(index *StopIndex, query string, p Point, param float64) ([]StopDistance, error) {
+	switch query {
+	case queryNearest:
+		return index.Nearest(p, int(param)), nil
+	case queryWithin:
+		return index.WithinRadius(p, param), nil
+	default:
+		return nil, fmt.Errorf("Unknown stop query %v", query)
+	}
+}
+
+func (h StopQueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := Coordinates{q.Get("lat"), q.Get("long")}.ToPoint()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := path.Base(r.URL.Path)
+	if len(q.Get("k")) > 0 && len(q.Get("radius")) > 0 {
+		http.Error(w, "Either k or radius expected, not both", http.StatusBadRequest)
+		return
+	}
+	param := float64(DefaultNearestStops)
+	if query == queryWithin {
+		param = DefaultQueryRadius
+	}
+	for _, name := range []string{"k", "radius"} {
+		if v := q.Get(name); len(v) > 0 {
+			if param, err = strconv.ParseFloat(v, 64); err != nil || param < 0 {
+				http.Error(w, "Invalid "+name+" "+v, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	result, err := QueryStops(h.Index, query, p, param)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if result == nil {
+		result = make([]StopDistance, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
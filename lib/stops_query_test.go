@@ -0,0 +1,86 @@
+package transit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReadPublishedStops(t *testing.T) {
+	log.Printf("---------- TestReadPublishedStops ------------ ")
+	p := "TestReadPublishedStops.json"
+	defer os.Remove(p)
+	isNightly := false
+	lines := []Line{
+		{"I01", "01", 1, "Plaza Biribila - Arangoiti", DirectionForward, []Stop{{Id: "0001"}, {Id: "0002"}}, nil, &isNightly, nil},
+		{"V01", "01", 1, "Arangoiti - Plaza Biribila", DirectionBackward, []Stop{{Id: "0002"}}, nil, &isNightly, nil},
+	}
+	content, _ := JsonPresenter{}.FormatList(lines)
+	ioutil.WriteFile(p, []byte(content), 0644)
+
+	stops, err := ReadPublishedStops(p)
+	if err != nil || len(stops) != 2 {
+		t.Errorf("Expected 2 stops, actual %v (%v)", stops, err)
+	}
+	if _, err := ReadPublishedStops("unknown.json"); err == nil {
+		t.Errorf("Expected error reading unknown file")
+	}
+}
+
+func TestStopQueryHandler(t *testing.T) {
+	log.Printf("---------- TestStopQueryHandler ------------ ")
+	stops := []Stop{
+		{Id: "0011", Name: "Moyua", Location: Coordinates{"43.2630", "-2.9350"}},
+		{Id: "0012", Name: "Abando", Location: Coordinates{"43.2610", "-2.9270"}},
+		{Id: "0013", Name: "Moyua 2", Location: Coordinates{"43.2640", "-2.9350"}},
+	}
+	server := httptest.NewServer(StopQueryHandler{NewStopIndex(stops, DefaultIndexCellSize)})
+	defer server.Close()
+
+	testCases := []struct {
+		query          string
+		expectedStatus int
+		expectedIds    []string
+	}{
+		{"/nearest?lat=43.2630&long=-2.9350&k=2", http.StatusOK, []string{"0011", "0013"}},
+		{"/nearest?lat=43.2610&long=-2.9270", http.StatusOK, []string{"0012", "0011", "0013"}},
+		{"/within?lat=43.2630&long=-2.9350&radius=150", http.StatusOK, []string{"0011", "0013"}},
+		{"/within?lat=43.2630&long=-2.9350", http.StatusOK, []string{"0011", "0013"}},
+		{"/within?lat=43.3630&long=-2.9350", http.StatusOK, []string{}},
+		{"/nearest?lat=north&long=-2.9350", http.StatusBadRequest, nil},
+		{"/nearest?lat=NaN&long=-2.9350", http.StatusBadRequest, nil},
+		{"/nearest?lat=-89.9&long=170", http.StatusOK, []string{"0012", "0011", "0013"}},
+		{"/nearest?lat=43.2630&long=-2.9350&k=-1", http.StatusBadRequest, nil},
+		{"/nearest?lat=43.2630&long=-2.9350&k=2&radius=150", http.StatusBadRequest, nil},
+		{"/within?lat=43.2630&long=-2.9350&radius=150&k=2", http.StatusBadRequest, nil},
+		{"/farthest?lat=43.2630&long=-2.9350", http.StatusNotFound, nil},
+	}
+	for _, tc := range testCases {
+		resp, err := http.Get(server.URL + tc.query)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", tc.query, err)
+		}
+		if resp.StatusCode != tc.expectedStatus {
+			t.Errorf("%v: expected status %v, actual %v", tc.query, tc.expectedStatus, resp.StatusCode)
+		}
+		if tc.expectedIds != nil {
+			var result []StopDistance
+			json.NewDecoder(resp.Body).Decode(&result)
+			if len(result) != len(tc.expectedIds) {
+				t.Errorf("%v: expected stops %v, actual %v", tc.query, tc.expectedIds, result)
+			} else {
+				for i, id := range tc.expectedIds {
+					if result[i].Stop.Id != id {
+						t.Errorf("%v: expected stops %v, actual %v", tc.query, tc.expectedIds, result)
+						break
+					}
+				}
+			}
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package transit
+
+import (
+	"sort"
+)
+
+// StopService is a line (and direction) serving a stop, with the
+// timetable of the line at that stop.
+type StopService struct {
+	LineId    string    `json:"Id"`
+	AgencyId  string    `json:"AgencyId,omitempty"`
+	Number    int       `json:"Number,omitempty"`
+	Name      string    `json:"Name,omitempty"`
+	Direction string    `json:"Dir,omitempty"`
+	Schedule  Timetable `json:"Sc,omitempty"`
+	Names     *Names    `json:"Nm,omitempty"`
+}
+
+// AggregatedStop is a stop with every line serving it, its location and
+// the station it belongs to.
+type AggregatedStop struct {
+	Id        string        `json:"Id"`
+	Name      string        `json:"Na,omitempty"`
+	Location  Coordinates   `json:"Lc,omitempty"`
+	StationId string        `json:"St,omitempty"`
+	Names     *Names        `json:"Nm,omitempty"`
+	Services  []StopService `json:"Lines,omitempty"`
+}
+
+// AggregateStops returns the stops of the lines, once each and sorted by
+// id, with the lines serving them sorted by line id. The stop data is
+// the one of the first line visiting it. A line visiting a stop twice
+// (e.g. circular lines) is listed once, with its first visit.
+func AggregateStops(lines []Line) []AggregatedStop {
+	byId := make(map[string]*AggregatedStop)
+	for _, l := range lines {
+		for _, s := range l.Stops {
+			as, found := byId[s.Id]
+			if !found {
+				as = &AggregatedStop{Id: s.Id, Name: s.Name, Location: s.Location, StationId: s.StationId, Names: s.Names}
+				byId[s.Id] = as
+			}
+			if as.serves(l.Id) {
+				continue
+			}
+			as.Services = append(as.Services, StopService{l.Id, l.AgencyId, l.Number, l.Name, l.Direction, s.Schedule, l.Names})
+		}
+	}
+
+	stops := make([]AggregatedStop, 0, len(byId))
+	for _, as := range byId {
+		sort.Slice(as.Services, func(i, j int) bool { return as.Services[i].LineId < as.Services[j].LineId })
+		stops = append(stops, *as)
+	}
+	sort.Slice(stops, func(i, j int) bool { return stops[i].Id < stops[j].Id })
+	return stops
+}
+
+// serves tells whether line lineId is one of the services of the stop.
+func (s AggregatedStop) serves(lineId string) bool {
+	for _, service := range s.Services {
+		if service.LineId == lineId {
+			return true
+		}
+	}
+	return false
+}
+
+// LineIds returns the ids of the lines serving the stop.
+func (s AggregatedStop) LineIds() []string {
+	ids := make([]string, len(s.Services))
+	for i, service := range s.Services {
+		ids[i] = service.LineId
+	}
+	return ids
+}
+
+// localizeStops returns a copy of the stops with the names of stops and
+// lines in language (canonical ones when not available in that
+// language). stops if language is empty.
+func localizeStops(stops []AggregatedStop, language string) []AggregatedStop {
+	if len(language) == 0 {
+		return stops
+	}
+
+	localized := make([]AggregatedStop, len(stops))
+	for i, s := range stops {
+		if name := s.Names.In(language); len(name) > 0 {
+			s.Name = name
+		}
+		s.Names = nil
+		services := make([]StopService, len(s.Services))
+		for j, service := range s.Services {
+			if name := service.Names.In(language); len(name) > 0 {
+				service.Name = name
+			}
+			service.Names = nil
+			services[j] = service
+		}
+		if s.Services != nil {
+			s.Services = services
+		}
+		localized[i] = s
+	}
+	return localized
+}
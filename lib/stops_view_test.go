@@ -0,0 +1,110 @@
+package transit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// stopsViewTestLines returns line I01 and line I05, which also serves
+// stop 0002 (station S0002) at other times.
+func stopsViewTestLines() []Line {
+	forward := shapeTestLine("I01")
+	for i := range forward.Stops {
+		forward.Stops[i].Schedule = Timetable{Weekday: "06:3" + strconv.Itoa(i)}
+	}
+	other := shapeTestLine("I05")
+	other.AgencyId, other.Number, other.Name = "05", 5, "Txurdinaga - Zorrotza"
+	other.Names = &Names{Eu: "Txurdinaga - Zorrotzaurre"}
+	other.Stops = []Stop{other.Stops[1]}
+	other.Stops[0].Schedule = Timetable{Saturday: "08:00"}
+	other.Stops[0].Names = &Names{Eu: "Moyua plaza"}
+	forward.Stops[1].StationId, other.Stops[0].StationId = "S0002", "S0002"
+	return []Line{other, forward}
+}
+
+func TestAggregateStops(t *testing.T) {
+	log.Printf("---------- TestAggregateStops ------------ ")
+	stops := AggregateStops(stopsViewTestLines())
+	if len(stops) != 3 || stops[0].Id != "0001" || stops[2].Id != "0003" {
+		t.Fatalf("Unexpected stops %v", stops)
+	}
+
+	s := stops[1]
+	if s.StationId != "S0002" || s.Location != (Coordinates{"43.2610", "-2.9330"}) || len(s.Services) != 2 {
+		t.Fatalf("Unexpected stop 0002 %v", s)
+	}
+	expected := []StopService{
+		{"I01", "01", 1, "Moyua - Abando", DirectionForward, Timetable{Weekday: "06:31"}, nil},
+		{"I05", "05", 5, "Txurdinaga - Zorrotza", DirectionForward, Timetable{Saturday: "08:00"}, &Names{Eu: "Txurdinaga - Zorrotzaurre"}},
+	}
+	for i, service := range s.Services {
+		if service.LineId != expected[i].LineId || service.Schedule != expected[i].Schedule || service.Name != expected[i].Name ||
+			service.Number != expected[i].Number || service.Direction != expected[i].Direction {
+			t.Errorf("Expected service %v, actual %v", expected[i], service)
+		}
+	}
+	if ids := strings.Join(s.LineIds(), " "); ids != "I01 I05" {
+		t.Errorf("Unexpected lines %v", ids)
+	}
+}
+
+func TestStopsPresenters(t *testing.T) {
+	log.Printf("---------- TestStopsPresenters ------------ ")
+	stops := AggregateStops(stopsViewTestLines())
+	testCases := []struct {
+		presenter Presenter
+		output    string
+		expected  []string
+	}{
+		{JsonPresenter{}, "allstops.json", []string{`"St": "S0002"`, `"Sc": {`, `"Wor": "06:31"`, `"Nm": {`}},
+		{JsonPresenter{Language: LanguageBasque}, "allstops.json", []string{`"Na": "Moyua plaza"`, `"Name": "Txurdinaga - Zorrotzaurre"`}},
+		{JsonPresenter{ConnectionsString: true}, "allstops.json", []string{`"Co": "I01 I05"`, `"Lines": [`}},
+		{GeoJsonPresenter{}, "allstops.geojson", []string{`"type": "Point"`, `"StationId": "S0002"`, `"Sat": "08:00"`}},
+		{KmlPresenter{}, "allstops.kml", []string{`<Data name="Lines">`, `<value>I01,I05</value>`, `<Data name="I05">`, `<value>Saturday: 08:00</value>`}},
+	}
+	for _, tc := range testCases {
+		s, err := tc.presenter.FormatStops(stops)
+		if err != nil || tc.presenter.StopsOutputName() != tc.output {
+			t.Errorf("%T: unexpected error %v or output %v", tc.presenter, err, tc.presenter.StopsOutputName())
+		}
+		for _, expected := range tc.expected {
+			if !strings.Contains(s, expected) {
+				t.Errorf("%T: expected %v in %v", tc.presenter, expected, s)
+			}
+		}
+	}
+
+	// Presenting does not change the stops
+	if stops[1].Names == nil || stops[1].Services[1].Names == nil {
+		t.Errorf("Stops changed by presenter %v", stops[1])
+	}
+}
+
+func TestPublishStops(t *testing.T) {
+	log.Printf("---------- TestPublishStops ------------ ")
+	dir, err := ioutil.TempDir("", "publishstops")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := publishLocally(stopsViewTestLines(), dir, []Presenter{JsonPresenter{}, KmlPresenter{}}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, name := range []string{"alllines.json", "allstops.json", "alllines.kml", "allstops.kml"} {
+		if !Exists(path.Join(dir, name)) {
+			t.Errorf("Expected file %v", name)
+		}
+	}
+	f, _ := ioutil.ReadFile(path.Join(dir, "allstops.json"))
+	var stops []AggregatedStop
+	if err := json.Unmarshal(f, &stops); err != nil || len(stops) != 3 || len(stops[1].Services) != 2 {
+		t.Errorf("Unexpected stops published %v (%v)", stops, err)
+	}
+}
@@ -0,0 +1,178 @@
+package transit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Constants
+const envTransferMaxDistance string = "TRANSFER_MAX_DISTANCE"   // Meters
+const envTransferWalkingSpeed string = "TRANSFER_WALKING_SPEED" // Meters per second
+const envTransferOverrides string = "TRANSFER_OVERRIDES"        // Path to JSON file
+const transfersJsonOutputName string = "transfers.json"
+const transfersGTFSOutputName string = "transfers.txt"
+const gtfsTransferHeader string = "from_stop_id,to_stop_id,transfer_type,min_transfer_time"
+const gtfsTransferTypeMinTime string = "2"
+
+// Streets are not straight lines: walking distance is estimated as the
+// straight line distance times this factor.
+const walkingDetourFactor float64 = 1.25
+
+var DefaultTransferMaxDistance = 250.0
+var DefaultWalkingSpeed = 1.2
+
+// Transfer is a walking connection between stops of different agencies.
+type Transfer struct {
+	FromStopId  string `json:"From"`
+	ToStopId    string `json:"To"`
+	Distance    int    `json:"Dist"` // Meters in straight line
+	WalkingTime int    `json:"Time"` // Seconds
+}
+
+// TransferOverride fixes the transfer between two stops (both ways),
+// for pairs whose estimation is known to be wrong.
+type TransferOverride struct {
+	FromStopId  string
+	ToStopId    string
+	Exclude     bool // No transfer, e.g. a river or a motorway in between
+	WalkingTime int  // Seconds. Replaces the estimation if greater than 0
+}
+
+// TransferConfig tells how transfers are generated.
+type TransferConfig struct {
+	MaxDistance  float64 // Meters
+	WalkingSpeed float64 // Meters per second
+	Overrides    []TransferOverride
+}
+
+// LoadTransferConfig reads the transfer configuration from environment.
+// Defaults are used for the values not defined.
+func LoadTransferConfig() (TransferConfig, error) {
+	config := TransferConfig{
+		MaxDistance:  GetEnvVariableValueFloat(envTransferMaxDistance, DefaultTransferMaxDistance),
+		WalkingSpeed: GetEnvVariableValueFloat(envTransferWalkingSpeed, DefaultWalkingSpeed),
+	}
+
+	overridesPath := os.Getenv(envTransferOverrides)
+	if len(overridesPath) == 0 {
+		return config, nil
+	}
+	f, err := ioutil.ReadFile(overridesPath)
+	if err != nil {
+		log.Printf("Error reading transfer overrides %v. Error: %v ", overridesPath, err)
+		return config, err
+	}
+	if err := json.Unmarshal(f, &config.Overrides); err != nil {
+		return config, fmt.Errorf("Error parsing transfer overrides %v: %v", overridesPath, err)
+	}
+	return config, nil
+}
+
+// BuildTransfers finds the pairs of stops of different agencies within
+// walking distance and estimates the walking time between them. Stop ids
+// are namespaced per agency, as in MergeAgencies. Transfers are sorted by
+// origin and destination stop.
+func BuildTransfers(agencies []Parser, config TransferConfig) []Transfer {
+	if config.MaxDistance <= 0 || config.WalkingSpeed <= 0 {
+		log.Printf("Transfers disabled. Max distance %v, walking speed %v", config.MaxDistance, config.WalkingSpeed)
+		return nil
+	}
+
+	var stops []Stop
+	agencyOf := make(map[string]string)
+	for _, a := range agencies {
+		for _, s := range a.Data().stops {
+			s.Id = NamespaceId(a.Name(), s.Id)
+			agencyOf[s.Id] = a.Name()
+			stops = append(stops, s)
+		}
+	}
+
+	overrides := make(map[string]TransferOverride)
+	for _, o := range config.Overrides {
+		overrides[transferPairKey(o.FromStopId, o.ToStopId)] = o
+	}
+
+	index := NewStopIndex(stops, config.MaxDistance)
+	var transfers []Transfer
+	for _, s := range stops {
+		p, err := s.Location.ToPoint()
+		if err != nil {
+			continue
+		}
+		for _, near := range index.WithinRadius(p, config.MaxDistance) {
+			if agencyOf[near.Stop.Id] == agencyOf[s.Id] {
+				continue
+			}
+			t := Transfer{s.Id, near.Stop.Id, int(math.Round(near.Distance)),
+				estimateWalkingTime(near.Distance, config.WalkingSpeed)}
+			if o, found := overrides[transferPairKey(t.FromStopId, t.ToStopId)]; found {
+				if o.Exclude {
+					continue
+				}
+				if o.WalkingTime > 0 {
+					t.WalkingTime = o.WalkingTime
+				}
+			}
+			transfers = append(transfers, t)
+		}
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].FromStopId != transfers[j].FromStopId {
+			return transfers[i].FromStopId < transfers[j].FromStopId
+		}
+		return transfers[i].ToStopId < transfers[j].ToStopId
+	})
+	return transfers
+}
+
+// FormatTransfersGTFS returns the transfers as a GTFS transfers.txt file.
+func FormatTransfersGTFS(transfers []Transfer) string {
+	var b strings.Builder
+	b.WriteString(gtfsTransferHeader + "\n")
+	for _, t := range transfers {
+		fmt.Fprintf(&b, "%v,%v,%v,%v\n", t.FromStopId, t.ToStopId, gtfsTransferTypeMinTime, t.WalkingTime)
+	}
+	return b.String()
+}
+
+// publishTransfers writes the transfers in destPath, both as JSON
+// and as GTFS transfers.txt.
+func publishTransfers(transfers []Transfer, destPath string) error {
+	log.Printf("Publishing %d transfers locally", len(transfers))
+	if transfers == nil {
+		transfers = make([]Transfer, 0)
+	}
+	b, err := json.Marshal(transfers)
+	if err != nil {
+		log.Printf("Error formatting transfers. Error:%v", err)
+		return err
+	}
+	if err := CreateFile(path.Join(destPath, transfersJsonOutputName), string(b)); err != nil {
+		log.Printf("Error creating file for transfers. Error:%v", err)
+		return err
+	}
+	return CreateFile(path.Join(destPath, transfersGTFSOutputName), FormatTransfersGTFS(transfers))
+}
+
+// estimateWalkingTime returns the seconds to walk a straight line
+// distance (meters) at speed (meters per second).
+func estimateWalkingTime(distance, speed float64) int {
+	return int(math.Ceil(distance * walkingDetourFactor / speed))
+}
+
+// transferPairKey identifies a pair of stops regardless of the order.
+func transferPairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
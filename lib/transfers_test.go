@@ -0,0 +1,85 @@
+package transit
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func transferTestAgencies() []Parser {
+	bilbobus := &Bilbobus{TransitData{stops: []Stop{
+		{Id: "0011", Name: "Moyua", Location: Coordinates{"43.2630", "-2.9350"}},
+		{Id: "0012", Name: "Abando", Location: Coordinates{"43.2610", "-2.9270"}},
+		{Id: "0013", Name: "Moyua 2", Location: Coordinates{"43.2631", "-2.9351"}},
+		{Id: "0014", Name: "Sin localizar"},
+	}}}
+	metro := &GTFSAgency{name: AgencyMetroBilbao, data: TransitData{stops: []Stop{
+		{Id: "MOY", Name: "Moyua", Location: Coordinates{"43.2640", "-2.9350"}},
+		{Id: "ABA", Name: "Abando", Location: Coordinates{"43.2610", "-2.9290"}},
+	}}}
+	return []Parser{bilbobus, metro}
+}
+
+func TestBuildTransfers(t *testing.T) {
+	log.Printf("---------- TestBuildTransfers ------------ ")
+	config := TransferConfig{MaxDistance: 200, WalkingSpeed: 1.25}
+	transfers := BuildTransfers(transferTestAgencies(), config)
+
+	// Stops of the same agency never transfer. Abando stops are 162 m away.
+	expected := []Transfer{
+		{"Bilbobus:0011", "MetroBilbao:MOY", 111, 112},
+		{"Bilbobus:0012", "MetroBilbao:ABA", 162, 162},
+		{"Bilbobus:0013", "MetroBilbao:MOY", 100, 101},
+		{"MetroBilbao:ABA", "Bilbobus:0012", 162, 162},
+		{"MetroBilbao:MOY", "Bilbobus:0011", 111, 112},
+		{"MetroBilbao:MOY", "Bilbobus:0013", 100, 101},
+	}
+	if !reflect.DeepEqual(transfers, expected) {
+		t.Errorf("Expected transfers %v, actual %v", expected, transfers)
+	}
+
+	config.Overrides = []TransferOverride{
+		{FromStopId: "MetroBilbao:ABA", ToStopId: "Bilbobus:0012", Exclude: true},
+		{FromStopId: "Bilbobus:0011", ToStopId: "MetroBilbao:MOY", WalkingTime: 300},
+	}
+	transfers = BuildTransfers(transferTestAgencies(), config)
+	if len(transfers) != 4 || transfers[0].WalkingTime != 300 || transfers[2].WalkingTime != 300 {
+		t.Errorf("Overrides not applied both ways: %v", transfers)
+	}
+
+	if transfers := BuildTransfers(transferTestAgencies(), TransferConfig{}); transfers != nil {
+		t.Errorf("Expected no transfers when disabled, actual %v", transfers)
+	}
+}
+
+func TestFormatTransfersGTFS(t *testing.T) {
+	log.Printf("---------- TestFormatTransfersGTFS ------------ ")
+	actual := FormatTransfersGTFS([]Transfer{{"Bilbobus:0011", "MetroBilbao:MOY", 111, 112}})
+	expected := "from_stop_id,to_stop_id,transfer_type,min_transfer_time\nBilbobus:0011,MetroBilbao:MOY,2,112\n"
+	if actual != expected {
+		t.Errorf("Expected %q, actual %q", expected, actual)
+	}
+}
+
+func TestLoadTransferConfig(t *testing.T) {
+	log.Printf("---------- TestLoadTransferConfig ------------ ")
+	p := "TestLoadTransferConfig.json"
+	defer os.Remove(p)
+	ioutil.WriteFile(p, []byte(`[{"FromStopId":"Bilbobus:0011","ToStopId":"MetroBilbao:MOY","Exclude":true}]`), 0644)
+	os.Setenv(envTransferMaxDistance, "300")
+	os.Setenv(envTransferOverrides, p)
+	defer os.Unsetenv(envTransferMaxDistance)
+	defer os.Unsetenv(envTransferOverrides)
+
+	config, err := LoadTransferConfig()
+	if err != nil || config.MaxDistance != 300 || config.WalkingSpeed != DefaultWalkingSpeed || len(config.Overrides) != 1 || !config.Overrides[0].Exclude {
+		t.Errorf("Unexpected configuration %v (%v)", config, err)
+	}
+
+	ioutil.WriteFile(p, []byte(`{malformed`), 0644)
+	if _, err := LoadTransferConfig(); err == nil {
+		t.Errorf("Expected error with malformed overrides")
+	}
+}
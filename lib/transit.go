@@ -3,7 +3,9 @@
 package transit
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"log"
 	"os"
@@ -28,16 +30,27 @@ const SeasonSummer string = "VE"
 const WeekDayTypeId string = "1"
 const SaturdayTypeId string = "2"
 const SundayTypeId string = "3"
+const MondayToThursdayTypeId string = "4"
+const FridayTypeId string = "5"
 const DirectionForwardNumber string = "1"
 const DirectionBackwardNumber string = "2"
 const EnvNameReuseLocalData string = "REUSE_TRANSIT_LOCAL_FILES"
 const AgencyNameSeparator string = "-"
 const EnvRemoveDuplicatedStopsInLine string = "REMOVE_DUPLICATED_STOPS_IN_LINE"
 
+// Types of day of the timetable
+const DayWeekday string = "Weekday"
+const DayMondayToThursday string = "MondayToThursday"
+const DayFriday string = "Friday"
+const DaySaturday string = "Saturday"
+const DaySunday string = "Sunday"
+const DayFridayNight string = "FridayNight"
+const DaySaturdayNight string = "SaturdayNight"
+const DayHolidayEve string = "HolidayEve"
+
 // Globals
 var Directions = [2]string{DirectionForward, DirectionBackward}
 var DirectionsPrefixes = [2]string{DirectionForwardShortPrefix, DirectionBackwardShortPrefix}
-var LinesIgnored map[string]bool
 
 // Bilbobus is a parser of transit information of Bilbao bus agency.
 type TransitData struct {
@@ -46,6 +59,50 @@ type TransitData struct {
 	dayLines   []Line
 	nightLines []Line
 	stops      []Stop
+	stations   []Station
+
+	remediations []RemediationResult // Of the rules applied while digesting
+}
+
+// Lines returns the lines (all directions).
+func (td TransitData) Lines() []Line {
+	return td.lines
+}
+
+// Line returns the line with the given id (e.g. I01), if found.
+func (td TransitData) Line(id string) (Line, bool) {
+	for _, l := range td.lines {
+		if l.Id == id {
+			return l, true
+		}
+	}
+	return Line{}, false
+}
+
+// Stops returns the stops of the line with agency id lineId (e.g. 01)
+// in the given direction. Nil if the line is unknown.
+func (td TransitData) Stops(lineId string, direction string) []Stop {
+	for _, l := range td.lines {
+		if l.AgencyId == lineId && l.Direction == direction {
+			return l.Stops
+		}
+	}
+	return nil
+}
+
+// Stations returns the stations grouping the stops.
+func (td TransitData) Stations() []Station {
+	return td.stations
+}
+
+// Stop returns the stop with the given id, if found.
+func (td TransitData) Stop(id string) (Stop, bool) {
+	for _, s := range td.stops {
+		if s.Id == id {
+			return s, true
+		}
+	}
+	return Stop{}, false
 }
 
 // Metadata contains meta-information about the data
@@ -73,22 +130,45 @@ type Coordinates struct {
 	Long string `json:"Lo,omitempty"`
 }
 
-// Timetable stores the schedule per type of day.
+// Timetable stores the schedule per type of day. Lines have either Weekday
+// or, when Fridays differ, MondayToThrusday and Friday. Departures are
+// HH:MM separated by commas, in departure order: times lower than the
+// previous ones are past midnight, still of the same service day.
+// Night lines run in the nights starting on Friday, Saturday and eves of
+// holidays, and only have those types of day and MondayToThrusday for the
+// rest of the nights of weekdays.
 type Timetable struct {
 	Weekday          string `json:"Wor,omitempty"`
 	MondayToThrusday string `json:"M2T,omitempty"`
 	Friday           string `json:"Fri,omitempty"`
 	Saturday         string `json:"Sat,omitempty"`
 	Sunday           string `json:"Sun,omitempty"`
+	FridayNight      string `json:"FriN,omitempty"`
+	SaturdayNight    string `json:"SatN,omitempty"`
+	HolidayEve       string `json:"EveN,omitempty"`
 }
 
 // Stop keeps the information of a (bus, metro,...) stop.
 type Stop struct {
 	Id          string      `json:"Id,omitempty"`
 	Name        string      `json:"Na,omitempty"`
-	Connections string      `json:"Co,omitempty"`
+	Connections Connections `json:"Co,omitempty"`
 	Schedule    Timetable   `json:"Sc,omitempty"`
 	Location    Coordinates `json:"Lc,omitempty"`
+	StationId   string      `json:"St,omitempty"`
+	Names       *Names      `json:"Nm,omitempty"`
+
+	// Meters from the start of the map route of the line
+	ShapeDistance int `json:"Sd,omitempty"`
+}
+
+// Station groups the stops that are the same place for the traveller,
+// e.g. both sides of a street (GTFS stop with location_type=1).
+type Station struct {
+	Id       string      `json:"Id,omitempty"`
+	Name     string      `json:"Na,omitempty"`
+	Location Coordinates `json:"Lc,omitempty"`
+	StopIds  []string    `json:"Stops,omitempty"`
 }
 
 // Line represents a line of transport mean. Consists of
@@ -102,27 +182,69 @@ type Line struct {
 	Stops       []Stop        `json:"Stops,omitempty"`
 	MapRoute    []Coordinates `json:"Map,omitempty"`
 	IsNightLine *bool         `json:"Night,omitempty"`
+	Names       *Names        `json:"Nm,omitempty"`
+}
+
+// Names keeps the name of a stop or line in each official language.
+// Empty if the name is the same in all of them.
+type Names struct {
+	Es string `json:"es,omitempty"` // Spanish
+	Eu string `json:"eu,omitempty"` // Basque
 }
 
 // Parse is a type of function that receives a list of Lines and adds
 // new information to that list. For example: a Decorator function might
 // add the location of each stop in the provided list of lines.
-type Parse func(*[]Line, TransitSource) error
+// Parsing stops as soon as possible when ctx is cancelled.
+type Parse func(context.Context, *[]Line, TransitSource) error
 
 // Parser is an interface that must be implemented per transit agency.
-// Exposes Parse method to digest the raw data provided by the agency.
-// Once parsed, the agency information can be queries using the rest of the methods: Lines, Stops, etc.
+// Exposes Digest method to digest the raw data provided by the agency in
+// its sources. Digest goes on when a source fails and returns the errors
+// of all of them (see DigestErrors).
+// Once parsed, the agency information can be queried using the rest of the
+// methods: Data, Lines, Line, Stops, Stop.
 type Parser interface {
-	Digest(dataPath string) error
+	Name() string
+	GetSources() []TransitSource
+	Digest(ctx context.Context, sources []TransitSource) error
+	Data() TransitData
 	Lines() []Line
+	Line(id string) (Line, bool)
 	Stops(lineId string, direction string) []Stop
+	Stop(id string) (Stop, bool)
+}
+
+// DigestErrors aggregates the errors found while digesting the sources
+// of an agency, so one failing source or line does not hide the rest.
+type DigestErrors []error
+
+func (e DigestErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %v", len(e), strings.Join(messages, "; "))
+}
+
+// ErrorOrNil returns nil if there are no errors, so the
+// aggregate can be returned as error.
+func (e DigestErrors) ErrorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
 }
 
 // Presenter is an interface implemented by formatter classes.
-// Exposes methods to transform lines into the presenting format, e.g. JSON
+// Exposes methods to transform lines and stops into the presenting
+// format, e.g. JSON, and the names of the files they are published in.
 type Presenter interface {
 	Format(l Line) (string, error)
 	FormatList(l []Line) (string, error)
+	OutputName() string
+	FormatStops(s []AggregatedStop) (string, error)
+	StopsOutputName() string
 }
 
 // ToDirectionNumber returns the identifier that matches
@@ -201,12 +323,12 @@ func LoadIgnoreLineIds() map[string]bool {
 	return linesIgnored
 }
 
-// IsIgnored checks whether line id should be ignored
-func IsIgnored(id string) bool {
+// IsIgnored checks whether line id is in the list of lines to ignore
+func IsIgnored(linesIgnored map[string]bool, id string) bool {
 
 	ignored := false
-	if LinesIgnored!=nil {
-		_, ignored = LinesIgnored[strings.TrimSpace(id)]
+	if linesIgnored!=nil {
+		_, ignored = linesIgnored[strings.TrimSpace(id)]
 	}
 
 	return ignored
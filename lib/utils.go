@@ -62,12 +62,7 @@ func CRC32(s string) int {
 // IsFileSizeGreaterThanZero returns true if the file
 // p is greater than zero.
 func IsFileSizeGreaterThanZero(p string) bool {
-	fi, e := os.Stat(p)
-	if e != nil {
-		return false
-	}
-	// get the size
-	return fi.Size() > 0
+	return ValidateFileNotEmpty(p) == nil
 }
 
 // ApplyRegexAllSubmatch compile and apply the supplied regular expression over
@@ -106,3 +101,18 @@ func MD5(s string) string {
 	io.WriteString(hash, s)
 	return hex.EncodeToString(hash.Sum(nil))
 }
+
+// GetEnvVariableValueFloat returns the value of the variable as float.
+// If not defined or not a number, returns defaultValue.
+func GetEnvVariableValueFloat(v string, defaultValue float64) float64 {
+	value := os.Getenv(v)
+	if len(value) == 0 {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Env variable %v=%v is not a number. Using %v", v, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
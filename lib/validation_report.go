@@ -0,0 +1,237 @@
+package transit
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Constants
+const validationReportOutputName string = "validation.json"
+const validationPageOutputName string = "validation.html"
+const StatusOk string = "ok"
+
+// LineStatus summarizes the findings of a line.
+type LineStatus struct {
+	Id       string
+	Name     string
+	Status   string // ok, warning or error
+	Errors   int
+	Warnings int
+}
+
+// ValidationDiff tells what changed since the previous validation.
+type ValidationDiff struct {
+	NewFindings      []Finding `json:",omitempty"`
+	ResolvedFindings []Finding `json:",omitempty"`
+	AddedLines       []string  `json:",omitempty"`
+	RemovedLines     []string  `json:",omitempty"`
+}
+
+// AgencyValidation is the validation of the data of an agency: status
+// of every line, findings and remediation rules applied.
+type AgencyValidation struct {
+	Agency       string
+	Lines        []LineStatus
+	Findings     []Finding
+	Remediations []RemediationResult `json:",omitempty"`
+	Diff         *ValidationDiff     `json:",omitempty"` // nil if no previous validation
+}
+
+// ValidationArtifact is the validation report published with the data.
+type ValidationArtifact struct {
+	Generated time.Time
+	Agencies  []AgencyValidation
+}
+
+// NewAgencyValidation validates the data of the agency with the default
+// rules (see DefaultValidationRules).
+func NewAgencyValidation(agency string, td TransitData) AgencyValidation {
+	r := ValidateLines(td.lines, DefaultValidationRules)
+	v := AgencyValidation{Agency: agency, Findings: r.Findings, Remediations: td.remediations}
+	for _, l := range td.lines {
+		status := LineStatus{Id: l.Id, Name: l.Name, Status: StatusOk}
+		for _, f := range r.Findings {
+			if f.LineId != l.Id {
+				continue
+			}
+			if f.Severity == SeverityError {
+				status.Errors++
+				status.Status = SeverityError
+			} else if f.Severity == SeverityWarning {
+				status.Warnings++
+				if status.Status == StatusOk {
+					status.Status = SeverityWarning
+				}
+			}
+		}
+		v.Lines = append(v.Lines, status)
+	}
+	return v
+}
+
+// Report returns the findings as a validation report.
+func (v AgencyValidation) Report() ValidationReport {
+	return ValidationReport{v.Findings}
+}
+
+// HasErrors tells whether any finding has severity error.
+func (v AgencyValidation) HasErrors() bool {
+	return v.Report().Count(SeverityError) > 0
+}
+
+// MissingData returns the findings of stops without schedule or location.
+func (v AgencyValidation) MissingData() []Finding {
+	var missing []Finding
+	for _, f := range v.Findings {
+		if f.Rule == RuleStopNoSchedule || (f.Rule == RuleStopIncomplete && strings.Contains(f.Message, "location")) {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// PublishValidationReport writes the validations in destPath as JSON and
+// as a static HTML page, with the differences from the previous report
+// found in destPath, if any.
+func PublishValidationReport(validations []AgencyValidation, destPath string) error {
+	log.Printf("Publishing validation of %d agencies locally", len(validations))
+	os.MkdirAll(destPath, os.ModePerm)
+
+	previous := make(map[string]AgencyValidation)
+	if f, err := ioutil.ReadFile(path.Join(destPath, validationReportOutputName)); err == nil {
+		var artifact ValidationArtifact
+		if err := json.Unmarshal(f, &artifact); err != nil {
+			log.Printf("Error parsing previous validation report. Error:%v", err)
+		}
+		for _, v := range artifact.Agencies {
+			previous[v.Agency] = v
+		}
+	}
+
+	artifact := ValidationArtifact{Generated: time.Now()}
+	for _, v := range validations {
+		if p, found := previous[v.Agency]; found {
+			diff := diffValidations(p, v)
+			v.Diff = &diff
+		}
+		artifact.Agencies = append(artifact.Agencies, v)
+	}
+
+	b, err := json.MarshalIndent(artifact, "", "    ")
+	if err != nil {
+		log.Printf("Error formatting validation report. Error:%v", err)
+		return err
+	}
+	if err := CreateFile(path.Join(destPath, validationReportOutputName), string(b)); err != nil {
+		log.Printf("Error creating file for validation report. Error:%v", err)
+		return err
+	}
+
+	var page strings.Builder
+	if err := validationPage.Execute(&page, artifact); err != nil {
+		log.Printf("Error formatting validation page. Error:%v", err)
+		return err
+	}
+	return CreateFile(path.Join(destPath, validationPageOutputName), page.String())
+}
+
+// diffValidations returns the changes from previous to current. Findings
+// are the same if they have the same rule, line and stop.
+func diffValidations(previous, current AgencyValidation) ValidationDiff {
+	var d ValidationDiff
+	key := func(f Finding) string { return f.Rule + "|" + f.LineId + "|" + f.StopId }
+	before, after := make(map[string]bool), make(map[string]bool)
+	for _, f := range previous.Findings {
+		before[key(f)] = true
+	}
+	for _, f := range current.Findings {
+		after[key(f)] = true
+		if !before[key(f)] {
+			d.NewFindings = append(d.NewFindings, f)
+		}
+	}
+	for _, f := range previous.Findings {
+		if !after[key(f)] {
+			d.ResolvedFindings = append(d.ResolvedFindings, f)
+		}
+	}
+
+	linesBefore, linesAfter := make(map[string]bool), make(map[string]bool)
+	for _, l := range previous.Lines {
+		linesBefore[l.Id] = true
+	}
+	for _, l := range current.Lines {
+		linesAfter[l.Id] = true
+		if !linesBefore[l.Id] {
+			d.AddedLines = append(d.AddedLines, l.Id)
+		}
+	}
+	for _, l := range previous.Lines {
+		if !linesAfter[l.Id] {
+			d.RemovedLines = append(d.RemovedLines, l.Id)
+		}
+	}
+	return d
+}
+
+var validationPage = template.Must(template.New("validation").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Validation report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.ok { background: #d4edda; }
+.warning, .stale { background: #fff3cd; }
+.error, .failed { background: #f8d7da; }
+</style>
+</head>
+<body>
+<h1>Validation report</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05"}}</p>
+{{range .Agencies}}
+<h2>{{.Agency}}</h2>
+
+<h3>Lines</h3>
+<table>
+<tr><th>Line</th><th>Name</th><th>Status</th><th>Errors</th><th>Warnings</th></tr>
+{{range .Lines}}<tr class="{{.Status}}"><td>{{.Id}}</td><td>{{.Name}}</td><td>{{.Status}}</td><td>{{.Errors}}</td><td>{{.Warnings}}</td></tr>
+{{end}}</table>
+
+<h3>Stops missing schedule or location</h3>
+{{with .MissingData}}<table>
+<tr><th>Line</th><th>Stop</th><th>Issue</th></tr>
+{{range .}}<tr class="{{.Severity}}"><td>{{.LineId}}</td><td>{{.StopId}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+
+<h3>Remediation rules</h3>
+{{with .Remediations}}<table>
+<tr><th>Rule</th><th>Status</th></tr>
+{{range .}}<tr class="{{.Status}}"><td>{{.Rule}}</td><td>{{.Status}} {{.Error}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+
+<h3>Changes since previous run</h3>
+{{with .Diff}}<ul>
+{{range .AddedLines}}<li>Line {{.}} added</li>
+{{end}}{{range .RemovedLines}}<li>Line {{.}} removed</li>
+{{end}}{{range .NewFindings}}<li class="{{.Severity}}">New: [{{.Rule}}] {{.LineId}} {{.StopId}} {{.Message}}</li>
+{{end}}{{range .ResolvedFindings}}<li class="ok">Resolved: [{{.Rule}}] {{.LineId}} {{.StopId}} {{.Message}}</li>
+{{end}}</ul>{{else}}<p>No previous run</p>{{end}}
+
+<h3>All findings</h3>
+{{with .Findings}}<table>
+<tr><th>Severity</th><th>Rule</th><th>Line</th><th>Stop</th><th>Message</th></tr>
+{{range .}}<tr class="{{.Severity}}"><td>{{.Severity}}</td><td>{{.Rule}}</td><td>{{.LineId}}</td><td>{{.StopId}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>None</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,73 @@
+package transit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestNewAgencyValidation(t *testing.T) {
+	log.Printf("---------- TestNewAgencyValidation ------------ ")
+	lines := validationTestLines()
+	lines[0].Stops[1].Schedule = Timetable{}
+	lines[1].Stops[2].Location = Coordinates{}
+	night := true
+	remediations := []RemediationResult{{RemediationRule{Action: RemediationSetNight, Line: "01", Night: &night}, RemediationApplied, ""}}
+	v := NewAgencyValidation("Bilbobus", TransitData{lines: lines, remediations: remediations})
+
+	expected := []LineStatus{{"I01", "Moyua - Abando", SeverityWarning, 0, 1}, {"V01", "Moyua - Abando", SeverityError, 1, 0}}
+	if len(v.Lines) != 2 || v.Lines[0] != expected[0] || v.Lines[1] != expected[1] {
+		t.Errorf("Expected lines %v, actual %v", expected, v.Lines)
+	}
+	if missing := v.MissingData(); len(missing) != 2 || missing[0].StopId != "0002" || missing[1].StopId != "0003" {
+		t.Errorf("Unexpected stops missing data %v", missing)
+	}
+	if !v.HasErrors() || len(v.Remediations) != 1 {
+		t.Errorf("Unexpected validation %v", v)
+	}
+}
+
+func TestPublishValidationReport(t *testing.T) {
+	log.Printf("---------- TestPublishValidationReport ------------ ")
+	destPath := "TestPublishValidationReport"
+	defer os.RemoveAll(destPath)
+
+	lines := validationTestLines()
+	lines[0].Stops[1].Schedule = Timetable{}
+	if err := PublishValidationReport([]AgencyValidation{NewAgencyValidation("Bilbobus", TransitData{lines: lines})}, destPath); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// Next run: schedule fixed, line V01 removed and stop outside Bizkaia
+	lines = validationTestLines()[:1]
+	lines[0].Stops[2].Location = Coordinates{"40.4168", "-3.7038"}
+	if err := PublishValidationReport([]AgencyValidation{NewAgencyValidation("Bilbobus", TransitData{lines: lines})}, destPath); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	f, _ := ioutil.ReadFile(path.Join(destPath, validationReportOutputName))
+	var artifact ValidationArtifact
+	if err := json.Unmarshal(f, &artifact); err != nil || len(artifact.Agencies) != 1 || artifact.Agencies[0].Diff == nil {
+		t.Fatalf("Unexpected validation report %s (%v)", f, err)
+	}
+	d := artifact.Agencies[0].Diff
+	if len(d.RemovedLines) != 1 || d.RemovedLines[0] != "V01" || len(d.AddedLines) != 0 {
+		t.Errorf("Unexpected lines diff %v", d)
+	}
+	// Unknown connection V01, impossible speed and outside Bizkaia
+	if len(d.NewFindings) != 3 || len(d.ResolvedFindings) != 1 || d.ResolvedFindings[0].Rule != RuleStopNoSchedule {
+		t.Errorf("Unexpected findings diff %v", d)
+	}
+
+	page, _ := ioutil.ReadFile(path.Join(destPath, validationPageOutputName))
+	for _, expected := range []string{"<h2>Bilbobus</h2>", `<tr class="error"><td>I01</td>`, "<li>Line V01 removed</li>",
+		"Resolved: [stop-no-schedule] I01 0002"} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("Expected %v in validation page", expected)
+		}
+	}
+}
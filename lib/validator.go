@@ -0,0 +1,88 @@
+package transit
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Validate is a type of function that checks whether the file in path
+// holds what the source is expected to provide. Returns nil if the file
+// is valid, otherwise an error explaining the reason of the rejection.
+type Validate func(path string) error
+
+// Files every GTFS feed has to include.
+var gtfsRequiredFiles = []string{"agency.txt", "stops.txt", "routes.txt", "trips.txt", "stop_times.txt"}
+
+// Files of a GTFS feed that define the service calendar. At least one is required.
+var gtfsCalendarFiles = []string{"calendar.txt", "calendar_dates.txt"}
+
+// ValidateFileNotEmpty rejects files that do not exist or are empty.
+func ValidateFileNotEmpty(p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return fmt.Errorf("Can not stat file %v: %v", p, err)
+	}
+	if fi.Size() == 0 {
+		return fmt.Errorf("File %v is empty", p)
+	}
+	return nil
+}
+
+// ValidateGTFSArchive rejects files that are not a zip archive with
+// the files GTFS requires.
+func ValidateGTFSArchive(p string) error {
+	if err := ValidateFileNotEmpty(p); err != nil {
+		return err
+	}
+
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		return fmt.Errorf("File %v is not a zip archive: %v", p, err)
+	}
+	defer r.Close()
+
+	present := make(map[string]bool)
+	for _, f := range r.File {
+		present[f.Name] = true
+	}
+
+	var missing []string
+	for _, name := range gtfsRequiredFiles {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	if !present[gtfsCalendarFiles[0]] && !present[gtfsCalendarFiles[1]] {
+		missing = append(missing, strings.Join(gtfsCalendarFiles, " or "))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("GTFS archive %v lacks required files: %v", p, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateContentMatches rejects files whose content has no match for
+// pattern. what describes the expected content in the rejection reason.
+func validateContentMatches(p, pattern, what string) error {
+	if err := ValidateFileNotEmpty(p); err != nil {
+		return err
+	}
+
+	f, err := ioutil.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("Can not read file %v: %v", p, err)
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid validation pattern %v: %v", pattern, err)
+	}
+	if !regex.Match(f) {
+		return fmt.Errorf("No %v found in %v (%v bytes). Error or maintenance page?", what, p, len(f))
+	}
+	return nil
+}
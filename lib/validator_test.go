@@ -0,0 +1,83 @@
+package transit
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const maintenancePage = `<html><head><title>Bilbobus</title></head>
+<body><h1>Estamos realizando tareas de mantenimiento</h1></body></html>`
+
+var validatorTestCases = []struct {
+	name           string
+	validate       Validate
+	content        string // content of the file to validate
+	expectedReason string // expected in the error, empty if valid
+}{
+	{"empty file", ValidateFileNotEmpty, "", "is empty"},
+	{"not empty file", ValidateFileNotEmpty, "x", ""},
+	{"lines page", ValidateLinesPage, `<option value="01">01 - Plaza Biribila - Arangoiti</option>`, ""},
+	{"lines maintenance", ValidateLinesPage, maintenancePage, "No line options"},
+	{"stops page", ValidateStopsPage, `<td headers="parada_ida"><span class="num">1</span>Moyua</td>`, ""},
+	{"stops maintenance", ValidateStopsPage, maintenancePage, "No stop rows"},
+	{"schedule page", ValidateSchedulePage, `<a href="horario-estimado?codLinea=01&amp;temporada=IV">06:30</a>`, ""},
+	{"schedule error page", ValidateSchedulePage, `<html><body>Error 500</body></html>`, "No horario-estimado links"},
+	{"gtfs not zip", ValidateGTFSArchive, maintenancePage, "not a zip archive"},
+}
+
+func TestValidators(t *testing.T) {
+	p := "TestValidators.html"
+	defer os.Remove(p)
+	for _, tc := range validatorTestCases {
+		ioutil.WriteFile(p, []byte(tc.content), 0644)
+		err := tc.validate(p)
+		if len(tc.expectedReason) == 0 && err != nil {
+			t.Errorf("%v: unexpected rejection: %v", tc.name, err)
+		}
+		if len(tc.expectedReason) > 0 && (err == nil || !strings.Contains(err.Error(), tc.expectedReason)) {
+			t.Errorf("%v: expected rejection (%v), actual (%v)", tc.name, tc.expectedReason, err)
+		}
+	}
+
+	if err := ValidateFileNotEmpty("unknown/file.html"); err == nil {
+		t.Errorf("Expected rejection of missing file")
+	}
+}
+
+func TestValidateGTFSArchive(t *testing.T) {
+	p := "TestValidateGTFSArchive.zip"
+	defer os.Remove(p)
+
+	complete := []archiveEntry{{"agency.txt", "a", false}, {"stops.txt", "s", false}, {"routes.txt", "r", false},
+		{"trips.txt", "t", false}, {"stop_times.txt", "st", false}, {"calendar_dates.txt", "c", false}}
+	writeZipArchive(p, complete)
+	if err := ValidateGTFSArchive(p); err != nil {
+		t.Errorf("Unexpected rejection of complete GTFS: %v", err)
+	}
+
+	writeZipArchive(p, complete[1:5])
+	err := ValidateGTFSArchive(p)
+	if err == nil || !strings.Contains(err.Error(), "agency.txt, calendar.txt or calendar_dates.txt") {
+		t.Errorf("Expected rejection listing missing files, actual %v", err)
+	}
+}
+
+func TestDownloadRejectionReason(t *testing.T) {
+	source := "TestDownloadRejectionReason_source.html"
+	dest := "TestDownloadRejectionReason_dest.html"
+	defer os.Remove(source)
+	defer os.Remove(dest)
+	ioutil.WriteFile(source, []byte(maintenancePage), 0644)
+
+	retries := MaxDownloadRetries
+	MaxDownloadRetries = 1
+	defer func() { MaxDownloadRetries = retries }()
+
+	wd, _ := os.Getwd()
+	err := Download("file://"+wd+"/"+source, dest, ValidateLinesPage)
+	if err == nil || !strings.Contains(err.Error(), "No line options") {
+		t.Errorf("Expected rejection reason in error, actual %v", err)
+	}
+}
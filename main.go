@@ -1,6 +1,7 @@
 package main
 
 import (
+		"context"
 		"log"
 		"github.com/caveda/qmoves-transit/lib"
 	"os"
@@ -8,35 +9,66 @@ import (
 
 const downloadFolder string = "./download"
 
-var bilboBus transit.Bilbobus
-
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Printf("%v", err)
+			os.Exit(-1)
+		}
+		return
+	}
 	prepare()
 }
 
 func prepare() {
 	setupEnvironment("./setupEnv.sh")
-	sources := bilboBus.GetSources()
-	for _, s := range sources {
-		log.Printf("Sources read: %v", s)
-		if !transit.UseCachedData() || !transit.Exists(s.Path) {
-			transit.Download(s.Uri, s.Path, transit.IsFileSizeGreaterThanZero)
+
+	ctx := context.Background()
+	var digested []transit.Parser
+	var validations []transit.AgencyValidation
+	for _, name := range transit.ConfiguredAgencies() {
+		agency, err := transit.NewAgency(name)
+		if err != nil {
+			log.Printf("Error creating agency: %s", err)
+			os.Exit(-1)
 		}
 
-	}
-	bilboBus.Digest(sources)
+		sources := agency.GetSources()
+		for _, s := range sources {
+			log.Printf("%v sources read: %v", name, s)
+		}
+		if err := agency.Digest(ctx, sources); err != nil {
+			log.Printf("Errors digesting %v: %s", name, err)
+		}
 
-	// Data health analysis
-	report, err := transit.CheckConsistency(bilboBus.Data())
-	if err!=nil {
-		log.Printf("Found consistency errors: %s", err)
-		os.Exit(-1)
+		// Data health analysis
+		validation := transit.NewAgencyValidation(name, agency.Data())
+		log.Print(validation.Report().Text())
+		validations = append(validations, validation)
+		digested = append(digested, agency)
 	}
 
-	log.Printf(report)
+	// Validation report is written even if not publishing, to review it
+	if err := transit.PublishValidationReport(validations, "./gen"); err != nil {
+		log.Printf("Error publishing validation report: %s", err)
+	}
+	for _, v := range validations {
+		if v.HasErrors() {
+			log.Printf("Found consistency errors in %v", v.Agency)
+			os.Exit(-1)
+		}
+	}
 
 	// Publishing
-	transit.Publish(bilboBus.Data(), "./gen", transit.JsonPresenter{})
+	presenters, err := transit.PresentersFromEnv()
+	if err != nil {
+		log.Printf("Error configuring publish formats: %s", err)
+		os.Exit(-1)
+	}
+	if err := transit.PublishAgencies(digested, "./gen", presenters...); err != nil {
+		log.Printf("Error publishing agencies: %s", err)
+		os.Exit(-1)
+	}
 	log.Printf("Ready to serve the transit information")
 }